@@ -13,11 +13,27 @@ import (
 	"payment-gateway/configs/logger"
 	"payment-gateway/db"
 	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
 	"payment-gateway/internal/cache"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/commands"
 	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/inbox"
 	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/keys"
+	"payment-gateway/internal/lock"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/schema"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/sink"
+	"payment-gateway/internal/stream"
+	"payment-gateway/internal/webhook"
 	"payment-gateway/internal/workers"
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
 )
 
 func main() {
@@ -37,23 +53,200 @@ func main() {
 
 	redisClient := cache.InitRedis(ctx, cfg.Redis.Addr, cfg.Redis.Password)
 
+	// the command consumer needs Kafka whichever sink events go to
 	var kafkaProducer kafka.Producer
-	if len(cfg.Kafka.Brokers) > 0 {
-		kafkaProducer = kafka.NewProducer(cfg.Kafka.Brokers[0])
-	} else {
+	if len(cfg.Kafka.Brokers) > 0 && (cfg.Events.Sink == "kafka" || cfg.Commands.Enabled) {
+		kafkaProducer, err = kafka.NewProducer(cfg)
+		if err != nil {
+			logger.Error("Failed to initialize Kafka producer", "error", err)
+			os.Exit(1)
+		}
+	} else if cfg.Events.Sink == "kafka" {
 		logger.Warn("No Kafka brokers configured, Kafka producer will not be initialized")
 	}
 
-	dbHandler := db.NewDBHandler(database)
-	redisCache := cache.NewRedisCache(redisClient)
+	var eventSink sink.EventSink
+	if cfg.Events.Sink == "kafka" {
+		if kafkaProducer != nil {
+			eventSink = kafkaProducer
+		}
+	} else if eventSink, err = sink.New(cfg); err != nil {
+		logger.Error("Failed to initialize event sink", "sink", cfg.Events.Sink, "error", err)
+		os.Exit(1)
+	}
+
+	codec, err := events.CodecByName(cfg.Kafka.Serializer)
+	if err != nil {
+		logger.Error("Invalid Kafka serializer", "error", err)
+		os.Exit(1)
+	}
+	schemas, err := schema.Load(cfg.Kafka.SchemaRegistryDir)
+	if err != nil {
+		logger.Error("Failed to load schema registry", "error", err)
+		os.Exit(1)
+	}
+	keyProvider, err := keys.NewProvider(cfg)
+	if err != nil {
+		logger.Error("Invalid encryption key configuration", "error", err)
+		os.Exit(1)
+	}
+	eventEncoder, err := outbox.NewEncoder(codec, schemas, encryption.New(keyProvider))
+	if err != nil {
+		logger.Error("Schema registry is missing event schemas", "serializer", cfg.Kafka.Serializer, "error", err)
+		os.Exit(1)
+	}
+
+	// status changes go to Kafka through the outbox and reach SSE clients on
+	// every replica through Redis
+	eventHub := stream.NewHub()
+	eventHub.Listen(ctx, redisClient)
+	dbHandler := db.NewDBHandler(database, eventEncoder.StatusMessages(cfg.Kafka.StatusEventsTopic), webhook.StatusEvent, stream.NewPublisher(redisClient).Hook)
+	transactionEvents := db.NewTransactionEventHandler(database)
+
+	redisCache := cache.NewLayeredCache(
+		cache.NewRedisCache(redisClient, cfg.Cache.UserTTL, cfg.Cache.RouteTTL),
+		cfg.Cache.LocalSize,
+		cfg.Cache.LocalTTL,
+		cfg.Cache.NegativeTTL,
+	)
+	redisCache.ListenForInvalidations(ctx)
+	metrics.RegisterCounter("cache_hits_total", "In-process cache hits.", func() float64 {
+		return float64(redisCache.Stats().Hits)
+	})
+	metrics.RegisterCounter("cache_misses_total", "In-process cache misses.", func() float64 {
+		return float64(redisCache.Stats().Misses)
+	})
+	metrics.RegisterCounter("cache_negative_hits_total", "In-process cache hits for empty results.", func() float64 {
+		return float64(redisCache.Stats().NegativeHits)
+	})
+	if err := cache.ListenDBInvalidations(ctx, database, cfg.DB.URL, redisCache); err != nil {
+		logger.Warn("Failed to listen for cache invalidations, relying on TTLs", "error", err)
+	}
 	stripeClient := gateway.Stripe(cfg)
 
-	processor := workers.NewTransactionProcessor(dbHandler, cfg.Workers.Count, stripeClient)
+	var locker lock.Locker
+	switch cfg.Lock.Backend {
+	case "postgres":
+		locker = lock.NewPostgresLocker(database, cfg.Lock.WaitTimeout)
+	default:
+		locker = lock.NewRedisLocker(redisClient, database, cfg.Lock.TTL, cfg.Lock.WaitTimeout)
+	}
+
+	webhookStore := db.NewWebhookHandler(database)
+	webhookDispatcher := webhook.NewDispatcher(
+		webhookStore,
+		webhook.NewClient(cfg.Webhooks.RequestTimeout),
+		cfg.Webhooks.PollInterval,
+		cfg.Webhooks.BatchSize,
+		cfg.Webhooks.MaxAttempts,
+		cfg.Webhooks.RetryBackoff,
+	)
+	webhookDispatcher.Start(ctx)
+
+	processor := workers.NewTransactionProcessor(
+		dbHandler,
+		redisCache,
+		locker,
+		cfg.Workers.Count,
+		cfg.Workers.QueueSize,
+		cfg.Workers.EnqueueTimeout,
+		stripeClient,
+	)
 	processor.Start(ctx)
 
-	gatewayService := services.NewGateway(dbHandler, redisCache, processor, kafkaProducer, cfg)
+	gatewayService := services.NewGateway(dbHandler, redisCache, processor, locker,
+		eventEncoder.CreatedMessages(cfg.Kafka.TransactionsTopic), cfg)
+
+	// messages wait in the outbox table while there is no sink
+	var outboxRelay *outbox.Relay
+	if eventSink != nil {
+		outboxRelay = outbox.NewRelay(
+			db.NewOutboxHandler(database),
+			eventSink,
+			cfg.Outbox.PollInterval,
+			cfg.Outbox.BatchSize,
+			cfg.Outbox.MaxAttempts,
+			cfg.Outbox.RetryBackoff,
+		)
+		outboxRelay.Start(ctx)
+	}
+
+	// other services request payouts by sending commands to Kafka
+	var commandConsumer *commands.Consumer
+	var commandReaders []kafka.Consumer
+	if cfg.Commands.Enabled && kafkaProducer != nil {
+		for _, topic := range []string{cfg.Commands.Topic, cfg.Commands.RetryTopic} {
+			reader, err := kafka.NewConsumer(cfg, topic, cfg.Commands.GroupID)
+			if err != nil {
+				logger.Error("Failed to initialize Kafka consumer", "topic", topic, "error", err)
+				os.Exit(1)
+			}
+			commandReaders = append(commandReaders, reader)
+		}
+		commandConsumer = commands.NewConsumer(
+			commandReaders[0],
+			commandReaders[1],
+			kafkaProducer,
+			gatewayService,
+			cfg.Commands.RetryTopic,
+			cfg.Commands.DeadLetterTopic,
+			cfg.Commands.MaxAttempts,
+			cfg.Commands.RetryBackoff,
+		)
+		commandConsumer.Start(ctx)
+	}
+
+	limiter := ratelimit.NewRedisLimiter(redisClient, cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	ipLimiter := ratelimit.NewRedisLimiter(redisClient, cfg.RateLimit.IPRequestsPerSecond, cfg.RateLimit.IPBurst)
+
+	apiKeyStore := db.NewAPIKeyHandler(database)
+	if cfg.Security.BootstrapAdminKey != "" {
+		if err := auth.EnsureBootstrapKey(ctx, apiKeyStore, cfg.Security.BootstrapAdminKey); err != nil {
+			logger.Error("Failed to create bootstrap admin API key", "error", err)
+			os.Exit(1)
+		}
+	}
+	keyManager := auth.NewKeyService(apiKeyStore)
+
+	callbackGateways := make(map[string]signature.GatewayConfig, len(cfg.Callbacks.Gateways))
+	callbackFields := make(map[string]callback.FieldMapping, len(cfg.Callbacks.Gateways))
+	for name, gw := range cfg.Callbacks.Gateways {
+		scheme, err := signature.SchemeByName(gw.Scheme)
+		if err != nil {
+			logger.Error("Invalid callback signature scheme", "gateway", name, "error", err)
+			os.Exit(1)
+		}
+		if gw.Secret == "" {
+			logger.Warn("No callback signing secret configured, callbacks will be rejected", "gateway", name)
+		}
+		callbackGateways[name] = signature.GatewayConfig{Scheme: scheme, Secret: gw.Secret}
+		callbackFields[name] = callback.FieldMapping{
+			EventID:      gw.EventIDField,
+			Reference:    gw.ReferenceField,
+			Status:       gw.StatusField,
+			ErrorMessage: gw.ErrorMessageField,
+			EventTime:    gw.EventTimeField,
+			Sequence:     gw.SequenceField,
+		}
+	}
+	verifier := signature.NewVerifier(callbackGateways, signature.NewRedisNonceStore(redisClient), cfg.Callbacks.SignatureTolerance)
+
+	callbackParser := callback.NewParser(callbackFields)
 
-	router := api.SetupRouter(dbHandler, gatewayService)
+	callbackInbox := db.NewCallbackInboxHandler(database)
+	inboxProcessor := inbox.NewProcessor(
+		callbackInbox,
+		dbHandler,
+		gatewayService,
+		callbackParser,
+		cfg.Callbacks.InboxPollInterval,
+		cfg.Callbacks.InboxBatchSize,
+		cfg.Callbacks.InboxMaxAttempts,
+		cfg.Callbacks.InboxRetryBackoff,
+	)
+	inboxProcessor.Start(ctx)
+
+	router := api.SetupRouter(dbHandler, gatewayService, limiter, ipLimiter, cfg.RateLimit.ClientIPHeader, keyManager, verifier, callbackParser, callbackInbox, webhookStore, transactionEvents, eventHub)
 
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
@@ -85,9 +278,37 @@ func main() {
 		logger.Error("HTTP server forced to shutdown", "error", err)
 	}
 
+	if commandConsumer != nil {
+		logger.Info("Stopping command consumer...")
+		commandConsumer.Stop()
+		for _, reader := range commandReaders {
+			if err := reader.Close(); err != nil {
+				logger.Error("Error closing Kafka consumer", "error", err)
+			}
+		}
+	}
+
+	logger.Info("Stopping callback inbox processor...")
+	inboxProcessor.Stop()
+
 	logger.Info("Stopping transaction processor...")
 	processor.Stop()
 
+	logger.Info("Stopping webhook dispatcher...")
+	webhookDispatcher.Stop()
+
+	if outboxRelay != nil {
+		logger.Info("Stopping outbox relay...")
+		outboxRelay.Stop()
+	}
+
+	if eventSink != nil && eventSink != kafkaProducer {
+		logger.Info("Closing event sink...")
+		if err := eventSink.Close(); err != nil {
+			logger.Error("Error closing event sink", "error", err)
+		}
+	}
+
 	if kafkaProducer != nil {
 		logger.Info("Closing Kafka producer...")
 		if err := kafkaProducer.Close(); err != nil {
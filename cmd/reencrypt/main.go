@@ -0,0 +1,50 @@
+// Command reencrypt seals the stored encrypted event data under the current
+// encryption key, after a rotation, so the retired keys can be removed from
+// ENCRYPTION_RETIRED_KEYS or the key file. It reads the same environment as
+// the service.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/keys"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/pkg/encryption"
+)
+
+func main() {
+	cfg := envs.Load()
+	logger.Init(cfg.LogLevel)
+
+	batchSize := flag.Int("batch", 500, "messages read per query")
+	dryRun := flag.Bool("dry-run", false, "count and decrypt stale messages without rewriting them")
+	flag.Parse()
+
+	database, err := db.InitDB(cfg.DB.URL)
+	if err != nil {
+		logger.Error("Failed to initialize database", "error", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	keyProvider, err := keys.NewProvider(cfg)
+	if err != nil {
+		logger.Error("Invalid encryption key configuration", "error", err)
+		os.Exit(1)
+	}
+	cipher := encryption.New(keyProvider)
+
+	logger.Info("Re-encrypting outbox messages", "keyID", cipher.KeyID(), "dryRun", *dryRun)
+	stats, err := outbox.Reencrypt(context.Background(), db.NewOutboxHandler(database), cipher, *batchSize, *dryRun)
+	logger.Info("Outbox messages re-encrypted", "scanned", stats.Scanned, "encrypted", stats.Encrypted,
+		"stale", stats.Stale, "rewritten", stats.Rewritten)
+	if err != nil {
+		logger.Error("Re-encryption stopped", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,139 @@
+// Command replay republishes the events of stored transactions, rebuilt from
+// the transactions and their status history, to backfill consumers that
+// missed them. It reads the same environment as the service and publishes to
+// its event sink; every message carries the replay header.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/keys"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/internal/replay"
+	"payment-gateway/internal/schema"
+	"payment-gateway/internal/sink"
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
+)
+
+func main() {
+	cfg := envs.Load()
+	logger.Init(cfg.LogLevel)
+
+	var filter db.ReplayFilter
+	flag.IntVar(&filter.FromID, "from-id", 0, "first transaction ID")
+	flag.IntVar(&filter.ToID, "to-id", 0, "last transaction ID")
+	since := flag.String("since", "", "transactions created at or after this RFC 3339 time")
+	until := flag.String("until", "", "transactions created before this RFC 3339 time")
+	flag.StringVar(&filter.Status, "status", "", "transactions currently in this status")
+	flag.IntVar(&filter.UserID, "user", 0, "transactions of this user")
+	all := flag.Bool("all", false, "replay every transaction, required without any other selection")
+
+	topic := flag.String("topic", "", "publish every event to this topic instead of the configured ones")
+	eventTypes := flag.String("events", "all", `"created", "status" or "all"`)
+	rate := flag.Float64("rate", 100, "messages per second, 0 for no limit")
+	batchSize := flag.Int("batch", 100, "transactions read per query")
+	dryRun := flag.Bool("dry-run", false, "log the messages instead of publishing them")
+	flag.Parse()
+
+	var err error
+	if filter.Since, err = parseTime(*since); err != nil {
+		logger.Error("Invalid -since", "error", err)
+		os.Exit(2)
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		logger.Error("Invalid -until", "error", err)
+		os.Exit(2)
+	}
+	if filter == (db.ReplayFilter{}) && !*all {
+		logger.Error("Select transactions with -from-id, -to-id, -since, -until, -status or -user, or pass -all")
+		os.Exit(2)
+	}
+
+	opts := replay.Options{Filter: filter, Rate: *rate, BatchSize: *batchSize, DryRun: *dryRun}
+	switch *eventTypes {
+	case "all":
+		opts.CreatedTopic, opts.StatusTopic = cfg.Kafka.TransactionsTopic, cfg.Kafka.StatusEventsTopic
+	case "created":
+		opts.CreatedTopic = cfg.Kafka.TransactionsTopic
+	case "status":
+		opts.StatusTopic = cfg.Kafka.StatusEventsTopic
+	default:
+		logger.Error("Invalid -events", "events", *eventTypes)
+		os.Exit(2)
+	}
+	if *topic != "" {
+		if opts.CreatedTopic != "" {
+			opts.CreatedTopic = *topic
+		}
+		if opts.StatusTopic != "" {
+			opts.StatusTopic = *topic
+		}
+	}
+
+	database, err := db.InitDB(cfg.DB.URL)
+	if err != nil {
+		logger.Error("Failed to initialize database", "error", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	codec, err := events.CodecByName(cfg.Kafka.Serializer)
+	if err != nil {
+		logger.Error("Invalid Kafka serializer", "error", err)
+		os.Exit(1)
+	}
+	schemas, err := schema.Load(cfg.Kafka.SchemaRegistryDir)
+	if err != nil {
+		logger.Error("Failed to load schema registry", "error", err)
+		os.Exit(1)
+	}
+	keyProvider, err := keys.NewProvider(cfg)
+	if err != nil {
+		logger.Error("Invalid encryption key configuration", "error", err)
+		os.Exit(1)
+	}
+	encoder, err := outbox.NewEncoder(codec, schemas, encryption.New(keyProvider))
+	if err != nil {
+		logger.Error("Schema registry is missing event schemas", "serializer", cfg.Kafka.Serializer, "error", err)
+		os.Exit(1)
+	}
+
+	var eventSink sink.EventSink
+	if !*dryRun {
+		if eventSink, err = sink.New(cfg); err != nil {
+			logger.Error("Failed to initialize event sink", "sink", cfg.Events.Sink, "error", err)
+			os.Exit(1)
+		}
+		defer eventSink.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Replaying events", "createdTopic", opts.CreatedTopic, "statusTopic", opts.StatusTopic,
+		"rate", opts.Rate, "dryRun", opts.DryRun)
+	stats, err := replay.NewReplayer(db.NewReplayHandler(database), encoder, eventSink).Run(ctx, opts)
+	logger.Info("Events replayed", "transactions", stats.Transactions, "messages", stats.Messages,
+		"withoutHistory", stats.WithoutHistory, "lastTransactionID", stats.LastTransactionID)
+	if err != nil {
+		// -from-id lastTransactionID+1 resumes where it stopped
+		logger.Error("Replay stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,32 @@
+// Command schema-registry serves the event schemas of contract/events over
+// HTTP, a local stand-in for a schema registry.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/internal/schema"
+)
+
+func main() {
+	logger.Init("info")
+
+	dir := flag.String("dir", "contract/events", "schema registry directory")
+	addr := flag.String("addr", ":8081", "listen address")
+	flag.Parse()
+
+	registry, err := schema.Load(*dir)
+	if err != nil {
+		logger.Error("Failed to load schema registry", "dir", *dir, "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Schema registry starting", "addr", *addr, "dir", *dir)
+	if err := http.ListenAndServe(*addr, registry.Handler()); err != nil {
+		logger.Error("Schema registry stopped", "error", err)
+		os.Exit(1)
+	}
+}
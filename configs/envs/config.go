@@ -3,6 +3,9 @@ package envs
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -25,6 +28,33 @@ type Config struct {
 		Password string
 	}
 
+	// Cache configuration
+	Cache struct {
+		UserTTL     time.Duration
+		RouteTTL    time.Duration
+		LocalSize   int
+		LocalTTL    time.Duration
+		NegativeTTL time.Duration
+	}
+
+	// Transaction lock configuration
+	Lock struct {
+		Backend     string // "redis" or "postgres"
+		TTL         time.Duration
+		WaitTimeout time.Duration
+	}
+
+	// Rate limiting configuration
+	RateLimit struct {
+		RequestsPerSecond int
+		Burst             int
+
+		// per client address, checked before authentication
+		IPRequestsPerSecond int
+		IPBurst             int
+		ClientIPHeader      string // set by a trusted proxy, e.g. X-Forwarded-For; the peer address if empty
+	}
+
 	// Logging configuration
 	LogLevel string
 
@@ -32,23 +62,146 @@ type Config struct {
 	Kafka struct {
 		Brokers           []string
 		TransactionsTopic string
+		StatusEventsTopic string
+		Balancer          string // see kafka.NewBalancer
+		Serializer        string // events codec, "json" or "protobuf"
+		SchemaRegistryDir string
+
+		// producer settings, see kafka.NewProducer
+		RequiredAcks string // "all", "one" or "none"
+		Compression  string // "none", "gzip", "snappy", "lz4" or "zstd"
+		NoRetries    bool
+		BatchSize    int
+		BatchTimeout time.Duration
+		WriteTimeout time.Duration
+		ReadTimeout  time.Duration
+		DialTimeout  time.Duration
+
+		TLS struct {
+			Enabled            bool
+			CAFile             string
+			CertFile           string // client certificate, for mutual TLS
+			KeyFile            string
+			InsecureSkipVerify bool
+		}
+
+		SASL struct {
+			Mechanism string // empty, "plain", "scram-sha-256" or "scram-sha-512"
+			Username  string
+			Password  string
+		}
+	}
+
+	// Event sink configuration, where the outbox relay publishes
+	Events struct {
+		Sink     string // "kafka", "nats", "file" or "memory", which only logs
+		FilePath string // JSON lines, "-" for stdout
+
+		NATS struct {
+			URL     string // nats://[user:password@]host:port, tls://... for TLS
+			Timeout time.Duration
+
+			// used when the URL or the server asks for TLS
+			TLS struct {
+				CAFile   string
+				CertFile string // client certificate, for mutual TLS
+				KeyFile  string
+			}
+		}
+	}
+
+	// Outbox relay configuration
+	Outbox struct {
+		PollInterval time.Duration
+		BatchSize    int
+		// MaxAttempts is how often a message may fail before it is parked,
+		// 0 retries it forever
+		MaxAttempts  int
+		RetryBackoff time.Duration
+	}
+
+	// Kafka payment command consumer configuration
+	Commands struct {
+		Enabled         bool
+		Topic           string
+		RetryTopic      string
+		DeadLetterTopic string
+		GroupID         string
+		MaxAttempts     int
+		RetryBackoff    time.Duration
 	}
 
 	// Worker configuration
 	Workers struct {
-		Count int
+		Count          int
+		QueueSize      int
+		EnqueueTimeout time.Duration
 	}
 
 	Retry struct {
 		MaxRetries int
 	}
 
+	// Gateway callback configuration
+	Callbacks struct {
+		SignatureTolerance time.Duration
+		Gateways           map[string]CallbackGateway // keyed by lowercased gateway name
+
+		// callback inbox processing
+		InboxPollInterval time.Duration
+		InboxBatchSize    int
+		InboxMaxAttempts  int
+		InboxRetryBackoff time.Duration
+	}
+
+	// Outgoing merchant webhook configuration
+	Webhooks struct {
+		PollInterval   time.Duration
+		BatchSize      int
+		MaxAttempts    int
+		RetryBackoff   time.Duration
+		RequestTimeout time.Duration
+	}
+
 	// Security configuration
 	Security struct {
-		EncryptionKey string
+		KeyProvider       string // "local" or "vault"
+		EncryptionKey     string // base64, 32 bytes
+		EncryptionKeyID   string // written into ciphertexts, change it with the key
+		RetiredKeys       string // id:base64,... still decrypted after rotation
+		KeyFile           string // JSON key set, replaces the three above
+		Vault             VaultConfig
+		BootstrapAdminKey string
 	}
 }
 
+// VaultConfig locates the Vault transit key that wraps data keys.
+type VaultConfig struct {
+	Addr       string
+	Token      string
+	TransitKey string
+	Timeout    time.Duration
+}
+
+type CallbackGateway struct {
+	Scheme string // signature scheme, see internal/signature
+	Secret string
+
+	// payload field names, empty means the CallbackRequest default
+	EventIDField      string
+	ReferenceField    string
+	StatusField       string
+	ErrorMessageField string
+	EventTimeField    string
+	SequenceField     string
+}
+
+// defaultCallbackFields are the payload field names of gateways that don't
+// use our CallbackRequest schema, overridable with CALLBACK_FIELDS_<NAME>.
+var defaultCallbackFields = map[string]string{
+	"adyen": "reference=pspReference,status=status,error=reason,time=eventDate",
+}
+
 func Load() *Config {
 	cfg := &Config{}
 
@@ -67,19 +220,147 @@ func Load() *Config {
 	cfg.Redis.Addr = getEnv("REDIS_ADDR", "redis:6379")
 	cfg.Redis.Password = getEnv("REDIS_PASSWORD", "password")
 
+	// Cache configuration
+	cfg.Cache.UserTTL = getEnvAsDuration("CACHE_USER_TTL", 10*time.Minute)
+	cfg.Cache.RouteTTL = getEnvAsDuration("CACHE_ROUTE_TTL", 5*time.Minute)
+	cfg.Cache.LocalSize = getEnvAsInt("CACHE_LOCAL_SIZE", 1000)
+	cfg.Cache.LocalTTL = getEnvAsDuration("CACHE_LOCAL_TTL", 30*time.Second)
+	cfg.Cache.NegativeTTL = getEnvAsDuration("CACHE_NEGATIVE_TTL", 10*time.Second)
+
+	// Transaction lock configuration
+	cfg.Lock.Backend = getEnv("LOCK_BACKEND", "redis")
+	cfg.Lock.TTL = getEnvAsDuration("LOCK_TTL", 30*time.Second)
+	cfg.Lock.WaitTimeout = getEnvAsDuration("LOCK_WAIT_TIMEOUT", 5*time.Second)
+
+	// Rate limiting configuration
+	cfg.RateLimit.RequestsPerSecond = getEnvAsInt("RATE_LIMIT_RPS", 10)
+	cfg.RateLimit.Burst = getEnvAsInt("RATE_LIMIT_BURST", 20)
+	cfg.RateLimit.IPRequestsPerSecond = getEnvAsInt("RATE_LIMIT_IP_RPS", 50)
+	cfg.RateLimit.IPBurst = getEnvAsInt("RATE_LIMIT_IP_BURST", 100)
+	cfg.RateLimit.ClientIPHeader = getEnv("RATE_LIMIT_CLIENT_IP_HEADER", "")
+
 	// Logging configuration
 	cfg.LogLevel = getEnv("LOG_LEVEL", "info")
 
 	// Kafka configuration
-	kafkaBroker := getEnv("KAFKA_BROKER", "kafka:9092")
-	cfg.Kafka.Brokers = []string{kafkaBroker}
+	// KAFKA_BROKER is the single-broker setting of older deployments
+	for _, broker := range strings.Split(getEnv("KAFKA_BROKER_URL", getEnv("KAFKA_BROKER", "kafka:9092")), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			cfg.Kafka.Brokers = append(cfg.Kafka.Brokers, broker)
+		}
+	}
 	cfg.Kafka.TransactionsTopic = getEnv("KAFKA_TRANSACTIONS_TOPIC", "payment-transactions")
+	cfg.Kafka.StatusEventsTopic = getEnv("KAFKA_STATUS_EVENTS_TOPIC", "payment-transaction-events")
+	cfg.Kafka.Balancer = getEnv("KAFKA_BALANCER", "hash")
+	cfg.Kafka.Serializer = getEnv("KAFKA_SERIALIZER", "json")
+	cfg.Kafka.SchemaRegistryDir = getEnv("SCHEMA_REGISTRY_DIR", "contract/events")
+	cfg.Kafka.RequiredAcks = getEnv("KAFKA_REQUIRED_ACKS", "all")
+	cfg.Kafka.Compression = getEnv("KAFKA_COMPRESSION", "none")
+	// KAFKA_IDEMPOTENT is the old, misleading name of KAFKA_NO_RETRIES
+	cfg.Kafka.NoRetries = getEnvAsBool("KAFKA_NO_RETRIES", getEnvAsBool("KAFKA_IDEMPOTENT", true))
+	cfg.Kafka.BatchSize = getEnvAsInt("KAFKA_BATCH_SIZE", 100)
+	cfg.Kafka.BatchTimeout = getEnvAsDuration("KAFKA_BATCH_TIMEOUT", 10*time.Millisecond)
+	cfg.Kafka.WriteTimeout = getEnvAsDuration("KAFKA_WRITE_TIMEOUT", 10*time.Second)
+	cfg.Kafka.ReadTimeout = getEnvAsDuration("KAFKA_READ_TIMEOUT", 10*time.Second)
+	cfg.Kafka.DialTimeout = getEnvAsDuration("KAFKA_DIAL_TIMEOUT", 5*time.Second)
+	cfg.Kafka.TLS.Enabled = getEnvAsBool("KAFKA_TLS_ENABLED", false)
+	cfg.Kafka.TLS.CAFile = getEnv("KAFKA_TLS_CA_FILE", "")
+	cfg.Kafka.TLS.CertFile = getEnv("KAFKA_TLS_CERT_FILE", "")
+	cfg.Kafka.TLS.KeyFile = getEnv("KAFKA_TLS_KEY_FILE", "")
+	cfg.Kafka.TLS.InsecureSkipVerify = getEnvAsBool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false)
+	cfg.Kafka.SASL.Mechanism = getEnv("KAFKA_SASL_MECHANISM", "")
+	cfg.Kafka.SASL.Username = getEnv("KAFKA_SASL_USERNAME", "")
+	cfg.Kafka.SASL.Password = getEnv("KAFKA_SASL_PASSWORD", "")
+
+	// Event sink configuration
+	cfg.Events.Sink = getEnv("EVENT_SINK", "kafka")
+	cfg.Events.FilePath = getEnv("EVENT_SINK_FILE", "events.jsonl")
+	cfg.Events.NATS.URL = getEnv("NATS_URL", "nats://localhost:4222")
+	cfg.Events.NATS.Timeout = getEnvAsDuration("NATS_TIMEOUT", 5*time.Second)
+	cfg.Events.NATS.TLS.CAFile = getEnv("NATS_TLS_CA_FILE", "")
+	cfg.Events.NATS.TLS.CertFile = getEnv("NATS_TLS_CERT_FILE", "")
+	cfg.Events.NATS.TLS.KeyFile = getEnv("NATS_TLS_KEY_FILE", "")
+
+	// Outbox relay configuration
+	cfg.Outbox.PollInterval = getEnvAsDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond)
+	cfg.Outbox.BatchSize = getEnvAsInt("OUTBOX_BATCH_SIZE", 100)
+	cfg.Outbox.MaxAttempts = getEnvAsInt("OUTBOX_MAX_ATTEMPTS", 50)
+	cfg.Outbox.RetryBackoff = getEnvAsDuration("OUTBOX_RETRY_BACKOFF", time.Second)
+
+	// Kafka payment command consumer configuration
+	cfg.Commands.Enabled = getEnvAsBool("KAFKA_COMMANDS_ENABLED", false)
+	cfg.Commands.Topic = getEnv("KAFKA_COMMANDS_TOPIC", "payment-commands")
+	cfg.Commands.RetryTopic = getEnv("KAFKA_COMMANDS_RETRY_TOPIC", "payment-commands-retry")
+	cfg.Commands.DeadLetterTopic = getEnv("KAFKA_COMMANDS_DLQ_TOPIC", "payment-commands-dlq")
+	cfg.Commands.GroupID = getEnv("KAFKA_COMMANDS_GROUP_ID", "payment-gateway")
+	cfg.Commands.MaxAttempts = getEnvAsInt("KAFKA_COMMANDS_MAX_ATTEMPTS", 5)
+	cfg.Commands.RetryBackoff = getEnvAsDuration("KAFKA_COMMANDS_RETRY_BACKOFF", 5*time.Second)
 
 	// Worker configuration
 	cfg.Workers.Count = 5
+	cfg.Workers.QueueSize = getEnvAsInt("WORKER_QUEUE_SIZE", 100)
+	cfg.Workers.EnqueueTimeout = getEnvAsDuration("WORKER_ENQUEUE_TIMEOUT", 100*time.Millisecond)
 
 	cfg.Retry.MaxRetries = 3
 
+	// Gateway callback configuration
+	cfg.Callbacks.SignatureTolerance = getEnvAsDuration("CALLBACK_SIGNATURE_TOLERANCE", 5*time.Minute)
+	cfg.Callbacks.InboxPollInterval = getEnvAsDuration("CALLBACK_INBOX_POLL_INTERVAL", time.Second)
+	cfg.Callbacks.InboxBatchSize = getEnvAsInt("CALLBACK_INBOX_BATCH_SIZE", 50)
+	cfg.Callbacks.InboxMaxAttempts = getEnvAsInt("CALLBACK_INBOX_MAX_ATTEMPTS", 8)
+	cfg.Callbacks.InboxRetryBackoff = getEnvAsDuration("CALLBACK_INBOX_RETRY_BACKOFF", 2*time.Second)
+	cfg.Callbacks.Gateways = map[string]CallbackGateway{}
+	for _, pair := range strings.Split(getEnv("CALLBACK_GATEWAYS", "stripe=stripe,paypal=paypal,adyen=adyen"), ",") {
+		name, scheme, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if name == "" {
+			continue
+		}
+		gateway := CallbackGateway{
+			Scheme: scheme,
+			Secret: getEnv("CALLBACK_SECRET_"+strings.ToUpper(name), ""),
+		}
+
+		fields := getEnv("CALLBACK_FIELDS_"+strings.ToUpper(name), defaultCallbackFields[strings.ToLower(name)])
+		for _, field := range strings.Split(fields, ",") {
+			kind, fieldName, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch kind {
+			case "event":
+				gateway.EventIDField = fieldName
+			case "reference":
+				gateway.ReferenceField = fieldName
+			case "status":
+				gateway.StatusField = fieldName
+			case "error":
+				gateway.ErrorMessageField = fieldName
+			case "time":
+				gateway.EventTimeField = fieldName
+			case "sequence":
+				gateway.SequenceField = fieldName
+			}
+		}
+
+		cfg.Callbacks.Gateways[strings.ToLower(name)] = gateway
+	}
+
+	// Outgoing merchant webhook configuration
+	cfg.Webhooks.PollInterval = getEnvAsDuration("WEBHOOK_POLL_INTERVAL", time.Second)
+	cfg.Webhooks.BatchSize = getEnvAsInt("WEBHOOK_BATCH_SIZE", 50)
+	cfg.Webhooks.MaxAttempts = getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 10)
+	cfg.Webhooks.RetryBackoff = getEnvAsDuration("WEBHOOK_RETRY_BACKOFF", 5*time.Second)
+	cfg.Webhooks.RequestTimeout = getEnvAsDuration("WEBHOOK_REQUEST_TIMEOUT", 10*time.Second)
+
+	// Security configuration
+	cfg.Security.KeyProvider = getEnv("ENCRYPTION_KEY_PROVIDER", "local")
+	cfg.Security.EncryptionKey = getEnv("ENCRYPTION_KEY", "")
+	cfg.Security.EncryptionKeyID = getEnv("ENCRYPTION_KEY_ID", "v1")
+	cfg.Security.RetiredKeys = getEnv("ENCRYPTION_RETIRED_KEYS", "")
+	cfg.Security.KeyFile = getEnv("ENCRYPTION_KEY_FILE", "")
+	cfg.Security.Vault.Addr = getEnv("VAULT_ADDR", "http://localhost:8200")
+	cfg.Security.Vault.Token = getEnv("VAULT_TOKEN", "")
+	cfg.Security.Vault.TransitKey = getEnv("VAULT_TRANSIT_KEY", "payment-gateway")
+	cfg.Security.Vault.Timeout = getEnvAsDuration("VAULT_TIMEOUT", 5*time.Second)
+	cfg.Security.BootstrapAdminKey = getEnv("BOOTSTRAP_ADMIN_API_KEY", "")
+
 	return cfg
 }
 
@@ -90,3 +371,27 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvAsInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
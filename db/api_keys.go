@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKey struct {
+	ID         int
+	Name       string
+	KeyHash    string
+	Scopes     []string
+	UserID     *int // when set, the key may only act on behalf of this user
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+	RotatedTo  *int
+	CreatedAt  time.Time
+}
+
+type APIKeyStorage interface {
+	CreateAPIKey(ctx context.Context, key APIKey) (int, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id int) (APIKey, error)
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+	RevokeAPIKey(ctx context.Context, id int) error
+	RotateAPIKey(ctx context.Context, oldID int, newKey APIKey, oldExpiresAt time.Time) (int, error)
+}
+
+func NewAPIKeyHandler(db *sql.DB) APIKeyStorage {
+	return &Postgres{db: db}
+}
+
+const apiKeyColumns = `id, name, key_hash, scopes, user_id, expires_at, revoked_at, last_used_at, rotated_to, created_at`
+
+func (p *Postgres) CreateAPIKey(ctx context.Context, key APIKey) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	id, err := createAPIKey(ctx, tx, key)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return id, nil
+}
+
+func (p *Postgres) GetAPIKeyByHash(ctx context.Context, keyHash string) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	return scanAPIKey(p.db.QueryRowContext(ctx, query, keyHash))
+}
+
+func (p *Postgres) GetAPIKeyByID(ctx context.Context, id int) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+	return scanAPIKey(p.db.QueryRowContext(ctx, query, id))
+}
+
+// TouchAPIKey records key usage. Updates are throttled to one per minute per
+// key so that busy keys don't turn every request into a write.
+func (p *Postgres) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $1
+		WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $1 - INTERVAL '1 minute')
+	`
+
+	if _, err := p.db.ExecContext(ctx, query, usedAt, id); err != nil {
+		return fmt.Errorf("failed to update api key usage: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) RevokeAPIKey(ctx context.Context, id int) error {
+	query := `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+
+	res, err := p.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %v", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// RotateAPIKey creates newKey and lets the old key live until oldExpiresAt,
+// giving clients a grace period to switch over.
+func (p *Postgres) RotateAPIKey(ctx context.Context, oldID int, newKey APIKey, oldExpiresAt time.Time) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	newID, err := createAPIKey(ctx, tx, newKey)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	query := `
+		UPDATE api_keys
+		SET rotated_to = $1, expires_at = LEAST(COALESCE(expires_at, $2), $2)
+		WHERE id = $3 AND revoked_at IS NULL AND rotated_to IS NULL
+	`
+
+	res, err := tx.ExecContext(ctx, query, newID, oldExpiresAt, oldID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to rotate api key: %v", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return 0, ErrAPIKeyNotFound
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return newID, nil
+}
+
+func createAPIKey(ctx context.Context, tx *sql.Tx, key APIKey) (int, error) {
+	query := `
+		INSERT INTO api_keys (name, key_hash, scopes, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+
+	var id int
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		key.Name,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.UserID,
+		key.ExpiresAt,
+		time.Now(),
+	).Scan(&id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create api key: %v", err)
+	}
+
+	return id, nil
+}
+
+func scanAPIKey(row *sql.Row) (APIKey, error) {
+	var key APIKey
+	var userID, rotatedTo sql.NullInt64
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID, &key.Name, &key.KeyHash, pq.Array(&key.Scopes), &userID,
+		&expiresAt, &revokedAt, &lastUsedAt, &rotatedTo, &key.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrAPIKeyNotFound
+		}
+		return APIKey{}, fmt.Errorf("failed to get api key: %v", err)
+	}
+
+	if userID.Valid {
+		id := int(userID.Int64)
+		key.UserID = &id
+	}
+
+	if rotatedTo.Valid {
+		id := int(rotatedTo.Int64)
+		key.RotatedTo = &id
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return key, nil
+}
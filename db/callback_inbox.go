@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrCallbackNotFound = errors.New("callback not found")
+
+const (
+	InboxStatusPending   = "pending"
+	InboxStatusProcessed = "processed"
+	InboxStatusDead      = "dead" // gave up, needs manual review
+)
+
+// InboxCallback is a raw gateway callback waiting in (or done with) the inbox.
+type InboxCallback struct {
+	ID            int
+	GatewayID     int
+	GatewayName   string
+	EventID       string
+	TransactionID *int // set when the callback route named the transaction
+	ContentType   string
+	Body          []byte
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	ReceivedAt    time.Time
+	ProcessedAt   *time.Time
+}
+
+type CallbackInboxStorage interface {
+	// EnqueueCallback stores a callback and reports whether the gateway
+	// already delivered an event with the same ID.
+	EnqueueCallback(ctx context.Context, cb InboxCallback) (id int, duplicate bool, err error)
+	// ClaimCallbacks returns up to limit due callbacks and hides them from
+	// other claimers for the visibility timeout.
+	ClaimCallbacks(ctx context.Context, limit int, visibility time.Duration) ([]InboxCallback, error)
+	MarkCallbackProcessed(ctx context.Context, id int, note string) error
+	MarkCallbackFailed(ctx context.Context, id int, errorMsg string, nextAttemptAt time.Time, dead bool) error
+	ListCallbacks(ctx context.Context, status string, limit int) ([]InboxCallback, error)
+	// RequeueCallback puts a dead callback back in the queue.
+	RequeueCallback(ctx context.Context, id int) error
+}
+
+func NewCallbackInboxHandler(db *sql.DB) CallbackInboxStorage {
+	return &Postgres{db: db}
+}
+
+const inboxColumns = `ci.id, ci.gateway_id, g.name, ci.event_id, ci.transaction_id, ci.content_type, ci.body,
+	ci.status, ci.attempts, ci.last_error, ci.next_attempt_at, ci.received_at, ci.processed_at`
+
+func (p *Postgres) EnqueueCallback(ctx context.Context, cb InboxCallback) (int, bool, error) {
+	query := `
+		INSERT INTO callback_inbox (gateway_id, event_id, transaction_id, content_type, body, status, next_attempt_at, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		ON CONFLICT (gateway_id, event_id) DO NOTHING
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRowContext(ctx, query,
+		cb.GatewayID, cb.EventID, cb.TransactionID, cb.ContentType, cb.Body, InboxStatusPending, time.Now(),
+	).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to enqueue callback: %v", err)
+	}
+
+	return id, false, nil
+}
+
+func (p *Postgres) ClaimCallbacks(ctx context.Context, limit int, visibility time.Duration) ([]InboxCallback, error) {
+	// SKIP LOCKED lets several replicas poll the inbox without handing out
+	// the same callback twice
+	query := `
+		UPDATE callback_inbox ci
+		SET attempts = ci.attempts + 1, next_attempt_at = $2
+		FROM gateways g
+		WHERE g.id = ci.gateway_id AND ci.id IN (
+			SELECT id FROM callback_inbox
+			WHERE status = $3 AND next_attempt_at <= $4
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + inboxColumns
+
+	now := time.Now()
+	rows, err := p.db.QueryContext(ctx, query, limit, now.Add(visibility), InboxStatusPending, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim callbacks: %v", err)
+	}
+	defer rows.Close()
+
+	return scanInboxCallbacks(rows)
+}
+
+func (p *Postgres) MarkCallbackProcessed(ctx context.Context, id int, note string) error {
+	query := `
+		UPDATE callback_inbox
+		SET status = $1, last_error = NULLIF($2, ''), processed_at = $3
+		WHERE id = $4
+	`
+
+	_, err := p.db.ExecContext(ctx, query, InboxStatusProcessed, note, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark callback processed: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) MarkCallbackFailed(ctx context.Context, id int, errorMsg string, nextAttemptAt time.Time, dead bool) error {
+	status := InboxStatusPending
+	if dead {
+		status = InboxStatusDead
+	}
+
+	query := `
+		UPDATE callback_inbox
+		SET status = $1, last_error = $2, next_attempt_at = $3
+		WHERE id = $4
+	`
+
+	_, err := p.db.ExecContext(ctx, query, status, errorMsg, nextAttemptAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark callback failed: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) ListCallbacks(ctx context.Context, status string, limit int) ([]InboxCallback, error) {
+	query := `
+		SELECT ` + inboxColumns + `
+		FROM callback_inbox ci
+		JOIN gateways g ON g.id = ci.gateway_id
+		WHERE ci.status = $1
+		ORDER BY ci.id DESC
+		LIMIT $2
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list callbacks: %v", err)
+	}
+	defer rows.Close()
+
+	return scanInboxCallbacks(rows)
+}
+
+func (p *Postgres) RequeueCallback(ctx context.Context, id int) error {
+	query := `
+		UPDATE callback_inbox
+		SET status = $1, attempts = 0, next_attempt_at = $2
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := p.db.ExecContext(ctx, query, InboxStatusPending, time.Now(), id, InboxStatusDead)
+	if err != nil {
+		return fmt.Errorf("failed to requeue callback: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrCallbackNotFound
+	}
+
+	return nil
+}
+
+func scanInboxCallbacks(rows *sql.Rows) ([]InboxCallback, error) {
+	var callbacks []InboxCallback
+	for rows.Next() {
+		var cb InboxCallback
+		var transactionID sql.NullInt64
+		var lastError sql.NullString
+		var processedAt sql.NullTime
+
+		err := rows.Scan(
+			&cb.ID, &cb.GatewayID, &cb.GatewayName, &cb.EventID, &transactionID, &cb.ContentType, &cb.Body,
+			&cb.Status, &cb.Attempts, &lastError, &cb.NextAttemptAt, &cb.ReceivedAt, &processedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan callback: %v", err)
+		}
+
+		if transactionID.Valid {
+			id := int(transactionID.Int64)
+			cb.TransactionID = &id
+		}
+		if lastError.Valid {
+			cb.LastError = lastError.String
+		}
+		if processedAt.Valid {
+			cb.ProcessedAt = &processedAt.Time
+		}
+
+		callbacks = append(callbacks, cb)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating callbacks: %v", err)
+	}
+
+	return callbacks, nil
+}
@@ -14,6 +14,20 @@ import (
 
 var db *sql.DB
 
+// ErrStaleFencingToken is returned when a write carries an older fencing token
+// than the last one applied to the transaction, i.e. the writer's lock expired.
+var ErrStaleFencingToken = errors.New("stale fencing token")
+
+// ErrTransactionNotPending is returned by FailPendingTransaction when the
+// transaction has already left pending.
+var ErrTransactionNotPending = errors.New("transaction is no longer pending")
+
+var ErrStatusMappingNotFound = errors.New("gateway status mapping not found")
+
+// ErrDuplicateCommand is returned by CreateTransaction when a transaction
+// with the same CommandID already exists.
+var ErrDuplicateCommand = errors.New("command already processed")
+
 type User struct {
 	ID        int
 	Username  string
@@ -49,26 +63,95 @@ type Transaction struct {
 	GatewayID    int
 	GatewayTxnID string
 	ErrorMessage string
+	ReasonCode   string // why the gateway status mapped the way it did, see gateway_status_mappings
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	CompletedAt  *time.Time
+	CommandID    string // set for transactions requested by a Kafka command, see internal/commands
+
+	// position of the last applied gateway callback, used to drop late ones
+	LastEventAt       *time.Time
+	LastEventSequence int64
+}
+
+// StatusMapping translates a gateway's callback status into ours.
+type StatusMapping struct {
+	GatewayID      int
+	GatewayStatus  string
+	InternalStatus string
+	ReasonCode     string
+}
+
+// CallbackUpdate is a status change reported by a gateway callback. Zero
+// EventAt or EventSequence mean the gateway didn't send one.
+type CallbackUpdate struct {
+	TransactionID int
+	Status        string
+	GatewayTxnID  string
+	ErrorMessage  string
+	ReasonCode    string
+	EventAt       time.Time
+	EventSequence int64
+}
+
+// ReconciliationAlert flags a transaction whose gateway reported a final
+// status that contradicts the one we already have.
+type ReconciliationAlert struct {
+	ID             int
+	TransactionID  int
+	GatewayID      int
+	CurrentStatus  string
+	ReportedStatus string
+	Details        string
+	CreatedAt      time.Time
+	ResolvedAt     *time.Time
+}
+
+// SecurityEvent records suspicious input, e.g. a callback that doesn't match
+// the transaction it claims to be about.
+type SecurityEvent struct {
+	ID            int
+	Type          string
+	TransactionID int
+	GatewayID     int
+	Details       string
+	CreatedAt     time.Time
 }
 
 type Storage interface {
 	GetUserByID(ctx context.Context, id int) (User, error)
-	UpdateTransactionStatus(ctx context.Context, txID int, status, gatewayTxnID, errorMsg string) error
-	CreateTransaction(ctx context.Context, tx Transaction) (int, error)
+	UpdateTransactionStatus(ctx context.Context, txID int, status, gatewayTxnID, errorMsg, reasonCode string, fencingToken int64) error
+	ApplyCallback(ctx context.Context, update CallbackUpdate, fencingToken int64) error
+	// FailPendingTransaction fails a transaction nobody has started on,
+	// without the transaction lock.
+	FailPendingTransaction(ctx context.Context, txID int, errorMsg, reasonCode string) error
+	// CreateTransaction inserts tx and, when message is not nil, its outbox
+	// message in the same database transaction.
+	CreateTransaction(ctx context.Context, tx Transaction, message OutboxMessageFunc) (int, error)
 	GetTransactionByID(ctx context.Context, id int) (Transaction, error)
+	GetTransactionByGatewayTxnID(ctx context.Context, gatewayID int, gatewayTxnID string) (Transaction, error)
 	GetGatewaysByCountry(ctx context.Context, countryID int) ([]Gateway, error)
-	UpdateTransactionGateway(ctx context.Context, txID int, gatewayID int) error
+	GetGatewayByID(ctx context.Context, id int) (Gateway, error)
+	GetGatewayByName(ctx context.Context, name string) (Gateway, error)
+	GetStatusMapping(ctx context.Context, gatewayID int, gatewayStatus string) (StatusMapping, error)
+	UpdateTransactionGateway(ctx context.Context, txID int, gatewayID int, fencingToken int64) error
+	RecordSecurityEvent(ctx context.Context, event SecurityEvent) error
+	CreateReconciliationAlert(ctx context.Context, alert ReconciliationAlert) error
 }
 
 type Postgres struct {
-	db *sql.DB
+	db            *sql.DB
+	statusMessage OutboxEventFunc
+	webhookEvent  WebhookEventFunc
+	hooks         []EventHook
 }
 
-func NewDBHandler(db *sql.DB) Storage {
-	return &Postgres{db: db}
+// NewDBHandler builds the Storage. statusMessage and webhookEvent, if not
+// nil, build the outbox message and the webhook event written with every
+// status change; hooks are called with every committed one. See
+// TransactionEvent.
+func NewDBHandler(db *sql.DB, statusMessage OutboxEventFunc, webhookEvent WebhookEventFunc, hooks ...EventHook) Storage {
+	return &Postgres{db: db, statusMessage: statusMessage, webhookEvent: webhookEvent, hooks: hooks}
 }
 
 func InitDB(dataSourceName string) (*sql.DB, error) {
@@ -88,16 +171,23 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 	return db, nil
 }
 
-func (p *Postgres) CreateTransaction(ctx context.Context, tx Transaction) (int, error) {
+func (p *Postgres) CreateTransaction(ctx context.Context, tx Transaction, message OutboxMessageFunc) (int, error) {
+	dbTx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
 	query := `
 		INSERT INTO transactions 
-		(user_id, amount, currency, type, status, gateway_id, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+		(user_id, amount, currency, type, status, gateway_id, created_at, updated_at, command_id) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')) 
+		ON CONFLICT (command_id) DO NOTHING
 		RETURNING id
 	`
 
 	var id int
-	err := p.db.QueryRowContext(
+	now := time.Now()
+	err = dbTx.QueryRowContext(
 		ctx,
 		query,
 		tx.UserID,
@@ -106,49 +196,140 @@ func (p *Postgres) CreateTransaction(ctx context.Context, tx Transaction) (int,
 		tx.Type,
 		tx.Status,
 		tx.GatewayID,
-		time.Now(),
-		time.Now(),
+		now,
+		now,
+		tx.CommandID,
 	).Scan(&id)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		dbTx.Rollback()
+		return 0, ErrDuplicateCommand
+	}
 	if err != nil {
+		dbTx.Rollback()
 		return 0, fmt.Errorf("failed to create transaction: %v", err)
 	}
 
+	event, err := p.recordTransactionEvent(ctx, dbTx, TransactionEvent{
+		TransactionID: id,
+		UserID:        tx.UserID,
+		Status:        tx.Status,
+		GatewayID:     tx.GatewayID,
+	})
+	if err != nil {
+		dbTx.Rollback()
+		return 0, err
+	}
+
+	if message != nil {
+		tx.ID = id
+		tx.CreatedAt = now
+		msg, err := message(ctx, tx)
+		if err != nil {
+			dbTx.Rollback()
+			return 0, fmt.Errorf("failed to build outbox message: %v", err)
+		}
+		if err := enqueueOutbox(ctx, dbTx, msg); err != nil {
+			dbTx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = dbTx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	p.emit(ctx, event)
+
 	return id, nil
 }
 
-func (p *Postgres) UpdateTransactionStatus(ctx context.Context, id int, status string, gatewayTxnID string, errorMsg string) error {
+func (p *Postgres) UpdateTransactionStatus(ctx context.Context, id int, status string, gatewayTxnID string, errorMsg string, reasonCode string, fencingToken int64) error {
+	return p.updateStatus(ctx, CallbackUpdate{
+		TransactionID: id,
+		Status:        status,
+		GatewayTxnID:  gatewayTxnID,
+		ErrorMessage:  errorMsg,
+		ReasonCode:    reasonCode,
+	}, fencingToken, false)
+}
+
+// ApplyCallback is UpdateTransactionStatus for gateway callbacks: it also
+// records the event position so older callbacks can be recognised later.
+func (p *Postgres) ApplyCallback(ctx context.Context, update CallbackUpdate, fencingToken int64) error {
+	return p.updateStatus(ctx, update, fencingToken, false)
+}
+
+// FailPendingTransaction is for when the lock can't be had. A pending
+// transaction hasn't reached a gateway, so the row lock is enough: whoever
+// holds the transaction lock either already moved it on, and nothing is
+// written, or will find it failed.
+func (p *Postgres) FailPendingTransaction(ctx context.Context, id int, errorMsg, reasonCode string) error {
+	return p.updateStatus(ctx, CallbackUpdate{
+		TransactionID: id,
+		Status:        "failed",
+		ErrorMessage:  errorMsg,
+		ReasonCode:    reasonCode,
+	}, 0, true)
+}
+
+// updateStatus applies update if fencingToken isn't stale or, for
+// pendingOnly, instead of the token check if the transaction is pending.
+func (p *Postgres) updateStatus(ctx context.Context, update CallbackUpdate, fencingToken int64, pendingOnly bool) error {
+	id := update.TransactionID
+
 	tx, err := p.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
 	var existingStatus string
-	lockQuery := `SELECT status FROM transactions WHERE id = $1 FOR UPDATE`
-	err = tx.QueryRowContext(ctx, lockQuery, id).Scan(&existingStatus)
+	var lastToken int64
+	var userID int
+	var gatewayID sql.NullInt64
+	lockQuery := `SELECT status, lock_token, user_id, gateway_id FROM transactions WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, lockQuery, id).Scan(&existingStatus, &lastToken, &userID, &gatewayID)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to lock transaction row: %v", err)
 	}
 
+	if pendingOnly {
+		if existingStatus != "pending" {
+			tx.Rollback()
+			return fmt.Errorf("%w: %s", ErrTransactionNotPending, existingStatus)
+		}
+	} else if fencingToken < lastToken {
+		tx.Rollback()
+		return fmt.Errorf("%w: %d < %d", ErrStaleFencingToken, fencingToken, lastToken)
+	}
+
 	if existingStatus == "completed" || existingStatus == "failed" {
 		tx.Rollback()
 		return fmt.Errorf("cannot update transaction in final state: %s", existingStatus)
 	}
 
+	var eventAt *time.Time
+	if !update.EventAt.IsZero() {
+		eventAt = &update.EventAt
+	}
+
 	query := `
 		UPDATE transactions 
-		SET status = $1, gateway_txn_id = $2, error_message = $3, updated_at = $4
+		SET status = $1, gateway_txn_id = $2, error_message = $3, updated_at = $4, lock_token = GREATEST(lock_token, $5),
+		    last_event_at = COALESCE($6, last_event_at),
+		    last_event_sequence = GREATEST(last_event_sequence, $7),
+		    reason_code = NULLIF($8, '')
 	`
 
-	args := []interface{}{status, gatewayTxnID, errorMsg, time.Now()}
+	args := []interface{}{update.Status, update.GatewayTxnID, update.ErrorMessage, time.Now(), fencingToken, eventAt, update.EventSequence, update.ReasonCode}
 
-	if status == "completed" {
-		query += `, completed_at = $5 WHERE id = $6`
+	if update.Status == "completed" {
+		query += `, completed_at = $9 WHERE id = $10`
 		now := time.Now()
 		args = append(args, now, id)
 	} else {
-		query += ` WHERE id = $5`
+		query += ` WHERE id = $9`
 		args = append(args, id)
 	}
 
@@ -158,29 +339,89 @@ func (p *Postgres) UpdateTransactionStatus(ctx context.Context, id int, status s
 		return fmt.Errorf("failed to update transaction status: %v", err)
 	}
 
+	event, err := p.recordTransactionEvent(ctx, tx, TransactionEvent{
+		TransactionID:  id,
+		UserID:         userID,
+		PreviousStatus: existingStatus,
+		Status:         update.Status,
+		GatewayID:      int(gatewayID.Int64),
+		ReasonCode:     update.ReasonCode,
+		ErrorMessage:   update.ErrorMessage,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := p.queueWebhooks(ctx, tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
+	p.emit(ctx, event)
+
 	return nil
 }
 
+// queueWebhooks queues the webhook deliveries for the status change of
+// transaction id as part of tx, so they are neither lost after a commit nor
+// sent for a change that was rolled back.
+func (p *Postgres) queueWebhooks(ctx context.Context, tx *sql.Tx, id int) error {
+	if p.webhookEvent == nil {
+		return nil
+	}
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE id = $1`
+	transaction, err := scanTransaction(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return fmt.Errorf("failed to read transaction for webhooks: %v", err)
+	}
+
+	event, ok, err := p.webhookEvent(ctx, transaction)
+	if err != nil {
+		return fmt.Errorf("failed to build webhook event: %v", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if _, err := enqueueWebhookEvent(ctx, tx, event); err != nil {
+		return err
+	}
+	return nil
+}
+
+const transactionColumns = `id, user_id, amount, currency, type, status, gateway_id,
+		       gateway_txn_id, error_message, created_at, updated_at, completed_at,
+		       last_event_at, last_event_sequence, reason_code`
+
 func (p *Postgres) GetTransactionByID(ctx context.Context, id int) (Transaction, error) {
-	query := `
-		SELECT id, user_id, amount, currency, type, status, gateway_id, 
-		       gateway_txn_id, error_message, created_at, updated_at, completed_at 
-		FROM transactions 
-		WHERE id = $1
-	`
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE id = $1`
+
+	return scanTransaction(p.db.QueryRowContext(ctx, query, id))
+}
+
+func (p *Postgres) GetTransactionByGatewayTxnID(ctx context.Context, gatewayID int, gatewayTxnID string) (Transaction, error) {
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE gateway_id = $1 AND gateway_txn_id = $2`
 
+	return scanTransaction(p.db.QueryRowContext(ctx, query, gatewayID, gatewayTxnID))
+}
+
+// scanTransaction scans transactionColumns from a *sql.Row or *sql.Rows.
+func scanTransaction(row interface{ Scan(dest ...any) error }) (Transaction, error) {
 	var tx Transaction
-	var gatewayTxnID, errorMsg sql.NullString
+	var gatewayTxnID, errorMsg, reasonCode sql.NullString
 	var gatewayID sql.NullInt64
-	var completedAt sql.NullTime
+	var completedAt, lastEventAt sql.NullTime
 
-	err := p.db.QueryRowContext(ctx, query, id).Scan(
+	err := row.Scan(
 		&tx.ID, &tx.UserID, &tx.Amount, &tx.Currency, &tx.Type, &tx.Status,
 		&gatewayID, &gatewayTxnID, &errorMsg, &tx.CreatedAt, &tx.UpdatedAt, &completedAt,
+		&lastEventAt, &tx.LastEventSequence, &reasonCode,
 	)
 
 	if err != nil {
@@ -203,6 +444,14 @@ func (p *Postgres) GetTransactionByID(ctx context.Context, id int) (Transaction,
 		tx.CompletedAt = &completedAt.Time
 	}
 
+	if lastEventAt.Valid {
+		tx.LastEventAt = &lastEventAt.Time
+	}
+
+	if reasonCode.Valid {
+		tx.ReasonCode = reasonCode.String
+	}
+
 	return tx, nil
 }
 
@@ -241,6 +490,69 @@ func (p *Postgres) GetGatewaysByCountry(ctx context.Context, countryID int) ([]G
 	return gateways, nil
 }
 
+func (p *Postgres) GetGatewayByID(ctx context.Context, id int) (Gateway, error) {
+	query := `SELECT id, name, data_format_supported, created_at, updated_at FROM gateways WHERE id = $1`
+
+	var gateway Gateway
+	err := p.db.QueryRowContext(ctx, query, id).Scan(
+		&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.CreatedAt, &gateway.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Gateway{}, fmt.Errorf("gateway not found: %v", err)
+		}
+		return Gateway{}, fmt.Errorf("failed to get gateway: %v", err)
+	}
+
+	return gateway, nil
+}
+
+func (p *Postgres) GetGatewayByName(ctx context.Context, name string) (Gateway, error) {
+	query := `SELECT id, name, data_format_supported, created_at, updated_at FROM gateways WHERE LOWER(name) = LOWER($1)`
+
+	var gateway Gateway
+	err := p.db.QueryRowContext(ctx, query, name).Scan(
+		&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.CreatedAt, &gateway.UpdatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Gateway{}, fmt.Errorf("gateway not found: %v", err)
+		}
+		return Gateway{}, fmt.Errorf("failed to get gateway: %v", err)
+	}
+
+	return gateway, nil
+}
+
+func (p *Postgres) GetStatusMapping(ctx context.Context, gatewayID int, gatewayStatus string) (StatusMapping, error) {
+	query := `
+		SELECT gateway_id, gateway_status, internal_status, reason_code
+		FROM gateway_status_mappings
+		WHERE gateway_id = $1 AND gateway_status = LOWER($2)
+	`
+
+	var mapping StatusMapping
+	var reasonCode sql.NullString
+	err := p.db.QueryRowContext(ctx, query, gatewayID, gatewayStatus).Scan(
+		&mapping.GatewayID, &mapping.GatewayStatus, &mapping.InternalStatus, &reasonCode,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return StatusMapping{}, fmt.Errorf("%w: gateway %d, status %q", ErrStatusMappingNotFound, gatewayID, gatewayStatus)
+	}
+	if err != nil {
+		return StatusMapping{}, fmt.Errorf("failed to get status mapping: %v", err)
+	}
+
+	if reasonCode.Valid {
+		mapping.ReasonCode = reasonCode.String
+	}
+
+	return mapping, nil
+}
+
 func (p *Postgres) GetUserByID(ctx context.Context, id int) (User, error) {
 	query := `SELECT id, username, email, country_id, created_at, updated_at FROM users WHERE id = $1`
 
@@ -259,27 +571,32 @@ func (p *Postgres) GetUserByID(ctx context.Context, id int) (User, error) {
 	return user, nil
 }
 
-func (p *Postgres) UpdateTransactionGateway(ctx context.Context, txID int, gatewayID int) error {
+func (p *Postgres) UpdateTransactionGateway(ctx context.Context, txID int, gatewayID int, fencingToken int64) error {
 	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	lockQuery := `SELECT id FROM transactions WHERE id = $1 FOR UPDATE`
+	lockQuery := `SELECT lock_token FROM transactions WHERE id = $1 FOR UPDATE`
 	row := tx.QueryRowContext(ctx, lockQuery, txID)
-	var id int
-	if err = row.Scan(&id); err != nil {
+	var lastToken int64
+	if err = row.Scan(&lastToken); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to lock transaction row: %v", err)
 	}
 
+	if fencingToken < lastToken {
+		tx.Rollback()
+		return fmt.Errorf("%w: %d < %d", ErrStaleFencingToken, fencingToken, lastToken)
+	}
+
 	query := `
 		UPDATE transactions 
-		SET gateway_id = $1, updated_at = $2
-		WHERE id = $3
+		SET gateway_id = $1, updated_at = $2, lock_token = $3
+		WHERE id = $4
 	`
 
-	_, err = tx.ExecContext(ctx, query, gatewayID, time.Now(), txID)
+	_, err = tx.ExecContext(ctx, query, gatewayID, time.Now(), fencingToken, txID)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to update transaction gateway: %v", err)
@@ -291,3 +608,33 @@ func (p *Postgres) UpdateTransactionGateway(ctx context.Context, txID int, gatew
 
 	return nil
 }
+
+func (p *Postgres) RecordSecurityEvent(ctx context.Context, event SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (event_type, transaction_id, gateway_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := p.db.ExecContext(ctx, query, event.Type, event.TransactionID, event.GatewayID, event.Details, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record security event: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) CreateReconciliationAlert(ctx context.Context, alert ReconciliationAlert) error {
+	query := `
+		INSERT INTO reconciliation_alerts (transaction_id, gateway_id, current_status, reported_status, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := p.db.ExecContext(ctx, query,
+		alert.TransactionID, alert.GatewayID, alert.CurrentStatus, alert.ReportedStatus, alert.Details, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create reconciliation alert: %v", err)
+	}
+
+	return nil
+}
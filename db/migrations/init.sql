@@ -67,11 +67,254 @@ BEGIN
             error_message TEXT,
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
             updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-            completed_at TIMESTAMP
+            completed_at TIMESTAMP,
+            lock_token BIGINT NOT NULL DEFAULT 0, -- fencing token of the last writer
+            last_event_at TIMESTAMP NULL, -- gateway timestamp of the last applied callback
+            last_event_sequence BIGINT NOT NULL DEFAULT 0, -- gateway sequence of the last applied callback
+            reason_code VARCHAR(50) NULL, -- why the gateway put the transaction in its status
+            command_id VARCHAR(255) NULL -- envelope ID of the Kafka command that requested it
         );
     END IF;
 END $$;
 
+-- columns added since the table was first created, for existing databases
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS lock_token BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS last_event_at TIMESTAMP NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS last_event_sequence BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reason_code VARCHAR(50) NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS command_id VARCHAR(255) NULL;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'api_keys') THEN
+        CREATE TABLE api_keys (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            key_hash CHAR(64) NOT NULL UNIQUE, -- hex SHA-256 of the raw key, the raw key is never stored
+            scopes TEXT[] NOT NULL DEFAULT '{}', -- 'deposit', 'withdrawal', 'read', 'admin'
+            user_id INT NULL REFERENCES users(id), -- restricts the key to a single user
+            expires_at TIMESTAMP,
+            revoked_at TIMESTAMP,
+            last_used_at TIMESTAMP,
+            rotated_to INT NULL REFERENCES api_keys(id),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'security_events') THEN
+        CREATE TABLE security_events (
+            id SERIAL PRIMARY KEY,
+            event_type VARCHAR(50) NOT NULL, -- e.g. 'callback_mismatch'
+            transaction_id INT NULL,
+            gateway_id INT NULL,
+            details TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_gateway_txn ON transactions (gateway_id, gateway_txn_id);
+
+-- a redelivered command must not create a second transaction
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_command ON transactions (command_id);
+
+-- Raw gateway callbacks, acknowledged on receipt and processed by internal/inbox
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'callback_inbox') THEN
+        CREATE TABLE callback_inbox (
+            id SERIAL PRIMARY KEY,
+            gateway_id INT NOT NULL REFERENCES gateways(id),
+            event_id VARCHAR(255) NOT NULL,
+            transaction_id INT NULL,
+            content_type VARCHAR(100) NOT NULL,
+            body BYTEA NOT NULL,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, processed, dead
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT,
+            next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            processed_at TIMESTAMP NULL,
+            UNIQUE (gateway_id, event_id)
+        );
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_callback_inbox_due ON callback_inbox (status, next_attempt_at);
+
+-- How each gateway's callback statuses translate to ours (internal/services)
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'gateway_status_mappings') THEN
+        CREATE TABLE gateway_status_mappings (
+            gateway_id INT NOT NULL REFERENCES gateways(id),
+            gateway_status VARCHAR(100) NOT NULL, -- lowercased
+            internal_status VARCHAR(20) NOT NULL, -- 'pending', 'processing', 'completed', 'failed'
+            reason_code VARCHAR(50) NULL,
+            PRIMARY KEY (gateway_id, gateway_status)
+        );
+    END IF;
+END $$;
+
+-- Callbacks reporting a final status that contradicts the stored one
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'reconciliation_alerts') THEN
+        CREATE TABLE reconciliation_alerts (
+            id SERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL REFERENCES transactions(id),
+            gateway_id INT NULL,
+            current_status VARCHAR(20) NOT NULL,
+            reported_status VARCHAR(20) NOT NULL,
+            details TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            resolved_at TIMESTAMP NULL
+        );
+    END IF;
+END $$;
+
+-- Every status a transaction went through, written with the status change.
+-- Backs Last-Event-ID resume of the SSE streams (internal/stream).
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'transaction_events') THEN
+        CREATE TABLE transaction_events (
+            id BIGSERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL REFERENCES transactions(id),
+            user_id INT NOT NULL,
+            previous_status VARCHAR(20) NULL, -- NULL for the creation event
+            status VARCHAR(20) NOT NULL,
+            gateway_id INT NULL,
+            reason_code VARCHAR(50) NULL,
+            error_message TEXT,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_transaction_events_transaction ON transaction_events (transaction_id, id);
+CREATE INDEX IF NOT EXISTS idx_transaction_events_user ON transaction_events (user_id, id);
+
+-- Kafka messages written together with the change they describe and
+-- published in id order by the outbox relay (internal/outbox)
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'outbox') THEN
+        CREATE TABLE outbox (
+            id BIGSERIAL PRIMARY KEY,
+            topic VARCHAR(255) NOT NULL,
+            message_key VARCHAR(255) NULL,
+            headers JSONB NOT NULL DEFAULT '{}',
+            payload BYTEA NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            sent_at TIMESTAMP NULL,
+            parked_at TIMESTAMP NULL
+        );
+    END IF;
+END $$;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';
+-- set when a message failed OUTBOX_MAX_ATTEMPTS times, the relay skips it;
+-- clear it (and attempts) to publish the message again
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP NULL;
+
+DROP INDEX IF EXISTS idx_outbox_unsent;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id) WHERE sent_at IS NULL AND parked_at IS NULL;
+
+-- Merchant endpoints notified about transaction events (internal/webhook)
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_subscriptions') THEN
+        CREATE TABLE webhook_subscriptions (
+            id SERIAL PRIMARY KEY,
+            url TEXT NOT NULL,
+            secret VARCHAR(255) NOT NULL,
+            event_types TEXT[] NOT NULL,
+            user_id INT NULL REFERENCES users(id), -- NULL receives events for every user
+            active BOOLEAN NOT NULL DEFAULT TRUE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_deliveries') THEN
+        CREATE TABLE webhook_deliveries (
+            id SERIAL PRIMARY KEY,
+            subscription_id INT NOT NULL REFERENCES webhook_subscriptions(id),
+            event_id VARCHAR(64) NOT NULL,
+            event_type VARCHAR(50) NOT NULL,
+            transaction_id INT NULL,
+            payload BYTEA NOT NULL,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, delivered, dead
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT,
+            last_status_code INT NULL,
+            next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            delivered_at TIMESTAMP NULL,
+            UNIQUE (subscription_id, event_id)
+        );
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+-- Fencing tokens of transaction locks, whichever backend holds them (internal/lock)
+CREATE SEQUENCE IF NOT EXISTS transaction_lock_token_seq;
+
+-- tokens issued before both backends shared the sequence must not outrank it
+SELECT setval('transaction_lock_token_seq', max_token)
+FROM (SELECT MAX(lock_token) AS max_token FROM transactions) t
+WHERE max_token > (SELECT last_value FROM transaction_lock_token_seq);
+
+-- Notify the application about changes to cached rows (see internal/cache/invalidation.go)
+CREATE OR REPLACE FUNCTION notify_user_cache_invalidation() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('cache_invalidation', 'user:' || COALESCE(NEW.id, OLD.id));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_country_cache_invalidation() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('cache_invalidation', 'country:' || COALESCE(NEW.country_id, OLD.country_id));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_gateway_cache_invalidation() RETURNS TRIGGER AS $$
+DECLARE
+    linked_country INT;
+BEGIN
+    FOR linked_country IN SELECT country_id FROM gateway_countries WHERE gateway_id = COALESCE(NEW.id, OLD.id) LOOP
+        PERFORM pg_notify('cache_invalidation', 'country:' || linked_country);
+    END LOOP;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_cache_invalidation ON users;
+CREATE TRIGGER users_cache_invalidation
+    AFTER UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_cache_invalidation();
+
+DROP TRIGGER IF EXISTS gateway_countries_cache_invalidation ON gateway_countries;
+CREATE TRIGGER gateway_countries_cache_invalidation
+    AFTER INSERT OR UPDATE OR DELETE ON gateway_countries
+    FOR EACH ROW EXECUTE FUNCTION notify_country_cache_invalidation();
+
+DROP TRIGGER IF EXISTS gateways_cache_invalidation ON gateways;
+CREATE TRIGGER gateways_cache_invalidation
+    AFTER UPDATE OR DELETE ON gateways
+    FOR EACH ROW EXECUTE FUNCTION notify_gateway_cache_invalidation();
+
 -- Insert sample data if tables are empty
 DO $$
 BEGIN
@@ -107,4 +350,35 @@ BEGIN
             (1, 2, 1), -- Stripe for UK
             (3, 3, 1); -- Adyen for EU
     END IF;
+
+    -- Map gateway callback statuses if none exist
+    IF NOT EXISTS (SELECT 1 FROM gateway_status_mappings) THEN
+        -- statuses every gateway may send in our CallbackRequest format
+        INSERT INTO gateway_status_mappings (gateway_id, gateway_status, internal_status, reason_code)
+        SELECT g.id, m.gateway_status, m.internal_status, m.reason_code
+        FROM gateways g CROSS JOIN (VALUES
+            ('success', 'completed', NULL),
+            ('completed', 'completed', NULL),
+            ('approved', 'completed', NULL),
+            ('pending', 'pending', NULL),
+            ('failed', 'failed', 'gateway_failed'),
+            ('declined', 'failed', 'declined'),
+            ('rejected', 'failed', 'rejected'),
+            ('expired', 'failed', 'expired'),
+            ('chargeback', 'failed', 'chargeback')
+        ) AS m (gateway_status, internal_status, reason_code);
+
+        INSERT INTO gateway_status_mappings (gateway_id, gateway_status, internal_status, reason_code) VALUES
+            (1, 'succeeded', 'completed', NULL), -- Stripe
+            (1, 'processing', 'processing', NULL),
+            (1, 'requires_payment_method', 'failed', 'payment_method_required'),
+            (1, 'canceled', 'failed', 'canceled'),
+            (2, 'denied', 'failed', 'declined'), -- PayPal
+            (2, 'voided', 'failed', 'canceled'),
+            (2, 'reversed', 'failed', 'chargeback'),
+            (3, 'authorised', 'completed', NULL), -- Adyen
+            (3, 'refused', 'failed', 'declined'),
+            (3, 'cancelled', 'failed', 'canceled'),
+            (3, 'error', 'failed', 'gateway_failed');
+    END IF;
 END $$;
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// outboxLockNamespace is the first key of the advisory lock held by the
+// relay, see lock.advisoryNamespace for the transaction locks.
+const outboxLockNamespace = 1002
+
+// ErrOutboxNotAttempted is wrapped by publish errors for messages that never
+// reached the sink, e.g. because its circuit breaker is open. They don't
+// count towards maxAttempts, so an outage doesn't park healthy messages.
+var ErrOutboxNotAttempted = errors.New("outbox message not attempted")
+
+// OutboxMessage is a Kafka message waiting in the outbox.
+type OutboxMessage struct {
+	ID        int64
+	Topic     string
+	Key       string
+	Headers   map[string]string
+	Payload   []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// OutboxMessageFunc builds the message announcing a new transaction. It is
+// called inside the insert, once the ID is known.
+type OutboxMessageFunc func(ctx context.Context, tx Transaction) (OutboxMessage, error)
+
+type OutboxStorage interface {
+	// RelayOutbox hands up to limit unsent messages to publish, oldest first,
+	// and marks the published ones sent. It stops at the first failure so the
+	// order is kept, unless the message has now failed maxAttempts times (0
+	// means no limit): then it is parked, returned in parked and skipped from
+	// then on. Only one replica relays at a time; the others get 0, nil, nil.
+	//
+	// "Oldest" is ID order. IDs come from a sequence when the row is
+	// inserted, not when it commits, so a message can become visible after
+	// one with a higher ID was published and is then published after it.
+	// Messages about the same transaction keep their order, its row lock
+	// makes the second change insert after the first committed.
+	RelayOutbox(ctx context.Context, limit, maxAttempts int, publish func(OutboxMessage) error) (sent int, parked []OutboxMessage, err error)
+	// ListOutbox returns up to limit messages with IDs above afterID, sent or
+	// not, in ID order.
+	ListOutbox(ctx context.Context, afterID int64, limit int) ([]OutboxMessage, error)
+	// RewriteOutboxMessage replaces the headers and payload of a message.
+	RewriteOutboxMessage(ctx context.Context, id int64, headers map[string]string, payload []byte) error
+}
+
+func NewOutboxHandler(db *sql.DB) OutboxStorage {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) RelayOutbox(ctx context.Context, limit, maxAttempts int, publish func(OutboxMessage) error) (int, []OutboxMessage, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// held until commit, a second relay would publish out of order
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1, 0)`, outboxLockNamespace).Scan(&locked)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to lock outbox: %v", err)
+	}
+	if !locked {
+		return 0, nil, nil
+	}
+
+	messages, err := pendingOutboxMessages(ctx, tx, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sent := 0
+	var parked []OutboxMessage
+	var publishErr error
+	for _, message := range messages {
+		if publishErr = publish(message); publishErr != nil {
+			if !errors.Is(publishErr, ErrOutboxNotAttempted) {
+				message.Attempts++
+			}
+
+			// a message that never goes out would hold back everything after
+			// it forever, so after maxAttempts it is set aside for an operator
+			var parkedAt *time.Time
+			if maxAttempts > 0 && message.Attempts >= maxAttempts {
+				now := time.Now()
+				parkedAt = &now
+			}
+
+			_, err := tx.ExecContext(ctx, `UPDATE outbox SET attempts = $2, last_error = $3, parked_at = $4 WHERE id = $1`,
+				message.ID, message.Attempts, publishErr.Error(), parkedAt)
+			if err != nil {
+				return 0, nil, fmt.Errorf("failed to record outbox failure: %v", err)
+			}
+
+			if parkedAt != nil {
+				parked = append(parked, message)
+				publishErr = nil
+				continue
+			}
+			publishErr = fmt.Errorf("failed to publish outbox message %d: %v", message.ID, publishErr)
+			break
+		}
+
+		_, err := tx.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = NULL, sent_at = $2 WHERE id = $1`,
+			message.ID, time.Now())
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to mark outbox message sent: %v", err)
+		}
+		sent++
+	}
+
+	// if this fails the batch is published again, consumers see it twice
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("failed to commit outbox: %v", err)
+	}
+
+	return sent, parked, publishErr
+}
+
+func (p *Postgres) ListOutbox(ctx context.Context, afterID int64, limit int) ([]OutboxMessage, error) {
+	query := `
+		SELECT id, topic, message_key, headers, payload, attempts, created_at
+		FROM outbox
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox messages: %v", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+func (p *Postgres) RewriteOutboxMessage(ctx context.Context, id int64, headers map[string]string, payload []byte) error {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox headers: %v", err)
+	}
+
+	result, err := p.db.ExecContext(ctx, `UPDATE outbox SET headers = $2, payload = $3 WHERE id = $1`, id, encoded, payload)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite outbox message %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("outbox message %d not found", id)
+	}
+
+	return nil
+}
+
+func pendingOutboxMessages(ctx context.Context, tx *sql.Tx, limit int) ([]OutboxMessage, error) {
+	query := `
+		SELECT id, topic, message_key, headers, payload, attempts, created_at
+		FROM outbox
+		WHERE sent_at IS NULL AND parked_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox messages: %v", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxMessages(rows)
+}
+
+func scanOutboxMessages(rows *sql.Rows) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		var key sql.NullString
+		var headers []byte
+		if err := rows.Scan(&message.ID, &message.Topic, &key, &headers, &message.Payload, &message.Attempts, &message.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %v", err)
+		}
+		message.Key = key.String
+		if err := json.Unmarshal(headers, &message.Headers); err != nil {
+			return nil, fmt.Errorf("invalid headers of outbox message %d: %v", message.ID, err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating outbox messages: %v", err)
+	}
+
+	return messages, nil
+}
+
+// enqueueOutbox writes message as part of tx, so it is sent if and only if tx
+// commits.
+func enqueueOutbox(ctx context.Context, tx *sql.Tx, message OutboxMessage) error {
+	query := `INSERT INTO outbox (topic, message_key, headers, payload, created_at) VALUES ($1, NULLIF($2, ''), $3, $4, $5)`
+
+	headers, err := json.Marshal(message.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox headers: %v", err)
+	}
+	if message.Headers == nil {
+		headers = []byte("{}")
+	}
+
+	if _, err := tx.ExecContext(ctx, query, message.Topic, message.Key, headers, message.Payload, time.Now()); err != nil {
+		return fmt.Errorf("failed to write outbox message: %v", err)
+	}
+
+	return nil
+}
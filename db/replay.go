@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ReplayFilter selects the transactions whose events are replayed. Zero
+// fields match everything.
+type ReplayFilter struct {
+	FromID int       // inclusive
+	ToID   int       // inclusive
+	Since  time.Time // created at or after
+	Until  time.Time // created before
+	Status string    // current status
+	UserID int
+}
+
+type ReplayStorage interface {
+	// ListReplayTransactions returns up to limit matching transactions with
+	// IDs above afterID, in ID order.
+	ListReplayTransactions(ctx context.Context, filter ReplayFilter, afterID, limit int) ([]Transaction, error)
+	TransactionEventStorage
+}
+
+func NewReplayHandler(db *sql.DB) ReplayStorage {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) ListReplayTransactions(ctx context.Context, filter ReplayFilter, afterID, limit int) ([]Transaction, error) {
+	query := `SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE id > $1
+		  AND ($2 = 0 OR id >= $2) AND ($3 = 0 OR id <= $3)
+		  AND ($4::timestamp IS NULL OR created_at >= $4) AND ($5::timestamp IS NULL OR created_at < $5)
+		  AND ($6 = '' OR status = $6) AND ($7 = 0 OR user_id = $7)
+		ORDER BY id
+		LIMIT $8
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, afterID, filter.FromID, filter.ToID,
+		nullTime(filter.Since), nullTime(filter.Until), filter.Status, filter.UserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transactions: %v", err)
+	}
+
+	return transactions, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
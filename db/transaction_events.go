@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TransactionEvent is one entry of a transaction's status history.
+type TransactionEvent struct {
+	ID             int64 // increases with every event, across transactions
+	TransactionID  int
+	UserID         int
+	PreviousStatus string // empty for the creation event
+	Status         string
+	GatewayID      int
+	ReasonCode     string
+	ErrorMessage   string
+	CreatedAt      time.Time
+}
+
+// OutboxEventFunc builds the outbox message announcing a status change.
+type OutboxEventFunc func(ctx context.Context, event TransactionEvent) (OutboxMessage, error)
+
+// EventHook is called with every status change after it has been committed.
+type EventHook func(ctx context.Context, event TransactionEvent)
+
+// TransactionEventFilter selects events of one transaction or one user.
+type TransactionEventFilter struct {
+	TransactionID int
+	UserID        int
+	AfterID       int64
+	Limit         int
+}
+
+type TransactionEventStorage interface {
+	// ListTransactionEvents returns matching events oldest first.
+	ListTransactionEvents(ctx context.Context, filter TransactionEventFilter) ([]TransactionEvent, error)
+}
+
+func NewTransactionEventHandler(db *sql.DB) TransactionEventStorage {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) ListTransactionEvents(ctx context.Context, filter TransactionEventFilter) ([]TransactionEvent, error) {
+	query := `
+		SELECT id, transaction_id, user_id, previous_status, status, gateway_id, reason_code, error_message, created_at
+		FROM transaction_events
+		WHERE ($1 = 0 OR transaction_id = $1) AND ($2 = 0 OR user_id = $2) AND id > $3
+		ORDER BY id
+		LIMIT $4
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, filter.TransactionID, filter.UserID, filter.AfterID, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transaction events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []TransactionEvent
+	for rows.Next() {
+		var event TransactionEvent
+		var previousStatus, reasonCode, errorMsg sql.NullString
+		var gatewayID sql.NullInt64
+
+		err := rows.Scan(&event.ID, &event.TransactionID, &event.UserID, &previousStatus, &event.Status,
+			&gatewayID, &reasonCode, &errorMsg, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction event: %v", err)
+		}
+
+		event.PreviousStatus = previousStatus.String
+		event.GatewayID = int(gatewayID.Int64)
+		event.ReasonCode = reasonCode.String
+		event.ErrorMessage = errorMsg.String
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating transaction events: %v", err)
+	}
+
+	return events, nil
+}
+
+// recordTransactionEvent appends event to the history and the outbox as part
+// of tx and returns it with its ID and timestamp filled in.
+func (p *Postgres) recordTransactionEvent(ctx context.Context, tx *sql.Tx, event TransactionEvent) (TransactionEvent, error) {
+	query := `
+		INSERT INTO transaction_events
+		(transaction_id, user_id, previous_status, status, gateway_id, reason_code, error_message, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''), $8)
+		RETURNING id
+	`
+
+	event.CreatedAt = time.Now()
+	err := tx.QueryRowContext(ctx, query,
+		event.TransactionID, event.UserID, event.PreviousStatus, event.Status,
+		event.GatewayID, event.ReasonCode, event.ErrorMessage, event.CreatedAt,
+	).Scan(&event.ID)
+	if err != nil {
+		return TransactionEvent{}, fmt.Errorf("failed to record transaction event: %v", err)
+	}
+
+	if p.statusMessage != nil {
+		message, err := p.statusMessage(ctx, event)
+		if err != nil {
+			return TransactionEvent{}, fmt.Errorf("failed to build status message: %v", err)
+		}
+		if err := enqueueOutbox(ctx, tx, message); err != nil {
+			return TransactionEvent{}, err
+		}
+	}
+
+	return event, nil
+}
+
+// emit hands a committed event to the hooks.
+func (p *Postgres) emit(ctx context.Context, event TransactionEvent) {
+	for _, hook := range p.hooks {
+		hook(ctx, event)
+	}
+}
@@ -0,0 +1,417 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrWebhookDeliveryNotFound     = errors.New("webhook delivery not found")
+)
+
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusDelivered = "delivered"
+	WebhookStatusDead      = "dead" // gave up, can be redelivered by hand
+)
+
+type WebhookSubscription struct {
+	ID         int
+	URL        string
+	Secret     string
+	EventTypes []string
+	UserID     *int // when set, only events for this user's transactions are sent
+	Active     bool
+	CreatedAt  time.Time
+}
+
+// WebhookEvent is one event to be fanned out to every matching subscription.
+type WebhookEvent struct {
+	ID            string
+	Type          string
+	UserID        int
+	TransactionID *int
+	Payload       []byte
+}
+
+// WebhookEventFunc builds the webhook event for a transaction that changed
+// status, which it carries; ok is false for statuses without an event. It
+// is called inside the status change, so deliveries are queued with it.
+type WebhookEventFunc func(ctx context.Context, tx Transaction) (event WebhookEvent, ok bool, err error)
+
+// WebhookDelivery is one event on its way to one subscription.
+type WebhookDelivery struct {
+	ID             int
+	SubscriptionID int
+	URL            string
+	Secret         string
+	EventID        string
+	EventType      string
+	TransactionID  *int
+	Payload        []byte
+	Status         string
+	Attempts       int
+	LastError      string
+	LastStatusCode *int
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+type WebhookStorage interface {
+	CreateWebhookSubscription(ctx context.Context, sub WebhookSubscription) (int, error)
+	GetWebhookSubscription(ctx context.Context, id int) (WebhookSubscription, error)
+	// ListWebhookSubscriptions returns active subscriptions, only userID's
+	// ones when it is set.
+	ListWebhookSubscriptions(ctx context.Context, userID *int) ([]WebhookSubscription, error)
+	// DeactivateWebhookSubscription stops a subscription and marks its
+	// pending deliveries dead.
+	DeactivateWebhookSubscription(ctx context.Context, id int) error
+	// EnqueueWebhookEvent creates a pending delivery for every active
+	// subscription interested in the event and returns how many it created.
+	EnqueueWebhookEvent(ctx context.Context, event WebhookEvent) (int, error)
+	// ClaimWebhookDeliveries returns up to limit due deliveries and hides
+	// them from other claimers for the visibility timeout.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, visibility time.Duration) ([]WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int, statusCode int) error
+	MarkWebhookFailed(ctx context.Context, id int, statusCode *int, errorMsg string, nextAttemptAt time.Time, dead bool) error
+	// ListWebhookDeliveries returns the delivery log of a subscription,
+	// newest first. An empty status matches every status.
+	ListWebhookDeliveries(ctx context.Context, subscriptionID int, status string, limit int) ([]WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id int) (WebhookDelivery, error)
+	// RedeliverWebhook queues a delivery again, whatever its status.
+	RedeliverWebhook(ctx context.Context, id int) error
+}
+
+func NewWebhookHandler(db *sql.DB) WebhookStorage {
+	return &Postgres{db: db}
+}
+
+const webhookSubscriptionColumns = `id, url, secret, event_types, user_id, active, created_at`
+
+const webhookDeliveryColumns = `wd.id, wd.subscription_id, ws.url, ws.secret, wd.event_id, wd.event_type, wd.transaction_id,
+	wd.payload, wd.status, wd.attempts, wd.last_error, wd.last_status_code, wd.next_attempt_at, wd.created_at, wd.delivered_at`
+
+func (p *Postgres) CreateWebhookSubscription(ctx context.Context, sub WebhookSubscription) (int, error) {
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, event_types, user_id, active, created_at)
+		VALUES ($1, $2, $3, $4, TRUE, $5)
+		RETURNING id
+	`
+
+	var id int
+	err := p.db.QueryRowContext(ctx, query,
+		sub.URL, sub.Secret, pq.Array(sub.EventTypes), sub.UserID, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook subscription: %v", err)
+	}
+
+	return id, nil
+}
+
+func (p *Postgres) GetWebhookSubscription(ctx context.Context, id int) (WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1 AND active`
+
+	rows, err := p.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return WebhookSubscription{}, fmt.Errorf("failed to get webhook subscription: %v", err)
+	}
+	defer rows.Close()
+
+	subs, err := scanWebhookSubscriptions(rows)
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	if len(subs) == 0 {
+		return WebhookSubscription{}, ErrWebhookSubscriptionNotFound
+	}
+
+	return subs[0], nil
+}
+
+func (p *Postgres) ListWebhookSubscriptions(ctx context.Context, userID *int) ([]WebhookSubscription, error) {
+	query := `
+		SELECT ` + webhookSubscriptionColumns + `
+		FROM webhook_subscriptions
+		WHERE active AND ($1::INT IS NULL OR user_id = $1)
+		ORDER BY id
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookSubscriptions(rows)
+}
+
+func (p *Postgres) DeactivateWebhookSubscription(ctx context.Context, id int) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	// subscriptions are kept so their delivery log stays readable
+	result, err := tx.ExecContext(ctx, `UPDATE webhook_subscriptions SET active = FALSE WHERE id = $1 AND active`, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to deactivate webhook subscription: %v", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ErrWebhookSubscriptionNotFound
+	}
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, last_error = 'subscription deactivated'
+		WHERE subscription_id = $2 AND status = $3
+	`
+
+	_, err = tx.ExecContext(ctx, query, WebhookStatusDead, id, WebhookStatusPending)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to cancel webhook deliveries: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) EnqueueWebhookEvent(ctx context.Context, event WebhookEvent) (int, error) {
+	return enqueueWebhookEvent(ctx, p.db, event)
+}
+
+// enqueueWebhookEvent runs on the database or inside a transaction.
+func enqueueWebhookEvent(ctx context.Context, exec interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, event WebhookEvent) (int, error) {
+	// the unique (subscription_id, event_id) key makes enqueueing the same
+	// event twice harmless
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, transaction_id, payload, status, next_attempt_at, created_at)
+		SELECT id, $1, $2, $3, $4, $5, $6, $6
+		FROM webhook_subscriptions
+		WHERE active AND $2 = ANY(event_types) AND (user_id IS NULL OR user_id = $7)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`
+
+	result, err := exec.ExecContext(ctx, query,
+		event.ID, event.Type, event.TransactionID, event.Payload, WebhookStatusPending, time.Now(), event.UserID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue webhook event: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func (p *Postgres) ClaimWebhookDeliveries(ctx context.Context, limit int, visibility time.Duration) ([]WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries wd
+		SET attempts = wd.attempts + 1, next_attempt_at = $2
+		FROM webhook_subscriptions ws
+		WHERE ws.id = wd.subscription_id AND wd.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $3 AND next_attempt_at <= $4
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+
+	now := time.Now()
+	rows, err := p.db.QueryContext(ctx, query, limit, now.Add(visibility), WebhookStatusPending, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+func (p *Postgres) MarkWebhookDelivered(ctx context.Context, id int, statusCode int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, last_status_code = $2, last_error = NULL, delivered_at = $3
+		WHERE id = $4
+	`
+
+	_, err := p.db.ExecContext(ctx, query, WebhookStatusDelivered, statusCode, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark webhook delivered: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) MarkWebhookFailed(ctx context.Context, id int, statusCode *int, errorMsg string, nextAttemptAt time.Time, dead bool) error {
+	status := WebhookStatusPending
+	if dead {
+		status = WebhookStatusDead
+	}
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, last_status_code = $2, last_error = $3, next_attempt_at = $4
+		WHERE id = $5
+	`
+
+	_, err := p.db.ExecContext(ctx, query, status, statusCode, errorMsg, nextAttemptAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark webhook failed: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) ListWebhookDeliveries(ctx context.Context, subscriptionID int, status string, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries wd
+		JOIN webhook_subscriptions ws ON ws.id = wd.subscription_id
+		WHERE wd.subscription_id = $1 AND ($2 = '' OR wd.status = $2)
+		ORDER BY wd.id DESC
+		LIMIT $3
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, subscriptionID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+func (p *Postgres) GetWebhookDelivery(ctx context.Context, id int) (WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries wd
+		JOIN webhook_subscriptions ws ON ws.id = wd.subscription_id
+		WHERE wd.id = $1
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("failed to get webhook delivery: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries, err := scanWebhookDeliveries(rows)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return WebhookDelivery{}, ErrWebhookDeliveryNotFound
+	}
+
+	return deliveries[0], nil
+}
+
+func (p *Postgres) RedeliverWebhook(ctx context.Context, id int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = 0, next_attempt_at = $2
+		WHERE id = $3
+	`
+
+	result, err := p.db.ExecContext(ctx, query, WebhookStatusPending, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrWebhookDeliveryNotFound
+	}
+
+	return nil
+}
+
+func scanWebhookSubscriptions(rows *sql.Rows) ([]WebhookSubscription, error) {
+	var subs []WebhookSubscription
+	for rows.Next() {
+		var sub WebhookSubscription
+		var userID sql.NullInt64
+
+		err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.EventTypes), &userID, &sub.Active, &sub.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %v", err)
+		}
+
+		if userID.Valid {
+			id := int(userID.Int64)
+			sub.UserID = &id
+		}
+
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook subscriptions: %v", err)
+	}
+
+	return subs, nil
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var transactionID, lastStatusCode sql.NullInt64
+		var lastError sql.NullString
+		var deliveredAt sql.NullTime
+
+		err := rows.Scan(
+			&d.ID, &d.SubscriptionID, &d.URL, &d.Secret, &d.EventID, &d.EventType, &transactionID,
+			&d.Payload, &d.Status, &d.Attempts, &lastError, &lastStatusCode, &d.NextAttemptAt, &d.CreatedAt, &deliveredAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+
+		if transactionID.Valid {
+			id := int(transactionID.Int64)
+			d.TransactionID = &id
+		}
+		if lastStatusCode.Valid {
+			code := int(lastStatusCode.Int64)
+			d.LastStatusCode = &code
+		}
+		if lastError.Valid {
+			d.LastError = lastError.String
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
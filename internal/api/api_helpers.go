@@ -9,7 +9,7 @@ import (
 	"payment-gateway/internal/models"
 )
 
-func DecodeRequest(r *http.Request, request *models.TransactionRequest) error {
+func DecodeRequest(r *http.Request, request interface{}) error {
 	contentType := r.Header.Get("Content-Type")
 
 	switch contentType {
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+)
+
+// defaultRotationGracePeriod keeps a rotated key valid long enough for
+// clients to roll out the new one.
+const defaultRotationGracePeriod = 24 * time.Hour
+
+type APIKeyHandler struct {
+	Keys auth.KeyManager
+}
+
+func NewAPIKeyHandler(keys auth.KeyManager) *APIKeyHandler {
+	return &APIKeyHandler{Keys: keys}
+}
+
+func (h *APIKeyHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.APIKeyRequest
+
+	if err := DecodeRequest(r, &request); err != nil || request.Name == "" {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	rawKey, key, err := h.Keys.CreateKey(r.Context(), request.Name, request.Scopes, request.UserID, request.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrBadScope) {
+			writeResponse(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.Error("Error creating API key", "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	response := models.APIResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "API key created, store it now as it won't be shown again",
+		Data:       apiKeyResponse(key, rawKey),
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *APIKeyHandler) RotateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	// the body is optional; a chunked one has no ContentLength, so only an
+	// empty body means the defaults
+	gracePeriod := defaultRotationGracePeriod
+	if r.Body != nil && r.Body != http.NoBody {
+		var request models.APIKeyRotateRequest
+		err := DecodeRequest(r, &request)
+		switch {
+		case err == io.EOF:
+		case err != nil:
+			writeResponse(w, r, http.StatusBadRequest, "Invalid request format")
+			return
+		default:
+			gracePeriod = time.Duration(request.GracePeriodSeconds) * time.Second
+		}
+	}
+
+	rawKey, key, err := h.Keys.RotateKey(r.Context(), id, gracePeriod)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "API key not found")
+			return
+		}
+		logger.Error("Error rotating API key", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to rotate API key")
+		return
+	}
+
+	response := models.APIResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "API key rotated, store it now as it won't be shown again",
+		Data:       apiKeyResponse(key, rawKey),
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *APIKeyHandler) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := h.Keys.RevokeKey(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "API key not found")
+			return
+		}
+		logger.Error("Error revoking API key", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, "API key revoked")
+}
+
+func apiKeyResponse(key db.APIKey, rawKey string) models.APIKeyResponse {
+	return models.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Key:       rawKey,
+		Scopes:    key.Scopes,
+		UserID:    key.UserID,
+		ExpiresAt: key.ExpiresAt,
+	}
+}
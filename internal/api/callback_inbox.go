@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+)
+
+// defaultInboxListLimit is how many callbacks ListHandler returns unless
+// the limit query parameter says otherwise.
+const defaultInboxListLimit = 100
+
+// CallbackInboxHandler lets operators review callbacks the inbox processor
+// gave up on and put them back in the queue.
+type CallbackInboxHandler struct {
+	Inbox db.CallbackInboxStorage
+}
+
+func NewCallbackInboxHandler(inbox db.CallbackInboxStorage) *CallbackInboxHandler {
+	return &CallbackInboxHandler{Inbox: inbox}
+}
+
+func (h *CallbackInboxHandler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.InboxStatusDead
+	}
+
+	limit := defaultInboxListLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			writeResponse(w, r, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	callbacks, err := h.Inbox.ListCallbacks(r.Context(), status, limit)
+	if err != nil {
+		logger.Error("Error listing callbacks", "status", status, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to list callbacks")
+		return
+	}
+
+	entries := make([]models.CallbackInboxResponse, 0, len(callbacks))
+	for _, cb := range callbacks {
+		entries = append(entries, models.CallbackInboxResponse{
+			ID:            cb.ID,
+			Gateway:       cb.GatewayName,
+			EventID:       cb.EventID,
+			TransactionID: cb.TransactionID,
+			ContentType:   cb.ContentType,
+			Body:          string(cb.Body),
+			Status:        cb.Status,
+			Attempts:      cb.Attempts,
+			LastError:     cb.LastError,
+			ReceivedAt:    cb.ReceivedAt,
+			ProcessedAt:   cb.ProcessedAt,
+		})
+	}
+
+	response := models.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Callbacks retrieved",
+		Data:       entries,
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *CallbackInboxHandler) RetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid callback ID")
+		return
+	}
+
+	if err := h.Inbox.RequeueCallback(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrCallbackNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "No dead callback with this ID")
+			return
+		}
+		logger.Error("Error requeueing callback", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to requeue callback")
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, "Callback requeued")
+}
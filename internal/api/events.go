@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/stream"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment so proxies
+// don't close it.
+const keepAliveInterval = 15 * time.Second
+
+// replayBatchSize is how many history rows are loaded per query on resume.
+const replayBatchSize = 500
+
+// EventStreamHandler serves transaction status changes as Server-Sent Events.
+// Clients resuming with Last-Event-ID first get what they missed from the
+// transaction history, then live events.
+type EventStreamHandler struct {
+	DB     db.Storage
+	Events db.TransactionEventStorage
+	Hub    *stream.Hub
+}
+
+func NewEventStreamHandler(dbHandler db.Storage, events db.TransactionEventStorage, hub *stream.Hub) *EventStreamHandler {
+	return &EventStreamHandler{DB: dbHandler, Events: events, Hub: hub}
+}
+
+// TransactionEventsHandler streams one transaction, starting with its full
+// history unless the client resumes.
+func (h *EventStreamHandler) TransactionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	transactionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid transaction ID")
+		return
+	}
+
+	tx, err := h.DB.GetTransactionByID(r.Context(), transactionID)
+	if err != nil {
+		writeResponse(w, r, http.StatusNotFound, "Transaction not found")
+		return
+	}
+
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok && !identity.CanActFor(tx.UserID) {
+		writeResponse(w, r, http.StatusForbidden, "API key is not allowed to act for this user")
+		return
+	}
+
+	h.serve(w, r, h.Hub.SubscribeTransaction(transactionID), db.TransactionEventFilter{TransactionID: transactionID}, true)
+}
+
+// UserEventsHandler streams every transaction of a user. Without
+// Last-Event-ID it only sends live events.
+func (h *EventStreamHandler) UserEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok && !identity.CanActFor(userID) {
+		writeResponse(w, r, http.StatusForbidden, "API key is not allowed to act for this user")
+		return
+	}
+
+	h.serve(w, r, h.Hub.SubscribeUser(userID), db.TransactionEventFilter{UserID: userID}, false)
+}
+
+func (h *EventStreamHandler) serve(w http.ResponseWriter, r *http.Request, sub *stream.Subscription, filter db.TransactionEventFilter, replayAll bool) {
+	defer h.Hub.Unsubscribe(sub)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(w, r, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	lastEventID, err := lastEventID(r)
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid Last-Event-ID")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// we subscribed before reading the history, so nothing committed in
+	// between is lost; the live events it overlaps with are skipped by ID
+	if lastEventID > 0 || replayAll {
+		filter.AfterID = lastEventID
+		filter.Limit = replayBatchSize
+		for {
+			events, err := h.Events.ListTransactionEvents(r.Context(), filter)
+			if err != nil {
+				logger.Error("Failed to replay transaction events", "error", err)
+				return
+			}
+
+			for _, event := range events {
+				if err := writeEvent(w, stream.FromDB(event)); err != nil {
+					return
+				}
+				lastEventID = event.ID
+			}
+			flusher.Flush()
+
+			if len(events) < replayBatchSize {
+				break
+			}
+			filter.AfterID = lastEventID
+		}
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.Events:
+			if !ok {
+				// dropped for falling behind or shutting down, the client resumes from lastEventID
+				return
+			}
+			if event.ID <= lastEventID {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+			flusher.Flush()
+		}
+	}
+}
+
+// lastEventID reads the resume position. Browsers send the header on
+// reconnect; the query parameter is for the first connection.
+func lastEventID(r *http.Request) (int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func writeEvent(w http.ResponseWriter, event models.TransactionStatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", event.ID, data)
+	return err
+}
@@ -1,28 +1,54 @@
 package api
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"payment-gateway/configs/logger"
 	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
 	"payment-gateway/internal/models"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/workers"
 
 	"github.com/gorilla/mux"
-	"github.com/shopspring/decimal"
 )
 
+// queueFullRetryAfterSeconds is sent as Retry-After when the worker queue is full.
+const queueFullRetryAfterSeconds = 5
+
+// maxCallbackBodySize caps how much of an unauthenticated callback we read
+// before its signature has been checked.
+const maxCallbackBodySize = 1 << 20
+
 type TransactionHandler struct {
 	DB             db.Storage
 	GatewayService services.GatewayServiceInterface
+	Verifier       signature.CallbackVerifier
+	CallbackParser callback.Parser
+	Inbox          db.CallbackInboxStorage
 }
 
-func NewTransactionHandler(db db.Storage, gatewayService services.GatewayServiceInterface) *TransactionHandler {
+func NewTransactionHandler(
+	db db.Storage,
+	gatewayService services.GatewayServiceInterface,
+	verifier signature.CallbackVerifier,
+	callbackParser callback.Parser,
+	inbox db.CallbackInboxStorage,
+) *TransactionHandler {
 	return &TransactionHandler{
 		DB:             db,
 		GatewayService: gatewayService,
+		Verifier:       verifier,
+		CallbackParser: callbackParser,
+		Inbox:          inbox,
 	}
 }
 
@@ -43,7 +69,7 @@ func (h *TransactionHandler) DepositHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if request.Amount.LessThanOrEqual(decimal.Zero) {
+	if err := request.Validate(); err != nil {
 		response := models.APIResponse{
 			StatusCode: http.StatusBadRequest,
 			Message:    "Amount must be greater than zero",
@@ -56,6 +82,19 @@ func (h *TransactionHandler) DepositHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok && !identity.CanActFor(request.UserID) {
+		response := models.APIResponse{
+			StatusCode: http.StatusForbidden,
+			Message:    "API key is not allowed to act for this user",
+		}
+		err := EncodeResponse(w, r, response)
+		if err != nil {
+			logger.Warn("Error encoding response", "error", err)
+			return
+		}
+		return
+	}
+
 	transaction := db.Transaction{
 		UserID:   request.UserID,
 		Amount:   request.Amount,
@@ -65,6 +104,20 @@ func (h *TransactionHandler) DepositHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	err := h.GatewayService.ProcessTransaction(r.Context(), transaction)
+	if errors.Is(err, workers.ErrQueueFull) {
+		logger.Warn("Transaction queue is full, rejecting deposit", "error", err)
+		w.Header().Set("Retry-After", strconv.Itoa(queueFullRetryAfterSeconds))
+		response := models.APIResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Service is busy, please retry later",
+		}
+		err := EncodeResponse(w, r, response)
+		if err != nil {
+			logger.Warn("Error encoding response", "error", err)
+			return
+		}
+		return
+	}
 	if err != nil {
 		logger.Error("Error processing deposit", "error", err)
 		response := models.APIResponse{
@@ -107,7 +160,7 @@ func (h *TransactionHandler) WithdrawalHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if request.Amount.LessThanOrEqual(decimal.Zero) {
+	if err := request.Validate(); err != nil {
 		response := models.APIResponse{
 			StatusCode: http.StatusBadRequest,
 			Message:    "Amount must be greater than zero",
@@ -120,6 +173,19 @@ func (h *TransactionHandler) WithdrawalHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if identity, ok := auth.IdentityFromContext(r.Context()); ok && !identity.CanActFor(request.UserID) {
+		response := models.APIResponse{
+			StatusCode: http.StatusForbidden,
+			Message:    "API key is not allowed to act for this user",
+		}
+		err := EncodeResponse(w, r, response)
+		if err != nil {
+			logger.Warn("Error encoding response", "error", err)
+			return
+		}
+		return
+	}
+
 	transaction := db.Transaction{
 		UserID:   request.UserID,
 		Amount:   request.Amount,
@@ -129,6 +195,20 @@ func (h *TransactionHandler) WithdrawalHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	err := h.GatewayService.ProcessTransaction(r.Context(), transaction)
+	if errors.Is(err, workers.ErrQueueFull) {
+		logger.Warn("Transaction queue is full, rejecting withdrawal", "error", err)
+		w.Header().Set("Retry-After", strconv.Itoa(queueFullRetryAfterSeconds))
+		response := models.APIResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Service is busy, please retry later",
+		}
+		err := EncodeResponse(w, r, response)
+		if err != nil {
+			logger.Warn("Error encoding response", "error", err)
+			return
+		}
+		return
+	}
 	if err != nil {
 		logger.Error("Error processing withdrawal", "error", err)
 		response := models.APIResponse{
@@ -164,26 +244,132 @@ func (h *TransactionHandler) CallbackHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var callbackData struct {
-		GatewayTxnID string `json:"gateway_txn_id" xml:"gateway_txn_id"`
-		Status       string `json:"status" xml:"status"`
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBodySize))
+	if err != nil {
+		logger.Error("Error reading callback body", "error", err)
+		http.Error(w, "Invalid callback data", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.GatewayService.GetTransactionStatus(r.Context(), transactionID)
+	if err != nil {
+		logger.Warn("Callback for unknown transaction", "id", transactionID, "error", err)
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	gateway, err := h.DB.GetGatewayByID(r.Context(), tx.GatewayID)
+	if err != nil {
+		logger.Warn("Callback for transaction without a known gateway", "id", transactionID, "gatewayID", tx.GatewayID, "error", err)
+		http.Error(w, "Invalid callback signature", http.StatusUnauthorized)
+		return
+	}
+
+	// the signature covers the raw bytes, so it must be checked before decoding
+	stamp, err := h.Verifier.Verify(r.Context(), gateway.Name, r.Header, body)
+	if err != nil {
+		logger.Warn("Rejected callback with invalid signature", "id", transactionID, "gateway", gateway.Name, "error", err)
+		http.Error(w, "Invalid callback signature", http.StatusUnauthorized)
+		return
+	}
+
+	h.acceptCallback(w, r, gateway, &transactionID, body, stamp)
+}
+
+// GatewayCallbackHandler accepts callbacks on a per-gateway route, so the
+// gateway is taken from the URL and the transaction is looked up by the
+// gateway's own reference instead of our ID.
+func (h *TransactionHandler) GatewayCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayName := mux.Vars(r)["gateway"]
+
+	gateway, err := h.DB.GetGatewayByName(r.Context(), gatewayName)
+	if err != nil {
+		logger.Warn("Callback for unknown gateway", "gateway", gatewayName, "error", err)
+		http.Error(w, "Unknown gateway", http.StatusNotFound)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&callbackData); err != nil {
-		logger.Error("Error decoding callback data", "error", err)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBodySize))
+	if err != nil {
+		logger.Error("Error reading callback body", "error", err)
 		http.Error(w, "Invalid callback data", http.StatusBadRequest)
 		return
 	}
 
-	err = h.GatewayService.HandleCallback(r.Context(), callbackData.GatewayTxnID, callbackData.Status, transactionID)
+	stamp, err := h.Verifier.Verify(r.Context(), gateway.Name, r.Header, body)
 	if err != nil {
-		logger.Error("Error processing callback", "error", err)
-		http.Error(w, "Failed to process callback", http.StatusInternalServerError)
+		logger.Warn("Rejected callback with invalid signature", "gateway", gateway.Name, "error", err)
+		http.Error(w, "Invalid callback signature", http.StatusUnauthorized)
 		return
 	}
 
+	// the transaction is resolved from the gateway's reference by the inbox
+	// processor, which retries if we haven't stored the reference yet
+	h.acceptCallback(w, r, gateway, nil, body, stamp)
+}
+
+// callbackContentType falls back to the gateway's configured data format
+// when the callback doesn't say what it is.
+func callbackContentType(r *http.Request, gateway db.Gateway) string {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+
+	if strings.EqualFold(gateway.DataFormatSupported, "XML") {
+		return "application/xml"
+	}
+	return "application/json"
+}
+
+// acceptCallback stores a verified callback in the inbox and acknowledges it.
+// Processing happens in internal/inbox, so a slow or failing update never
+// turns into a gateway retry storm. The nonce is only used up once the
+// callback is stored, so the gateway can retry a failed store.
+func (h *TransactionHandler) acceptCallback(w http.ResponseWriter, r *http.Request, gateway db.Gateway, transactionID *int, body []byte, stamp signature.Stamp) {
+	contentType := callbackContentType(r, gateway)
+
+	// malformed callbacks are rejected up front, the gateway should know
+	payload, err := h.CallbackParser.Parse(gateway.Name, contentType, body)
+	if err != nil {
+		logger.Error("Error decoding callback data", "gateway", gateway.Name, "error", err)
+		http.Error(w, "Invalid callback data", http.StatusBadRequest)
+		return
+	}
+
+	eventID := payload.EventID
+	if eventID == "" {
+		// without an event ID, identical redeliveries are still deduplicated
+		sum := sha256.Sum256(body)
+		eventID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	id, duplicate, err := h.Inbox.EnqueueCallback(r.Context(), db.InboxCallback{
+		GatewayID:     gateway.ID,
+		EventID:       eventID,
+		TransactionID: transactionID,
+		ContentType:   contentType,
+		Body:          body,
+	})
+	if err != nil {
+		// nothing was stored, so the gateway has to retry
+		logger.Error("Error storing callback", "gateway", gateway.Name, "error", err)
+		http.Error(w, "Failed to store callback", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Verifier.MarkUsed(r.Context(), gateway.Name, stamp); err != nil {
+		// the callback is stored, a replay would only be deduplicated
+		logger.Warn("Failed to record callback nonce", "gateway", gateway.Name, "eventID", eventID, "error", err)
+	}
+
+	if duplicate {
+		logger.Info("Duplicate callback ignored", "gateway", gateway.Name, "eventID", eventID)
+	} else {
+		logger.Info("Callback accepted", "gateway", gateway.Name, "eventID", eventID, "inboxID", id)
+	}
+
 	w.WriteHeader(http.StatusOK)
-	_, err = w.Write([]byte("Callback processed successfully"))
+	_, err = w.Write([]byte("Callback accepted"))
 	if err != nil {
 		logger.Warn("Error writing response", "error", err)
 		return
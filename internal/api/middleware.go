@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/ratelimit"
+)
+
+// IPRateLimitMiddleware limits requests by the address they came from. It
+// runs before authentication, so it also covers attempts with invalid keys
+// and the gateway callbacks, which carry no key. The address is the peer's,
+// or the last one in clientIPHeader when a trusted proxy sets it; anything
+// before that in the header comes from the client and could be forged.
+func IPRateLimitMiddleware(limiter ratelimit.Limiter, clientIPHeader string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tightest, allowed := applyRateLimit(w, r, limiter, []string{"ip:" + clientIP(r, clientIPHeader)}, nil)
+			if !allowed {
+				return
+			}
+			if tightest != nil {
+				r = r.WithContext(context.WithValue(r.Context(), rateLimitContextKey{}, tightest))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitMiddleware applies the limiter to every key the caller can be
+// attributed to: its API key, and its user, which is the one the key is
+// restricted to or, for unrestricted keys, the user_id of the request. It
+// runs after AuthMiddleware, the identity being known by then.
+func RateLimitMiddleware(limiter ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			previous, _ := r.Context().Value(rateLimitContextKey{}).(*ratelimit.Result)
+			if _, allowed := applyRateLimit(w, r, limiter, rateLimitKeys(r), previous); !allowed {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitContextKey holds the tightest bucket of the IP limit, for
+// RateLimitMiddleware to take into account in the headers.
+type rateLimitContextKey struct{}
+
+// applyRateLimit takes a token from the bucket of each key, stopping at the
+// first empty one, and sets the headers for the most restrictive bucket,
+// starting from tightest. It answers 429 and reports false if the request
+// is over a limit.
+func applyRateLimit(w http.ResponseWriter, r *http.Request, limiter ratelimit.Limiter, keys []string, tightest *ratelimit.Result) (*ratelimit.Result, bool) {
+	for _, key := range keys {
+		result, err := limiter.Allow(r.Context(), key)
+		if err != nil {
+			// fail open: Redis being down shouldn't take payments down with it
+			logger.Warn("Rate limiter unavailable, allowing request", "key", key, "error", err)
+			continue
+		}
+
+		if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+			tightest = &result
+		}
+
+		if !result.Allowed {
+			break
+		}
+	}
+
+	if tightest == nil {
+		return nil, true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.ResetAfter.Seconds())))
+
+	if !tightest.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter.Seconds())))
+		writeResponse(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
+		return tightest, false
+	}
+	return tightest, true
+}
+
+func rateLimitKeys(r *http.Request) []string {
+	identity, ok := auth.IdentityFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+
+	keys := []string{"apikey:" + strconv.Itoa(identity.KeyID)}
+	if identity.UserID != nil {
+		keys = append(keys, "user:"+strconv.Itoa(*identity.UserID))
+	} else if userID, ok := requestUserID(r); ok {
+		keys = append(keys, "user:"+strconv.Itoa(userID))
+	}
+	return keys
+}
+
+// requestUserID reads the user_id of a request body, putting the body back
+// for the handler.
+func requestUserID(r *http.Request) (int, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return 0, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 0, false
+	}
+
+	peek := *r
+	peek.Body = io.NopCloser(bytes.NewReader(body))
+	var request struct {
+		UserID *int `json:"user_id" xml:"user_id"`
+	}
+	if err := DecodeRequest(&peek, &request); err != nil || request.UserID == nil {
+		return 0, false
+	}
+	return *request.UserID, true
+}
+
+// clientIP returns the last address in header, if set, or the peer address.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		if values := r.Header.Values(header); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(seconds float64) int {
+	return int(math.Ceil(seconds))
+}
+
+// AuthMiddleware authenticates the X-API-Key header and attaches the caller
+// identity to the request context.
+func AuthMiddleware(authenticator auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rawKey := r.Header.Get("X-API-Key")
+			if rawKey == "" {
+				writeResponse(w, r, http.StatusUnauthorized, "Missing API key")
+				return
+			}
+
+			identity, err := authenticator.Authenticate(r.Context(), rawKey)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrExpiredKey) || errors.Is(err, auth.ErrRevokedKey) {
+					writeResponse(w, r, http.StatusUnauthorized, "Invalid API key")
+					return
+				}
+				logger.Error("Error authenticating API key", "error", err)
+				writeResponse(w, r, http.StatusInternalServerError, "Failed to authenticate request")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// RequireScope rejects callers whose identity lacks scope.
+func RequireScope(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := auth.IdentityFromContext(r.Context())
+		if !ok || !identity.HasScope(scope) {
+			writeResponse(w, r, http.StatusForbidden, "API key lacks the required scope")
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	response := models.APIResponse{
+		StatusCode: statusCode,
+		Message:    message,
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
@@ -1,35 +1,83 @@
 package api
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
 	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/requestid"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/stream"
 )
 
-func SetupRouter(dbHandler db.Storage, gatewayService services.GatewayServiceInterface) *mux.Router {
+func SetupRouter(
+	dbHandler db.Storage,
+	gatewayService services.GatewayServiceInterface,
+	limiter ratelimit.Limiter,
+	ipLimiter ratelimit.Limiter,
+	clientIPHeader string,
+	keyManager auth.KeyManager,
+	verifier signature.CallbackVerifier,
+	parser callback.Parser,
+	inbox db.CallbackInboxStorage,
+	webhooks db.WebhookStorage,
+	events db.TransactionEventStorage,
+	hub *stream.Hub,
+) *mux.Router {
 	router := mux.NewRouter()
 
-	handler := NewTransactionHandler(dbHandler, gatewayService)
+	handler := NewTransactionHandler(dbHandler, gatewayService, verifier, parser, inbox)
+	apiKeyHandler := NewAPIKeyHandler(keyManager)
+	inboxHandler := NewCallbackInboxHandler(inbox)
+	webhookHandler := NewWebhookHandler(webhooks)
+	eventHandler := NewEventStreamHandler(dbHandler, events, hub)
 
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			requestID := uuid.New().String()
-			ctx := context.WithValue(r.Context(), "requestID", requestID)
+			ctx := requestid.NewContext(r.Context(), requestID)
 
 			w.Header().Set("X-Request-ID", requestID)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	})
+	// before authentication, so guessing keys and flooding callbacks are limited too
+	router.Use(IPRateLimitMiddleware(ipLimiter, clientIPHeader))
 
-	router.HandleFunc("/deposit", handler.DepositHandler).Methods("POST")
-	router.HandleFunc("/withdrawal", handler.WithdrawalHandler).Methods("POST")
+	// gateways don't hold API keys, callbacks are authenticated by their signature
 	router.HandleFunc("/callback/{id:[0-9]+}", handler.CallbackHandler).Methods("POST")
+	router.HandleFunc("/callbacks/{gateway:[A-Za-z0-9_-]+}", handler.GatewayCallbackHandler).Methods("POST")
+
+	authenticated := router.NewRoute().Subrouter()
+	authenticated.Use(AuthMiddleware(keyManager))
+	authenticated.Use(RateLimitMiddleware(limiter))
+
+	authenticated.Handle("/deposit", RequireScope(auth.ScopeDeposit, handler.DepositHandler)).Methods("POST")
+	authenticated.Handle("/withdrawal", RequireScope(auth.ScopeWithdrawal, handler.WithdrawalHandler)).Methods("POST")
+	authenticated.Handle("/transactions/{id:[0-9]+}/events", RequireScope(auth.ScopeRead, eventHandler.TransactionEventsHandler)).Methods("GET")
+	authenticated.Handle("/users/{id:[0-9]+}/events", RequireScope(auth.ScopeRead, eventHandler.UserEventsHandler)).Methods("GET")
+	authenticated.Handle("/metrics", RequireScope(auth.ScopeAdmin, metrics.Handler().ServeHTTP)).Methods("GET")
+
+	authenticated.Handle("/api-keys", RequireScope(auth.ScopeAdmin, apiKeyHandler.CreateHandler)).Methods("POST")
+	authenticated.Handle("/api-keys/{id:[0-9]+}/rotate", RequireScope(auth.ScopeAdmin, apiKeyHandler.RotateHandler)).Methods("POST")
+	authenticated.Handle("/api-keys/{id:[0-9]+}", RequireScope(auth.ScopeAdmin, apiKeyHandler.RevokeHandler)).Methods("DELETE")
+
+	authenticated.Handle("/callback-inbox", RequireScope(auth.ScopeAdmin, inboxHandler.ListHandler)).Methods("GET")
+	authenticated.Handle("/callback-inbox/{id:[0-9]+}/retry", RequireScope(auth.ScopeAdmin, inboxHandler.RetryHandler)).Methods("POST")
+
+	authenticated.Handle("/webhooks", RequireScope(auth.ScopeWebhooks, webhookHandler.CreateHandler)).Methods("POST")
+	authenticated.Handle("/webhooks", RequireScope(auth.ScopeWebhooks, webhookHandler.ListHandler)).Methods("GET")
+	authenticated.Handle("/webhooks/{id:[0-9]+}", RequireScope(auth.ScopeWebhooks, webhookHandler.DeleteHandler)).Methods("DELETE")
+	authenticated.Handle("/webhooks/{id:[0-9]+}/deliveries", RequireScope(auth.ScopeWebhooks, webhookHandler.DeliveriesHandler)).Methods("GET")
+	authenticated.Handle("/webhook-deliveries/{id:[0-9]+}/redeliver", RequireScope(auth.ScopeWebhooks, webhookHandler.RedeliverHandler)).Methods("POST")
 
 	return router
 }
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/webhook"
+)
+
+// defaultDeliveryListLimit is how many deliveries DeliveriesHandler returns
+// unless the limit query parameter says otherwise.
+const defaultDeliveryListLimit = 100
+
+// webhookSecretPrefix makes leaked signing secrets easy to recognise.
+const webhookSecretPrefix = "whsec_"
+
+// WebhookHandler manages merchant webhook subscriptions and their delivery
+// log. Keys bound to a user only see and create subscriptions for that user.
+type WebhookHandler struct {
+	Store db.WebhookStorage
+}
+
+func NewWebhookHandler(store db.WebhookStorage) *WebhookHandler {
+	return &WebhookHandler{Store: store}
+}
+
+func (h *WebhookHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.WebhookSubscriptionRequest
+
+	if err := DecodeRequest(r, &request); err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	if err := validateSubscription(request); err != nil {
+		writeResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		logger.Error("Error generating webhook secret", "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to create webhook subscription")
+		return
+	}
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+
+	sub := db.WebhookSubscription{
+		URL:        request.URL,
+		Secret:     secret,
+		EventTypes: request.EventTypes,
+		UserID:     identity.UserID,
+	}
+
+	sub.ID, err = h.Store.CreateWebhookSubscription(r.Context(), sub)
+	if err != nil {
+		logger.Error("Error creating webhook subscription", "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to create webhook subscription")
+		return
+	}
+
+	data := webhookSubscriptionResponse(sub)
+	data.Secret = secret
+
+	response := models.APIResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "Webhook subscription created, store the secret now as it won't be shown again",
+		Data:       data,
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *WebhookHandler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	identity, _ := auth.IdentityFromContext(r.Context())
+
+	subs, err := h.Store.ListWebhookSubscriptions(r.Context(), identity.UserID)
+	if err != nil {
+		logger.Error("Error listing webhook subscriptions", "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to list webhook subscriptions")
+		return
+	}
+
+	entries := make([]models.WebhookSubscriptionResponse, 0, len(subs))
+	for _, sub := range subs {
+		entries = append(entries, webhookSubscriptionResponse(sub))
+	}
+
+	response := models.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Webhook subscriptions retrieved",
+		Data:       entries,
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *WebhookHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	if err := h.Store.DeactivateWebhookSubscription(r.Context(), sub.ID); err != nil {
+		if errors.Is(err, db.ErrWebhookSubscriptionNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "Webhook subscription not found")
+			return
+		}
+		logger.Error("Error deactivating webhook subscription", "id", sub.ID, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to delete webhook subscription")
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, "Webhook subscription deleted")
+}
+
+func (h *WebhookHandler) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveryListLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			writeResponse(w, r, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	status := r.URL.Query().Get("status")
+
+	deliveries, err := h.Store.ListWebhookDeliveries(r.Context(), sub.ID, status, limit)
+	if err != nil {
+		logger.Error("Error listing webhook deliveries", "subscriptionID", sub.ID, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to list webhook deliveries")
+		return
+	}
+
+	entries := make([]models.WebhookDeliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		entries = append(entries, models.WebhookDeliveryResponse{
+			ID:             d.ID,
+			SubscriptionID: d.SubscriptionID,
+			EventID:        d.EventID,
+			EventType:      d.EventType,
+			TransactionID:  d.TransactionID,
+			Payload:        string(d.Payload),
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			LastStatusCode: d.LastStatusCode,
+			LastError:      d.LastError,
+			NextAttemptAt:  d.NextAttemptAt,
+			CreatedAt:      d.CreatedAt,
+			DeliveredAt:    d.DeliveredAt,
+		})
+	}
+
+	response := models.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Webhook deliveries retrieved",
+		Data:       entries,
+	}
+	if err := EncodeResponse(w, r, response); err != nil {
+		logger.Warn("Error encoding response", "error", err)
+	}
+}
+
+func (h *WebhookHandler) RedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := h.Store.GetWebhookDelivery(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "Webhook delivery not found")
+			return
+		}
+		logger.Error("Error getting webhook delivery", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+
+	// deliveries of deleted subscriptions are never sent again
+	if _, ok := h.subscription(w, r, strconv.Itoa(delivery.SubscriptionID)); !ok {
+		return
+	}
+
+	if err := h.Store.RedeliverWebhook(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrWebhookDeliveryNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "Webhook delivery not found")
+			return
+		}
+		logger.Error("Error redelivering webhook", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, "Webhook delivery queued")
+}
+
+// subscription loads an active subscription the caller may manage and writes
+// the error response if there isn't one. Other users' subscriptions are
+// reported as missing rather than forbidden.
+func (h *WebhookHandler) subscription(w http.ResponseWriter, r *http.Request, idStr string) (db.WebhookSubscription, bool) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeResponse(w, r, http.StatusBadRequest, "Invalid webhook subscription ID")
+		return db.WebhookSubscription{}, false
+	}
+
+	sub, err := h.Store.GetWebhookSubscription(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrWebhookSubscriptionNotFound) {
+			writeResponse(w, r, http.StatusNotFound, "Webhook subscription not found")
+			return db.WebhookSubscription{}, false
+		}
+		logger.Error("Error getting webhook subscription", "id", id, "error", err)
+		writeResponse(w, r, http.StatusInternalServerError, "Failed to get webhook subscription")
+		return db.WebhookSubscription{}, false
+	}
+
+	identity, _ := auth.IdentityFromContext(r.Context())
+	if identity.UserID != nil && (sub.UserID == nil || *sub.UserID != *identity.UserID) {
+		writeResponse(w, r, http.StatusNotFound, "Webhook subscription not found")
+		return db.WebhookSubscription{}, false
+	}
+
+	return sub, true
+}
+
+func validateSubscription(request models.WebhookSubscriptionRequest) error {
+	if err := webhook.ValidateURL(request.URL); err != nil {
+		return err
+	}
+
+	if len(request.EventTypes) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, eventType := range request.EventTypes {
+		if !webhook.IsEventType(eventType) {
+			return fmt.Errorf("unknown event type: %s", eventType)
+		}
+	}
+
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	return webhookSecretPrefix + hex.EncodeToString(buf), nil
+}
+
+func webhookSubscriptionResponse(sub db.WebhookSubscription) models.WebhookSubscriptionResponse {
+	return models.WebhookSubscriptionResponse{
+		ID:         sub.ID,
+		URL:        sub.URL,
+		EventTypes: sub.EventTypes,
+		UserID:     sub.UserID,
+		CreatedAt:  sub.CreatedAt,
+	}
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+)
+
+const (
+	ScopeDeposit    = "deposit"
+	ScopeWithdrawal = "withdrawal"
+	ScopeRead       = "read"
+	ScopeWebhooks   = "webhooks"
+	ScopeAdmin      = "admin"
+)
+
+// keyPrefix makes leaked keys easy to recognise by secret scanners.
+const keyPrefix = "pgw_"
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	ErrExpiredKey = errors.New("api key expired")
+	ErrRevokedKey = errors.New("api key revoked")
+	ErrBadScope   = errors.New("unknown scope")
+)
+
+// Identity is the authenticated caller attached to the request context.
+type Identity struct {
+	KeyID  int
+	Name   string
+	Scopes []string
+	UserID *int
+}
+
+// HasScope reports whether the caller may use scope. Admin implies every scope.
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActFor reports whether the caller may operate on behalf of userID.
+func (i Identity) CanActFor(userID int) bool {
+	return i.UserID == nil || *i.UserID == userID
+}
+
+type contextKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, rawKey string) (Identity, error)
+}
+
+type KeyManager interface {
+	Authenticator
+	CreateKey(ctx context.Context, name string, scopes []string, userID *int, expiresAt *time.Time) (string, db.APIKey, error)
+	RotateKey(ctx context.Context, id int, gracePeriod time.Duration) (string, db.APIKey, error)
+	RevokeKey(ctx context.Context, id int) error
+}
+
+var _ KeyManager = (*KeyService)(nil)
+
+type KeyService struct {
+	DB db.APIKeyStorage
+}
+
+func NewKeyService(db db.APIKeyStorage) KeyManager {
+	return &KeyService{DB: db}
+}
+
+func (s *KeyService) Authenticate(ctx context.Context, rawKey string) (Identity, error) {
+	key, err := s.DB.GetAPIKeyByHash(ctx, HashKey(rawKey))
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return Identity{}, ErrInvalidKey
+		}
+		return Identity{}, err
+	}
+
+	now := time.Now()
+
+	if key.RevokedAt != nil {
+		return Identity{}, ErrRevokedKey
+	}
+
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return Identity{}, ErrExpiredKey
+	}
+
+	if err := s.DB.TouchAPIKey(ctx, key.ID, now); err != nil {
+		// usage tracking must not block an authenticated request
+		logger.Warn("Failed to record api key usage", "keyID", key.ID, "error", err)
+	}
+
+	return Identity{
+		KeyID:  key.ID,
+		Name:   key.Name,
+		Scopes: key.Scopes,
+		UserID: key.UserID,
+	}, nil
+}
+
+// CreateKey stores a new key and returns its raw value. The raw value is
+// only available here; afterwards only its hash is known.
+func (s *KeyService) CreateKey(ctx context.Context, name string, scopes []string, userID *int, expiresAt *time.Time) (string, db.APIKey, error) {
+	if err := validateScopes(scopes); err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	rawKey, err := generateKey()
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	key := db.APIKey{
+		Name:      name,
+		KeyHash:   HashKey(rawKey),
+		Scopes:    scopes,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}
+
+	key.ID, err = s.DB.CreateAPIKey(ctx, key)
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	return rawKey, key, nil
+}
+
+// RotateKey issues a replacement with the same name, scopes and user, and
+// keeps the old key valid for gracePeriod.
+func (s *KeyService) RotateKey(ctx context.Context, id int, gracePeriod time.Duration) (string, db.APIKey, error) {
+	old, err := s.DB.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	rawKey, err := generateKey()
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	key := db.APIKey{
+		Name:      old.Name,
+		KeyHash:   HashKey(rawKey),
+		Scopes:    old.Scopes,
+		UserID:    old.UserID,
+		ExpiresAt: old.ExpiresAt,
+	}
+
+	key.ID, err = s.DB.RotateAPIKey(ctx, id, key, time.Now().Add(gracePeriod))
+	if err != nil {
+		return "", db.APIKey{}, err
+	}
+
+	return rawKey, key, nil
+}
+
+func (s *KeyService) RevokeKey(ctx context.Context, id int) error {
+	return s.DB.RevokeAPIKey(ctx, id)
+}
+
+func HashKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %v", err)
+	}
+	return keyPrefix + hex.EncodeToString(buf), nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrBadScope)
+	}
+
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeDeposit, ScopeWithdrawal, ScopeRead, ScopeWebhooks, ScopeAdmin:
+		default:
+			return fmt.Errorf("%w: %s", ErrBadScope, scope)
+		}
+	}
+
+	return nil
+}
+
+// EnsureBootstrapKey makes sure rawKey exists as an admin key, so a fresh
+// deployment has a way to create the rest of its keys.
+func EnsureBootstrapKey(ctx context.Context, store db.APIKeyStorage, rawKey string) error {
+	keyHash := HashKey(rawKey)
+
+	_, err := store.GetAPIKeyByHash(ctx, keyHash)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, db.ErrAPIKeyNotFound) {
+		return err
+	}
+
+	_, err = store.CreateAPIKey(ctx, db.APIKey{
+		Name:    "bootstrap-admin",
+		KeyHash: keyHash,
+		Scopes:  []string{ScopeAdmin},
+	})
+	return err
+}
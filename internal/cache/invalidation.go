@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"payment-gateway/configs/logger"
+)
+
+// dbInvalidationChannel matches the NOTIFY channel used by the triggers in
+// db/migrations/init.sql.
+const dbInvalidationChannel = "cache_invalidation"
+
+// invalidationLockNamespace is the first key of the advisory lock electing
+// the replica that applies database invalidations, see
+// lock.advisoryNamespace and db.outboxLockNamespace for the others.
+const invalidationLockNamespace = 1003
+
+// invalidationElectionInterval is how often a replica that isn't applying
+// invalidations tries to take over, and the leader checks it still holds
+// the lock.
+const invalidationElectionInterval = 5 * time.Second
+
+// ListenDBInvalidations turns row changes on users and gateway_countries into
+// cache invalidations. Payloads are "user:<id>" or "country:<id>".
+//
+// Every replica receives each NOTIFY, but only the one holding the advisory
+// lock applies it: its Invalidate clears Redis once and its broadcast clears
+// the local tier of every replica. Notifications arriving while no replica
+// holds the lock are lost, the TTLs cover them.
+func ListenDBInvalidations(ctx context.Context, database *sql.DB, dataSourceName string, c Cache) error {
+	listener := pq.NewListener(dataSourceName, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("Cache invalidation listener event", "event", event, "error", err)
+		}
+	})
+
+	if err := listener.Listen(dbInvalidationChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	var leader atomic.Bool
+	go holdInvalidationLock(ctx, database, &leader)
+
+	go func() {
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// nil notification means the connection was re-established
+				// and we may have missed events, nothing to do but carry on
+				if n == nil || !leader.Load() {
+					continue
+				}
+
+				key, ok := invalidationKey(n.Extra)
+				if !ok {
+					logger.Warn("Unknown cache invalidation payload", "payload", n.Extra)
+					continue
+				}
+
+				if err := c.Invalidate(ctx, key); err != nil {
+					logger.Warn("Failed to invalidate cache key", "key", key, "error", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// holdInvalidationLock keeps trying to take the invalidation lock, on a
+// connection of its own since session-level advisory locks belong to one,
+// and reports in leader whether this replica holds it.
+func holdInvalidationLock(ctx context.Context, database *sql.DB, leader *atomic.Bool) {
+	ticker := time.NewTicker(invalidationElectionInterval)
+	defer ticker.Stop()
+
+	var conn *sql.Conn
+	defer func() {
+		leader.Store(false)
+		if conn != nil {
+			// the connection goes back to the pool, the lock must not
+			conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, 0)`, invalidationLockNamespace)
+			conn.Close()
+		}
+	}()
+
+	for {
+		// a lost connection took the lock with it
+		if conn != nil && conn.PingContext(ctx) != nil {
+			logger.Warn("Lost the cache invalidation lock")
+			leader.Store(false)
+			conn.Close()
+			conn = nil
+		}
+
+		if conn == nil {
+			if conn = tryInvalidationLock(ctx, database); conn != nil {
+				logger.Info("Applying database cache invalidations for all replicas")
+				leader.Store(true)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryInvalidationLock returns the connection holding the invalidation lock,
+// or nil if another replica has it.
+func tryInvalidationLock(ctx context.Context, database *sql.DB) *sql.Conn {
+	conn, err := database.Conn(ctx)
+	if err != nil {
+		return nil
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, 0)`, invalidationLockNamespace).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+func invalidationKey(payload string) (string, bool) {
+	kind, idStr, found := strings.Cut(payload, ":")
+	if !found {
+		return "", false
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "", false
+	}
+
+	switch kind {
+	case "user":
+		return UserKey(id), true
+	case "country":
+		return GatewaysByCountryKey(id), true
+	default:
+		return "", false
+	}
+}
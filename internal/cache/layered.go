@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"payment-gateway/db"
+)
+
+// Stats is a point-in-time snapshot of LayeredCache counters.
+type Stats struct {
+	Hits         uint64
+	Misses       uint64
+	NegativeHits uint64
+	Loads        uint64
+	SharedLoads  uint64
+}
+
+var _ Cache = (*LayeredCache)(nil)
+
+// Local entries and loads are kept apart per accessor: Get returns the raw
+// string stored under the key GetUser returns decoded.
+const (
+	localRaw      = "raw|"
+	localGateways = "gateways|"
+	localUser     = "user|"
+)
+
+// LayeredCache keeps a short-lived in-process LRU in front of a remote Cache
+// (normally RedisCache). Concurrent misses for the same key are collapsed
+// into a single remote load, and empty results are cached negatively so that
+// countries without gateways don't hit Redis/Postgres on every job.
+type LayeredCache struct {
+	remote      Cache
+	local       *lru
+	group       singleflight.Group
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	negativeHits atomic.Uint64
+	loads        atomic.Uint64
+	sharedLoads  atomic.Uint64
+}
+
+func NewLayeredCache(remote Cache, size int, ttl, negativeTTL time.Duration) *LayeredCache {
+	return &LayeredCache{
+		remote:      remote,
+		local:       newLRU(size),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func (c *LayeredCache) Get(ctx context.Context, key string) (string, error) {
+	localKey := localRaw + key
+	if val, ok := c.local.get(localKey); ok {
+		c.hits.Add(1)
+		return val.(string), nil
+	}
+	c.misses.Add(1)
+
+	// the load is shared, one caller giving up must not fail the others
+	loadCtx := context.WithoutCancel(ctx)
+	val, err, shared := c.group.Do(localKey, func() (interface{}, error) {
+		c.loads.Add(1)
+		return c.remote.Get(loadCtx, key)
+	})
+	if shared {
+		c.sharedLoads.Add(1)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	c.local.set(localKey, val, c.ttl)
+	return val.(string), nil
+}
+
+func (c *LayeredCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	// drop the local copy first so a failed remote write can't leave it stale
+	c.deleteLocal(key)
+	return c.remote.Set(ctx, key, value, expiration)
+}
+
+func (c *LayeredCache) GetGatewaysByCountry(ctx context.Context, dbHandler db.Storage, countryID int) ([]db.Gateway, error) {
+	localKey := localGateways + GatewaysByCountryKey(countryID)
+
+	if val, ok := c.local.get(localKey); ok {
+		gateways := val.([]db.Gateway)
+		if len(gateways) == 0 {
+			c.negativeHits.Add(1)
+		} else {
+			c.hits.Add(1)
+		}
+		return gateways, nil
+	}
+	c.misses.Add(1)
+
+	loadCtx := context.WithoutCancel(ctx)
+	val, err, shared := c.group.Do(localKey, func() (interface{}, error) {
+		c.loads.Add(1)
+		return c.remote.GetGatewaysByCountry(loadCtx, dbHandler, countryID)
+	})
+	if shared {
+		c.sharedLoads.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gateways := val.([]db.Gateway)
+	if len(gateways) == 0 {
+		c.local.set(localKey, gateways, c.negativeTTL)
+	} else {
+		c.local.set(localKey, gateways, c.ttl)
+	}
+
+	return gateways, nil
+}
+
+func (c *LayeredCache) GetUser(ctx context.Context, dbHandler db.Storage, userID int) (db.User, error) {
+	localKey := localUser + UserKey(userID)
+
+	if val, ok := c.local.get(localKey); ok {
+		c.hits.Add(1)
+		return val.(db.User), nil
+	}
+	c.misses.Add(1)
+
+	loadCtx := context.WithoutCancel(ctx)
+	val, err, shared := c.group.Do(localKey, func() (interface{}, error) {
+		c.loads.Add(1)
+		return c.remote.GetUser(loadCtx, dbHandler, userID)
+	})
+	if shared {
+		c.sharedLoads.Add(1)
+	}
+	if err != nil {
+		return db.User{}, err
+	}
+
+	user := val.(db.User)
+	c.local.set(localKey, user, c.ttl)
+
+	return user, nil
+}
+
+func (c *LayeredCache) GetRoute(ctx context.Context, dbHandler db.Storage, userID int) (Route, error) {
+	return resolveRoute(ctx, c, dbHandler, userID)
+}
+
+func (c *LayeredCache) Invalidate(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.deleteLocal(key)
+	}
+	return c.remote.Invalidate(ctx, keys...)
+}
+
+// deleteLocal drops the local entries of key of every accessor.
+func (c *LayeredCache) deleteLocal(key string) {
+	for _, prefix := range []string{localRaw, localGateways, localUser} {
+		c.local.delete(prefix + key)
+	}
+}
+
+// ListenForInvalidations drops local entries whenever another replica
+// invalidates them. It is a no-op if the remote cache can't notify.
+func (c *LayeredCache) ListenForInvalidations(ctx context.Context) {
+	source, ok := c.remote.(InvalidationSource)
+	if !ok {
+		return
+	}
+
+	source.SubscribeInvalidations(ctx, c.deleteLocal)
+}
+
+// Stats returns the current hit/miss counters.
+func (c *LayeredCache) Stats() Stats {
+	return Stats{
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Loads:        c.loads.Load(),
+		SharedLoads:  c.sharedLoads.Load(),
+	}
+}
@@ -11,18 +11,52 @@ import (
 	"payment-gateway/db"
 )
 
+// keyVersion is part of every typed cache key. Bump it whenever the cached
+// representation of a user or route changes so old entries are never decoded.
+const keyVersion = "v1"
+
+// invalidationChannel is the Redis pub/sub channel invalidated keys are announced on.
+const invalidationChannel = "cache:invalidate"
+
 type Cache interface {
 	GetGatewaysByCountry(ctx context.Context, dbHandler db.Storage, countryID int) ([]db.Gateway, error)
+	GetUser(ctx context.Context, dbHandler db.Storage, userID int) (db.User, error)
+	GetRoute(ctx context.Context, dbHandler db.Storage, userID int) (Route, error)
+	Invalidate(ctx context.Context, keys ...string) error
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 	Get(ctx context.Context, key string) (string, error)
 }
 
+// InvalidationSource is implemented by caches that can notify about keys
+// invalidated by other replicas.
+type InvalidationSource interface {
+	SubscribeInvalidations(ctx context.Context, handler func(key string))
+}
+
+// Route is the resolved, priority-ordered list of gateways for a user.
+type Route struct {
+	UserID    int
+	CountryID int
+	Gateways  []db.Gateway
+}
+
+var (
+	_ Cache              = (*RedisCache)(nil)
+	_ InvalidationSource = (*RedisCache)(nil)
+)
+
 type RedisCache struct {
-	client *redis.Client
+	client   *redis.Client
+	userTTL  time.Duration
+	routeTTL time.Duration
 }
 
-func NewRedisCache(client *redis.Client) Cache {
-	return &RedisCache{client: client}
+func NewRedisCache(client *redis.Client, userTTL, routeTTL time.Duration) *RedisCache {
+	return &RedisCache{
+		client:   client,
+		userTTL:  userTTL,
+		routeTTL: routeTTL,
+	}
 }
 
 func InitRedis(ctx context.Context, addr string, password string) *redis.Client {
@@ -48,7 +82,7 @@ func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
 }
 
 func (c *RedisCache) GetGatewaysByCountry(ctx context.Context, dbHandler db.Storage, countryID int) ([]db.Gateway, error) {
-	cacheKey := fmt.Sprintf("gateways:country:%d", countryID)
+	cacheKey := GatewaysByCountryKey(countryID)
 
 	// Try to get from cache first
 	val, err := c.Get(ctx, cacheKey)
@@ -66,7 +100,7 @@ func (c *RedisCache) GetGatewaysByCountry(ctx context.Context, dbHandler db.Stor
 	}
 
 	gatewaysJSON, _ := json.Marshal(gateways)
-	err = c.Set(ctx, cacheKey, gatewaysJSON, 5*time.Minute)
+	err = c.Set(ctx, cacheKey, gatewaysJSON, c.routeTTL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to set cache: %v", err)
 	}
@@ -74,6 +108,105 @@ func (c *RedisCache) GetGatewaysByCountry(ctx context.Context, dbHandler db.Stor
 	return gateways, nil
 }
 
+func (c *RedisCache) GetUser(ctx context.Context, dbHandler db.Storage, userID int) (db.User, error) {
+	cacheKey := UserKey(userID)
+
+	val, err := c.Get(ctx, cacheKey)
+	if err == nil {
+		var user db.User
+		if err := json.Unmarshal([]byte(val), &user); err == nil {
+			return user, nil
+		}
+	}
+
+	user, err := dbHandler.GetUserByID(ctx, userID)
+	if err != nil {
+		return db.User{}, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	userJSON, _ := json.Marshal(user)
+	if err := c.Set(ctx, cacheKey, userJSON, c.userTTL); err != nil {
+		// the user was loaded, a cache write failure shouldn't fail the caller
+		logger.Warn("Failed to cache user", "userID", userID, "error", err)
+	}
+
+	return user, nil
+}
+
+func (c *RedisCache) GetRoute(ctx context.Context, dbHandler db.Storage, userID int) (Route, error) {
+	return resolveRoute(ctx, c, dbHandler, userID)
+}
+
+// Invalidate deletes keys and announces them so other replicas drop their
+// in-process copies.
+func (c *RedisCache) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete cache keys: %v", err)
+	}
+
+	for _, key := range keys {
+		if err := c.client.Publish(ctx, invalidationChannel, key).Err(); err != nil {
+			return fmt.Errorf("failed to publish cache invalidation: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SubscribeInvalidations calls handler for every invalidated key until ctx is done.
+func (c *RedisCache) SubscribeInvalidations(ctx context.Context, handler func(key string)) {
+	pubsub := c.client.Subscribe(ctx, invalidationChannel)
+
+	go func() {
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				handler(msg.Payload)
+			}
+		}
+	}()
+}
+
 func (c *RedisCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return c.client.Set(ctx, key, value, expiration).Err()
 }
+
+func GatewaysByCountryKey(countryID int) string {
+	return fmt.Sprintf("%s:gateways:country:%d", keyVersion, countryID)
+}
+
+func UserKey(userID int) string {
+	return fmt.Sprintf("%s:user:%d", keyVersion, userID)
+}
+
+// resolveRoute builds a Route out of the user and gateway accessors of c, so
+// each part is cached and invalidated on its own.
+func resolveRoute(ctx context.Context, c Cache, dbHandler db.Storage, userID int) (Route, error) {
+	user, err := c.GetUser(ctx, dbHandler, userID)
+	if err != nil {
+		return Route{}, err
+	}
+
+	gateways, err := c.GetGatewaysByCountry(ctx, dbHandler, user.CountryID)
+	if err != nil {
+		return Route{}, err
+	}
+
+	return Route{
+		UserID:    user.ID,
+		CountryID: user.CountryID,
+		Gateways:  gateways,
+	}, nil
+}
@@ -0,0 +1,260 @@
+package callback
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported callback content type")
+	ErrMissingField           = errors.New("callback field missing")
+	ErrInvalidField           = errors.New("callback field invalid")
+)
+
+// Payload is the gateway-independent content of a callback.
+type Payload struct {
+	EventID      string
+	GatewayTxnID string
+	Status       string
+	ErrorMessage string
+
+	// when the gateway produced the event, zero if it didn't say
+	EventTime time.Time
+	// gateway sequence number of the event, zero if it didn't send one
+	Sequence int64
+}
+
+// FieldMapping names the fields a gateway uses for each part of Payload.
+// Nested JSON and XML fields are addressed with dots, e.g. "resource.id".
+type FieldMapping struct {
+	EventID      string
+	Reference    string
+	Status       string
+	ErrorMessage string
+	EventTime    string // RFC 3339 or unix seconds
+	Sequence     string
+}
+
+// DefaultMapping matches the CallbackRequest schema in contract/http/api.yaml.
+var DefaultMapping = FieldMapping{
+	EventID:      "event_id",
+	Reference:    "gateway_txn_id",
+	Status:       "status",
+	ErrorMessage: "error_message",
+	EventTime:    "event_time",
+	Sequence:     "sequence",
+}
+
+type Parser interface {
+	Parse(gatewayName, contentType string, body []byte) (Payload, error)
+}
+
+var _ Parser = (*FieldParser)(nil)
+
+// FieldParser decodes JSON, XML and form-encoded callbacks into a flat set of
+// fields and picks the payload out of them using the gateway's mapping.
+type FieldParser struct {
+	mappings map[string]FieldMapping
+}
+
+// NewParser builds a Parser. Gateways missing from mappings use DefaultMapping.
+func NewParser(mappings map[string]FieldMapping) Parser {
+	normalized := make(map[string]FieldMapping, len(mappings))
+	for name, mapping := range mappings {
+		normalized[strings.ToLower(name)] = mapping
+	}
+
+	return &FieldParser{mappings: normalized}
+}
+
+func (p *FieldParser) Parse(gatewayName, contentType string, body []byte) (Payload, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Payload{}, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+
+	var fields map[string]string
+	switch mediaType {
+	case "application/json":
+		fields, err = jsonFields(body)
+	case "application/xml", "text/xml":
+		fields, err = xmlFields(body)
+	case "application/x-www-form-urlencoded":
+		fields, err = formFields(body)
+	default:
+		return Payload{}, fmt.Errorf("%w: %q", ErrUnsupportedContentType, mediaType)
+	}
+	if err != nil {
+		return Payload{}, fmt.Errorf("failed to decode callback: %v", err)
+	}
+
+	mapping := p.mapping(gatewayName)
+
+	payload := Payload{
+		EventID:      fields[mapping.EventID],
+		GatewayTxnID: fields[mapping.Reference],
+		Status:       fields[mapping.Status],
+		ErrorMessage: fields[mapping.ErrorMessage],
+	}
+
+	if payload.GatewayTxnID == "" {
+		return Payload{}, fmt.Errorf("%w: %s", ErrMissingField, mapping.Reference)
+	}
+	if payload.Status == "" {
+		return Payload{}, fmt.Errorf("%w: %s", ErrMissingField, mapping.Status)
+	}
+
+	if value := fields[mapping.EventTime]; value != "" {
+		payload.EventTime, err = parseEventTime(value)
+		if err != nil {
+			return Payload{}, fmt.Errorf("%w: %s: %v", ErrInvalidField, mapping.EventTime, err)
+		}
+	}
+
+	if value := fields[mapping.Sequence]; value != "" {
+		payload.Sequence, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Payload{}, fmt.Errorf("%w: %s: %v", ErrInvalidField, mapping.Sequence, err)
+		}
+	}
+
+	return payload, nil
+}
+
+func (p *FieldParser) mapping(gatewayName string) FieldMapping {
+	mapping, ok := p.mappings[strings.ToLower(gatewayName)]
+	if !ok {
+		return DefaultMapping
+	}
+
+	// fields left out of a mapping fall back to the defaults
+	if mapping.EventID == "" {
+		mapping.EventID = DefaultMapping.EventID
+	}
+	if mapping.Reference == "" {
+		mapping.Reference = DefaultMapping.Reference
+	}
+	if mapping.Status == "" {
+		mapping.Status = DefaultMapping.Status
+	}
+	if mapping.ErrorMessage == "" {
+		mapping.ErrorMessage = DefaultMapping.ErrorMessage
+	}
+	if mapping.EventTime == "" {
+		mapping.EventTime = DefaultMapping.EventTime
+	}
+	if mapping.Sequence == "" {
+		mapping.Sequence = DefaultMapping.Sequence
+	}
+
+	return mapping
+}
+
+func parseEventTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func jsonFields(body []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	flattenJSON("", doc, fields)
+
+	return fields, nil
+}
+
+func flattenJSON(prefix string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenJSON(key, child, fields)
+		}
+	case string:
+		fields[prefix] = v
+	case json.Number:
+		fields[prefix] = v.String()
+	case bool:
+		fields[prefix] = fmt.Sprint(v)
+	}
+}
+
+// xmlFields flattens leaf elements into dotted paths below the root element,
+// so <notification><pspReference>1</pspReference></notification> becomes
+// "pspReference".
+func xmlFields(body []byte) (map[string]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	fields := map[string]string{}
+	var path []string
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(path) > 1 {
+				key := strings.Join(path[1:], ".")
+				if value := strings.TrimSpace(text.String()); value != "" {
+					if _, seen := fields[key]; !seen {
+						fields[key] = value
+					}
+				}
+			}
+			path = path[:len(path)-1]
+			text.Reset()
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New("empty xml document")
+	}
+
+	return fields, nil
+}
+
+func formFields(body []byte) (map[string]string, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(values))
+	for key := range values {
+		fields[key] = values.Get(key)
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,292 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/requestid"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/utils"
+	"payment-gateway/pkg/events"
+)
+
+// Headers set on commands forwarded to the retry and dead-letter topics.
+const (
+	HeaderAttempt   = "attempt"    // failed attempts so far
+	HeaderNotBefore = "not-before" // RFC 3339 time the retry is due
+	HeaderError     = "error"      // why the last attempt failed
+)
+
+// maxRetryBackoff caps the exponential backoff between attempts.
+const maxRetryBackoff = 10 * time.Minute
+
+// errPermanent marks commands that won't succeed however often they're tried.
+var errPermanent = errors.New("permanent command failure")
+
+// Consumer turns payment commands from Kafka into transactions, through the
+// same validation and GatewayService as the HTTP API. A command's offset is
+// committed once its transaction is stored or it has been forwarded: failed
+// commands go to the retry topic with a due time, and commands that can
+// never succeed, or exhaust their attempts, to the dead-letter topic.
+type Consumer struct {
+	Commands kafka.Consumer // reads the commands topic
+	Retries  kafka.Consumer // reads the retry topic
+	Producer kafka.Producer
+	Service  services.GatewayServiceInterface
+
+	retryTopic      string
+	deadLetterTopic string
+	maxAttempts     int
+	retryBackoff    time.Duration
+
+	processed atomic.Uint64
+	retried   atomic.Uint64
+	dead      atomic.Uint64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewConsumer(
+	commands kafka.Consumer,
+	retries kafka.Consumer,
+	producer kafka.Producer,
+	service services.GatewayServiceInterface,
+	retryTopic string,
+	deadLetterTopic string,
+	maxAttempts int,
+	retryBackoff time.Duration,
+) *Consumer {
+	return &Consumer{
+		Commands:        commands,
+		Retries:         retries,
+		Producer:        producer,
+		Service:         service,
+		retryTopic:      retryTopic,
+		deadLetterTopic: deadLetterTopic,
+		maxAttempts:     maxAttempts,
+		retryBackoff:    retryBackoff,
+	}
+}
+
+func (c *Consumer) Start(ctx context.Context) {
+	metrics.RegisterCounter("commands_processed_total", "Payment commands applied from Kafka.", func() float64 {
+		return float64(c.processed.Load())
+	})
+	metrics.RegisterCounter("commands_retried_total", "Payment commands that failed and were sent to the retry topic.", func() float64 {
+		return float64(c.retried.Load())
+	})
+	metrics.RegisterCounter("commands_dead_total", "Payment commands sent to the dead-letter topic.", func() float64 {
+		return float64(c.dead.Load())
+	})
+
+	// fetches block, so stopping cancels them rather than signalling
+	ctx, c.cancel = context.WithCancel(ctx)
+
+	c.wg.Add(2)
+	go c.run(ctx, c.Commands)
+	go c.run(ctx, c.Retries)
+}
+
+func (c *Consumer) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *Consumer) run(ctx context.Context, reader kafka.Consumer) {
+	defer c.wg.Done()
+
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to fetch command", "error", err)
+			if !sleep(ctx, c.retryBackoff) {
+				return
+			}
+			continue
+		}
+
+		// nothing after this message may be committed until it is handled
+		for failures := 1; ; failures++ {
+			err := c.Handle(ctx, message)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Failed to handle command, will retry", "topic", message.Topic, "failures", failures, "error", err)
+			if !sleep(ctx, c.backoff(failures)) {
+				return
+			}
+		}
+
+		if err := reader.CommitMessage(ctx, message); err != nil {
+			// the command is redelivered and then recognized by its ID
+			logger.Warn("Failed to commit command offset", "topic", message.Topic, "error", err)
+		}
+	}
+}
+
+// Handle applies one command, or forwards it to the retry or dead-letter
+// topic. Retries wait here until they are due. It returns an error only
+// when the command could be neither applied nor forwarded.
+func (c *Consumer) Handle(ctx context.Context, message kafka.Message) error {
+	if notBefore, err := time.Parse(time.RFC3339Nano, message.Headers[HeaderNotBefore]); err == nil {
+		if !sleep(ctx, time.Until(notBefore)) {
+			return ctx.Err()
+		}
+	}
+
+	err := c.apply(ctx, message)
+	if err == nil {
+		c.processed.Add(1)
+		return nil
+	}
+
+	attempt, _ := strconv.Atoi(message.Headers[HeaderAttempt])
+	attempt++
+
+	if errors.Is(err, errPermanent) || attempt >= c.maxAttempts {
+		if err := c.forward(ctx, c.deadLetterTopic, message, attempt, time.Time{}, err); err != nil {
+			return err
+		}
+		c.dead.Add(1)
+		logger.Error("Command sent to the dead-letter topic", "topic", message.Topic, "attempts", attempt, "error", err)
+		return nil
+	}
+
+	if err := c.forward(ctx, c.retryTopic, message, attempt, time.Now().Add(c.backoff(attempt)), err); err != nil {
+		return err
+	}
+	c.retried.Add(1)
+	logger.Warn("Command failed, will retry", "topic", message.Topic, "attempts", attempt, "error", err)
+	return nil
+}
+
+// apply creates the transaction a command asks for. Applying a command twice
+// is harmless, which matters because we may crash before committing it.
+func (c *Consumer) apply(ctx context.Context, message kafka.Message) error {
+	var codec events.Codec = events.JSONCodec{}
+	if contentType := message.Headers[events.HeaderContentType]; contentType != "" {
+		var err error
+		if codec, err = events.CodecByContentType(contentType); err != nil {
+			return fmt.Errorf("%w: %v", errPermanent, err)
+		}
+	}
+
+	envelope, err := codec.Unmarshal(message.Value)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	if envelope.Type != events.TypeRequestPayout {
+		return fmt.Errorf("%w: unknown command type %q", errPermanent, envelope.Type)
+	}
+	if envelope.SchemaVersion != events.SchemaVersions[envelope.Type] {
+		return fmt.Errorf("%w: unsupported %s version %d", errPermanent, envelope.Type, envelope.SchemaVersion)
+	}
+	if envelope.ID == "" {
+		return fmt.Errorf("%w: command has no ID", errPermanent)
+	}
+
+	var command events.RequestPayout
+	if err := envelope.Decode(&command); err != nil {
+		return fmt.Errorf("%w: invalid %s data: %v", errPermanent, envelope.Type, err)
+	}
+
+	amount, err := decimal.NewFromString(command.Amount)
+	if err != nil {
+		return fmt.Errorf("%w: invalid amount: %v", errPermanent, err)
+	}
+
+	request := models.TransactionRequest{Amount: amount, UserID: command.UserID, Currency: command.Currency}
+	if err := request.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	if envelope.CorrelationID != "" {
+		ctx = requestid.NewContext(ctx, envelope.CorrelationID)
+	}
+
+	err = c.Service.ProcessTransaction(ctx, db.Transaction{
+		UserID:    request.UserID,
+		Amount:    request.Amount,
+		Currency:  request.Currency,
+		Type:      "withdrawal",
+		Status:    "pending",
+		CommandID: envelope.ID,
+	})
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, db.ErrDuplicateCommand):
+		logger.Info("Command already processed", "commandID", envelope.ID)
+		return nil
+	default:
+		// including a full transaction queue: no transaction was created then,
+		// so the retry can still use the command ID
+		return err
+	}
+}
+
+func (c *Consumer) forward(ctx context.Context, topic string, message kafka.Message, attempt int, notBefore time.Time, cause error) error {
+	headers := make(map[string]string, len(message.Headers)+3)
+	for name, value := range message.Headers {
+		headers[name] = value
+	}
+	headers[HeaderAttempt] = strconv.Itoa(attempt)
+	headers[HeaderError] = cause.Error()
+	delete(headers, HeaderNotBefore)
+	if !notBefore.IsZero() {
+		headers[HeaderNotBefore] = notBefore.UTC().Format(time.RFC3339Nano)
+	}
+
+	return utils.ExecuteWithCircuitBreaker(func() error {
+		return c.Producer.PublishMessage(ctx, topic, message.Key, message.Value, headers)
+	})
+}
+
+func (c *Consumer) backoff(attempts int) time.Duration {
+	backoff := c.retryBackoff
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// sleep waits for d, or returns false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
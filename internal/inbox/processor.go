@@ -0,0 +1,208 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+)
+
+// maxRetryBackoff caps the exponential backoff between attempts.
+const maxRetryBackoff = 10 * time.Minute
+
+// claimVisibility hides a claimed callback from other replicas while it is
+// being processed. If we crash, it becomes due again after this long.
+const claimVisibility = time.Minute
+
+// errPermanent marks failures that won't go away by retrying.
+var errPermanent = errors.New("permanent callback failure")
+
+// Processor works through callback_inbox, applying callbacks through the
+// GatewayService. Failures are retried with exponential backoff; callbacks
+// that can never succeed, or exhaust their attempts, are marked dead for
+// manual review.
+type Processor struct {
+	Inbox   db.CallbackInboxStorage
+	DB      db.Storage
+	Service services.GatewayServiceInterface
+	Parser  callback.Parser
+
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	processed atomic.Uint64
+	retried   atomic.Uint64
+	dead      atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewProcessor(
+	inbox db.CallbackInboxStorage,
+	db db.Storage,
+	service services.GatewayServiceInterface,
+	parser callback.Parser,
+	pollInterval time.Duration,
+	batchSize int,
+	maxAttempts int,
+	retryBackoff time.Duration,
+) *Processor {
+	return &Processor{
+		Inbox:        inbox,
+		DB:           db,
+		Service:      service,
+		Parser:       parser,
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		maxAttempts:  maxAttempts,
+		retryBackoff: retryBackoff,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (p *Processor) Start(ctx context.Context) {
+	metrics.RegisterCounter("callback_inbox_processed_total", "Callbacks applied from the inbox.", func() float64 {
+		return float64(p.processed.Load())
+	})
+	metrics.RegisterCounter("callback_inbox_retried_total", "Callback attempts that failed and were rescheduled.", func() float64 {
+		return float64(p.retried.Load())
+	})
+	metrics.RegisterCounter("callback_inbox_dead_total", "Callbacks given up on and left for manual review.", func() float64 {
+		return float64(p.dead.Load())
+	})
+
+	p.wg.Add(1)
+	go p.run(ctx)
+}
+
+func (p *Processor) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+func (p *Processor) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Poll(ctx)
+		}
+	}
+}
+
+// Poll claims one batch of due callbacks and processes them.
+func (p *Processor) Poll(ctx context.Context) {
+	callbacks, err := p.Inbox.ClaimCallbacks(ctx, p.batchSize, claimVisibility)
+	if err != nil {
+		logger.Error("Failed to claim callbacks", "error", err)
+		return
+	}
+
+	for _, cb := range callbacks {
+		p.process(ctx, cb)
+	}
+}
+
+func (p *Processor) process(ctx context.Context, cb db.InboxCallback) {
+	note, err := p.apply(ctx, cb)
+	if err == nil {
+		if err := p.Inbox.MarkCallbackProcessed(ctx, cb.ID, note); err != nil {
+			logger.Error("Failed to mark callback processed", "inboxID", cb.ID, "error", err)
+		}
+		p.processed.Add(1)
+		return
+	}
+
+	dead := errors.Is(err, errPermanent) || cb.Attempts >= p.maxAttempts
+	nextAttemptAt := time.Now().Add(p.backoff(cb.Attempts))
+
+	if markErr := p.Inbox.MarkCallbackFailed(ctx, cb.ID, err.Error(), nextAttemptAt, dead); markErr != nil {
+		logger.Error("Failed to mark callback failed", "inboxID", cb.ID, "error", markErr)
+	}
+
+	if dead {
+		p.dead.Add(1)
+		logger.Error("Callback needs manual review", "inboxID", cb.ID, "gateway", cb.GatewayName, "attempts", cb.Attempts, "error", err)
+		return
+	}
+
+	p.retried.Add(1)
+	logger.Warn("Callback failed, will retry", "inboxID", cb.ID, "gateway", cb.GatewayName, "attempts", cb.Attempts, "error", err)
+}
+
+// apply processes a single callback. Reprocessing an already applied callback
+// is harmless, which matters because we may crash between applying it and
+// marking it processed.
+func (p *Processor) apply(ctx context.Context, cb db.InboxCallback) (string, error) {
+	payload, err := p.Parser.Parse(cb.GatewayName, cb.ContentType, cb.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	var transactionID int
+	if cb.TransactionID != nil {
+		transactionID = *cb.TransactionID
+	} else {
+		// the worker may not have stored the gateway's reference yet, so
+		// an unknown reference is retried rather than rejected
+		tx, err := p.DB.GetTransactionByGatewayTxnID(ctx, cb.GatewayID, payload.GatewayTxnID)
+		if err != nil {
+			return "", err
+		}
+		transactionID = tx.ID
+	}
+
+	err = p.Service.HandleCallback(ctx, models.Callback{
+		TransactionID: transactionID,
+		GatewayID:     cb.GatewayID,
+		GatewayTxnID:  payload.GatewayTxnID,
+		Status:        payload.Status,
+		ErrorMessage:  payload.ErrorMessage,
+		EventTime:     payload.EventTime,
+		Sequence:      payload.Sequence,
+	})
+
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.Is(err, services.ErrStatusConflict):
+		// a reconciliation alert was raised, retrying won't change anything
+		return err.Error(), nil
+	case errors.Is(err, services.ErrCallbackMismatch), errors.Is(err, services.ErrUnmappedStatus):
+		return "", fmt.Errorf("%w: %v", errPermanent, err)
+	default:
+		return "", err
+	}
+}
+
+func (p *Processor) backoff(attempts int) time.Duration {
+	backoff := p.retryBackoff
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
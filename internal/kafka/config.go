@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"payment-gateway/configs/envs"
+)
+
+// newTransport returns the connection settings shared by producers: TLS and
+// SASL authentication as configured.
+func newTransport(cfg *envs.Config) (*kafka.Transport, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := newSASLMechanism(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		DialTimeout: cfg.Kafka.DialTimeout,
+		TLS:         tlsConfig,
+		SASL:        mechanism,
+	}, nil
+}
+
+// newTLSConfig returns nil when TLS is disabled. Without a CA file the system
+// roots verify the brokers.
+func newTLSConfig(cfg *envs.Config) (*tls.Config, error) {
+	if !cfg.Kafka.TLS.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.Kafka.TLS.InsecureSkipVerify,
+	}
+
+	if cfg.Kafka.TLS.CAFile != "" {
+		ca, err := os.ReadFile(cfg.Kafka.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in kafka CA file %s", cfg.Kafka.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.Kafka.TLS.CertFile != "" || cfg.Kafka.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Kafka.TLS.CertFile, cfg.Kafka.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kafka client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newSASLMechanism returns nil when no mechanism is configured.
+func newSASLMechanism(cfg *envs.Config) (sasl.Mechanism, error) {
+	username, password := cfg.Kafka.SASL.Username, cfg.Kafka.SASL.Password
+
+	switch cfg.Kafka.SASL.Mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("unknown kafka SASL mechanism %q", cfg.Kafka.SASL.Mechanism)
+}
+
+func requiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch name {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	}
+	return 0, fmt.Errorf("unknown kafka required acks %q", name)
+}
+
+func compression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown kafka compression %q", name)
+}
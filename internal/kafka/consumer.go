@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+	"payment-gateway/configs/envs"
+)
+
+// Message is a consumed Kafka message.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+
+	raw kafka.Message
+}
+
+type Consumer interface {
+	// FetchMessage blocks until the next message of the group's partitions
+	// arrives. It isn't committed until CommitMessage is called.
+	FetchMessage(ctx context.Context) (Message, error)
+	// CommitMessage commits the offset of message and everything before it
+	// on its partition.
+	CommitMessage(ctx context.Context, message Message) error
+	Close() error
+}
+
+var _ Consumer = (*KafkaConsumer)(nil)
+
+type KafkaConsumer struct {
+	reader *kafka.Reader
+}
+
+// NewConsumer returns a member of consumer group groupID reading topic from
+// the brokers of cfg.Kafka, connecting like NewProducer does. A new group
+// starts at the oldest message.
+func NewConsumer(cfg *envs.Config, topic, groupID string) (Consumer, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, err := newSASLMechanism(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaConsumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: cfg.Kafka.Brokers,
+			GroupID: groupID,
+			Topic:   topic,
+			Dialer: &kafka.Dialer{
+				Timeout:       cfg.Kafka.DialTimeout,
+				DualStack:     true,
+				TLS:           tlsConfig,
+				SASLMechanism: mechanism,
+			},
+			StartOffset: kafka.FirstOffset,
+		}),
+	}, nil
+}
+
+func (kc *KafkaConsumer) FetchMessage(ctx context.Context) (Message, error) {
+	raw, err := kc.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to fetch kafka message: %v", err)
+	}
+
+	headers := make(map[string]string, len(raw.Headers))
+	for _, header := range raw.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+
+	return Message{
+		Topic:   raw.Topic,
+		Key:     raw.Key,
+		Value:   raw.Value,
+		Headers: headers,
+		raw:     raw,
+	}, nil
+}
+
+func (kc *KafkaConsumer) CommitMessage(ctx context.Context, message Message) error {
+	if err := kc.reader.CommitMessages(ctx, message.raw); err != nil {
+		return fmt.Errorf("failed to commit kafka message: %v", err)
+	}
+	return nil
+}
+
+func (kc *KafkaConsumer) Close() error {
+	return kc.reader.Close()
+}
@@ -3,14 +3,17 @@ package kafka
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/segmentio/kafka-go"
+	"payment-gateway/configs/envs"
 	"payment-gateway/configs/logger"
 )
 
 type Producer interface {
-	PublishMessage(ctx context.Context, topic string, message []byte) error
+	// PublishMessage sends message to topic. Messages with the same key go to
+	// the same partition; a nil key spreads them round-robin. Headers are
+	// sent as Kafka record headers.
+	PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error
 	Close() error
 }
 
@@ -20,29 +23,97 @@ type KafkaProducer struct {
 	writer *kafka.Writer
 }
 
-func NewProducer(brokerURL string) Producer {
+// NewProducer returns a producer for the brokers of cfg.Kafka.
+//
+// kafka-go has no idempotent producer (producer IDs and sequence numbers), so
+// writes are not idempotent: a write whose ack is lost is sent again and
+// consumers see it twice. NoRetries keeps the damage to that: it requires
+// acks from all in-sync replicas and a single attempt per write, so the
+// writer never retries a batch out of order behind the relay's back. Retries
+// are left to the outbox relay, which resends the same messages in order;
+// consumers dedupe on the envelope ID.
+func NewProducer(cfg *envs.Config) (Producer, error) {
+	balancer, err := NewBalancer(cfg.Kafka.Balancer)
+	if err != nil {
+		return nil, err
+	}
+
+	acks, err := requiredAcks(cfg.Kafka.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := compression(cfg.Kafka.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttempts := 0 // kafka-go's default
+	if cfg.Kafka.NoRetries {
+		if acks != kafka.RequireAll {
+			return nil, fmt.Errorf("kafka writes without retries need required acks \"all\", not %q", cfg.Kafka.RequiredAcks)
+		}
+		maxAttempts = 1
+	}
+
 	p := &KafkaProducer{
 		writer: &kafka.Writer{
-			Addr:                   kafka.TCP(brokerURL),
-			Balancer:               &kafka.LeastBytes{},
+			Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+			Balancer:               balancer,
+			RequiredAcks:           acks,
+			Compression:            codec,
+			MaxAttempts:            maxAttempts,
+			BatchSize:              cfg.Kafka.BatchSize,
+			BatchTimeout:           cfg.Kafka.BatchTimeout,
+			WriteTimeout:           cfg.Kafka.WriteTimeout,
+			ReadTimeout:            cfg.Kafka.ReadTimeout,
+			Transport:              transport,
 			AllowAutoTopicCreation: true,
-			BatchTimeout:           10 * time.Millisecond,
 		},
 	}
 
-	return p
+	return p, nil
 }
 
-func (kp *KafkaProducer) PublishMessage(ctx context.Context, topic string, message []byte) error {
+// NewBalancer returns the partition balancer of name. "hash" (FNV-1a, the
+// default), "murmur2" (the Java client's) and "crc32" (librdkafka's) send
+// messages with the same key to the same partition; "round_robin" and
+// "least_bytes" ignore keys.
+func NewBalancer(name string) (kafka.Balancer, error) {
+	switch name {
+	case "", "hash":
+		return &kafka.Hash{}, nil
+	case "murmur2":
+		return kafka.Murmur2Balancer{}, nil
+	case "crc32":
+		return kafka.CRC32Balancer{}, nil
+	case "round_robin":
+		return &kafka.RoundRobin{}, nil
+	case "least_bytes":
+		return &kafka.LeastBytes{}, nil
+	}
+	return nil, fmt.Errorf("unknown kafka balancer %q", name)
+}
+
+func (kp *KafkaProducer) PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error {
 	if kp.writer == nil {
 		logger.Error("Kafka writer is nil, cannot publish to Kafka")
 		return fmt.Errorf("kafka writer is not initialized")
 	}
 
 	kafkaMessage := kafka.Message{
+		Key:   key,
 		Value: message,
 		Topic: topic,
 	}
+	for name, value := range headers {
+		kafkaMessage.Headers = append(kafkaMessage.Headers, kafka.Header{Key: name, Value: []byte(value)})
+	}
 
 	err := kp.writer.WriteMessages(ctx, kafkaMessage)
 	if err != nil {
@@ -0,0 +1,40 @@
+package keys
+
+import (
+	"fmt"
+	"net/http"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/pkg/encryption"
+)
+
+// NewProvider returns the encryption.KeyProvider cfg.Security selects. The
+// local provider reads ENCRYPTION_KEY_FILE if set, and otherwise the current
+// key from ENCRYPTION_KEY plus the retired ones.
+func NewProvider(cfg *envs.Config) (encryption.KeyProvider, error) {
+	switch cfg.Security.KeyProvider {
+	case "", "local":
+		if cfg.Security.KeyFile != "" {
+			return encryption.LoadKeyFile(cfg.Security.KeyFile)
+		}
+
+		keys, err := encryption.ParseKeys(cfg.Security.RetiredKeys)
+		if err != nil {
+			return nil, err
+		}
+		current, err := encryption.ParseKey(cfg.Security.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[cfg.Security.EncryptionKeyID] = current
+		return encryption.NewLocalKeyProvider(cfg.Security.EncryptionKeyID, keys)
+	case "vault":
+		vault := cfg.Security.Vault
+		if vault.Token == "" {
+			return nil, fmt.Errorf("VAULT_TOKEN is required for the vault key provider")
+		}
+		return encryption.NewVaultKeyProvider(vault.Addr, vault.Token, vault.TransitKey,
+			&http.Client{Timeout: vault.Timeout}), nil
+	}
+	return nil, fmt.Errorf("unknown encryption key provider %q", cfg.Security.KeyProvider)
+}
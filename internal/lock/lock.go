@@ -0,0 +1,22 @@
+package lock
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotAcquired = errors.New("lock not acquired")
+
+// Locker serialises writers of the same transaction across goroutines and
+// replicas.
+type Locker interface {
+	Acquire(ctx context.Context, txID int) (Lease, error)
+}
+
+// Lease is a held lock. Token is a fencing token that increases with every
+// acquisition of the same transaction lock; it must accompany every write so
+// that a writer whose lease silently expired can't overwrite a newer one.
+type Lease interface {
+	Token() int64
+	Release(ctx context.Context) error
+}
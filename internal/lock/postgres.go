@@ -0,0 +1,107 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// advisoryNamespace is the first key of the two-key advisory lock form, so
+// transaction locks can't collide with other advisory locks in the database.
+const advisoryNamespace = 1001
+
+// unlockTimeout bounds releasing a lock, which doesn't follow the caller's
+// context.
+const unlockTimeout = 5 * time.Second
+
+var _ Locker = (*PostgresLocker)(nil)
+
+type PostgresLocker struct {
+	db            *sql.DB
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+}
+
+func NewPostgresLocker(db *sql.DB, waitTimeout time.Duration) Locker {
+	return &PostgresLocker{
+		db:            db,
+		waitTimeout:   waitTimeout,
+		retryInterval: 50 * time.Millisecond,
+	}
+}
+
+func (l *PostgresLocker) Acquire(ctx context.Context, txID int) (Lease, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, l.waitTimeout)
+	defer cancel()
+
+	// session-level advisory locks belong to a connection, so the lease has
+	// to keep that connection out of the pool until it is released
+	conn, err := l.db.Conn(waitCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection for lock: %v", err)
+	}
+
+	for {
+		var acquired bool
+		err := conn.QueryRowContext(waitCtx, `SELECT pg_try_advisory_lock($1, $2)`, advisoryNamespace, txID).Scan(&acquired)
+		if err != nil {
+			// the lock may have been taken before the query was cancelled
+			discard(conn)
+			return nil, fmt.Errorf("failed to acquire lock: %v", err)
+		}
+
+		if acquired {
+			token, err := nextToken(waitCtx, conn)
+			if err != nil {
+				unlockAndClose(ctx, conn, txID)
+				return nil, fmt.Errorf("failed to issue fencing token: %v", err)
+			}
+
+			return &postgresLease{conn: conn, txID: txID, token: token}, nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			conn.Close()
+			return nil, ErrNotAcquired
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+type postgresLease struct {
+	conn  *sql.Conn
+	txID  int
+	token int64
+}
+
+func (l *postgresLease) Token() int64 {
+	return l.token
+}
+
+func (l *postgresLease) Release(ctx context.Context) error {
+	return unlockAndClose(ctx, l.conn, l.txID)
+}
+
+// unlockAndClose releases the lock even if ctx is already cancelled, e.g. at
+// shutdown; a connection back in the pool would otherwise keep holding it.
+func unlockAndClose(ctx context.Context, conn *sql.Conn, txID int) error {
+	unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unlockTimeout)
+	defer cancel()
+
+	if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1, $2)`, advisoryNamespace, txID); err != nil {
+		discard(conn)
+		return fmt.Errorf("failed to release lock: %v", err)
+	}
+	conn.Close()
+	return nil
+}
+
+// discard closes the connection instead of returning it to the pool, which
+// ends its session and with it any advisory lock it holds.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(any) error { return driver.ErrBadConn })
+	conn.Close()
+}
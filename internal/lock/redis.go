@@ -0,0 +1,141 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"payment-gateway/configs/logger"
+)
+
+// releaseScript deletes the lock only if it is still held by the caller.
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// renewScript extends the lock only if it is still held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var _ Locker = (*RedisLocker)(nil)
+
+// RedisLocker holds locks in Redis and takes fencing tokens from the
+// database. The lock expires after ttl unless renewed; a lease renews it
+// every third of the ttl until released.
+type RedisLocker struct {
+	client        *redis.Client
+	db            *sql.DB
+	ttl           time.Duration
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+}
+
+func NewRedisLocker(client *redis.Client, db *sql.DB, ttl, waitTimeout time.Duration) Locker {
+	return &RedisLocker{
+		client:        client,
+		db:            db,
+		ttl:           ttl,
+		waitTimeout:   waitTimeout,
+		retryInterval: 50 * time.Millisecond,
+	}
+}
+
+func (l *RedisLocker) Acquire(ctx context.Context, txID int) (Lease, error) {
+	key := fmt.Sprintf("lock:transaction:%d", txID)
+	owner := uuid.New().String()
+
+	waitCtx, cancel := context.WithTimeout(ctx, l.waitTimeout)
+	defer cancel()
+
+	for {
+		ok, err := l.client.SetNX(waitCtx, key, owner, l.ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lock: %v", err)
+		}
+
+		if ok {
+			token, err := nextToken(waitCtx, l.db)
+			if err != nil {
+				releaseScript.Run(ctx, l.client, []string{key}, owner)
+				return nil, fmt.Errorf("failed to issue fencing token: %v", err)
+			}
+
+			lease := &redisLease{client: l.client, key: key, owner: owner, token: token, stop: make(chan struct{})}
+			lease.wg.Add(1)
+			go lease.renew(l.ttl)
+			return lease, nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return nil, ErrNotAcquired
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+type redisLease struct {
+	client *redis.Client
+	key    string
+	owner  string
+	token  int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// renew keeps the lock from expiring under a long job. Once the lock is
+// lost it stops; the next write fails on its fencing token if another
+// writer took over.
+func (l *redisLease) renew(ttl time.Duration) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		held, err := renewScript.Run(ctx, l.client, []string{l.key}, l.owner, ttl.Milliseconds()).Int()
+		cancel()
+		if err != nil {
+			logger.Warn("Failed to renew transaction lock", "key", l.key, "error", err)
+			continue
+		}
+		if held == 0 {
+			logger.Warn("Transaction lock expired before it was renewed", "key", l.key)
+			return
+		}
+	}
+}
+
+func (l *redisLease) Token() int64 {
+	return l.token
+}
+
+func (l *redisLease) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.wg.Wait()
+
+	if err := releaseScript.Run(ctx, l.client, []string{l.key}, l.owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock: %v", err)
+	}
+	return nil
+}
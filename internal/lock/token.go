@@ -0,0 +1,17 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+)
+
+// nextToken issues a fencing token. Both backends draw from the same
+// database sequence, so tokens written under one stay comparable with the
+// other's when LOCK_BACKEND changes.
+func nextToken(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}) (int64, error) {
+	var token int64
+	err := q.QueryRowContext(ctx, `SELECT nextval('transaction_lock_token_seq')`).Scan(&token)
+	return token, err
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// metric is read through fn at scrape time, so callers don't have to push
+// updates; they only expose a function returning the current value.
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	fn         func() float64
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]metric{}
+)
+
+func RegisterGauge(name, help string, fn func() float64) {
+	register(metric{name: name, help: help, metricType: "gauge", fn: fn})
+}
+
+func RegisterCounter(name, help string, fn func() float64) {
+	register(metric{name: name, help: help, metricType: "counter", fn: fn})
+}
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[m.name] = m
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.RLock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		metrics := make([]metric, 0, len(names))
+		sort.Strings(names)
+		for _, name := range names {
+			metrics = append(metrics, registry[name])
+		}
+		mu.RUnlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.metricType)
+			fmt.Fprintf(w, "%s %g\n", m.name, m.fn())
+		}
+	})
+}
@@ -1,17 +1,30 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"github.com/shopspring/decimal"
 )
 
+// ErrNonPositiveAmount is returned by TransactionRequest.Validate.
+var ErrNonPositiveAmount = errors.New("amount must be greater than zero")
+
 type TransactionRequest struct {
 	Amount   decimal.Decimal `json:"amount" xml:"amount"`
 	UserID   int             `json:"user_id" xml:"user_id"`
 	Currency string          `json:"currency" xml:"currency"`
 }
 
+// Validate checks what every deposit and withdrawal request must satisfy,
+// whether it came over HTTP or as a Kafka command.
+func (r TransactionRequest) Validate() error {
+	if r.Amount.LessThanOrEqual(decimal.Zero) {
+		return ErrNonPositiveAmount
+	}
+	return nil
+}
+
 type APIResponse struct {
 	StatusCode int         `json:"status_code" xml:"status_code"`
 	Message    string      `json:"message" xml:"message"`
@@ -31,4 +44,97 @@ type Transaction struct {
 	CreatedAt    time.Time       `json:"created_at"`
 	UpdatedAt    time.Time       `json:"updated_at"`
 	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+	// RequestID is the X-Request-ID of the API request that created the
+	// transaction, the worker tags its status changes with it.
+	RequestID string `json:"-"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name" xml:"name"`
+	Scopes    []string   `json:"scopes" xml:"scopes"`
+	UserID    *int       `json:"user_id,omitempty" xml:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+}
+
+type APIKeyRotateRequest struct {
+	GracePeriodSeconds int `json:"grace_period_seconds" xml:"grace_period_seconds"`
+}
+
+type APIKeyResponse struct {
+	ID        int        `json:"id" xml:"id"`
+	Name      string     `json:"name" xml:"name"`
+	Key       string     `json:"key,omitempty" xml:"key,omitempty"` // only returned on creation and rotation
+	Scopes    []string   `json:"scopes" xml:"scopes"`
+	UserID    *int       `json:"user_id,omitempty" xml:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+}
+
+type CallbackInboxResponse struct {
+	ID            int        `json:"id" xml:"id"`
+	Gateway       string     `json:"gateway" xml:"gateway"`
+	EventID       string     `json:"event_id" xml:"event_id"`
+	TransactionID *int       `json:"transaction_id,omitempty" xml:"transaction_id,omitempty"`
+	ContentType   string     `json:"content_type" xml:"content_type"`
+	Body          string     `json:"body" xml:"body"`
+	Status        string     `json:"status" xml:"status"`
+	Attempts      int        `json:"attempts" xml:"attempts"`
+	LastError     string     `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	ReceivedAt    time.Time  `json:"received_at" xml:"received_at"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty" xml:"processed_at,omitempty"`
+}
+
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" xml:"url"`
+	EventTypes []string `json:"event_types" xml:"event_types"`
+}
+
+type WebhookSubscriptionResponse struct {
+	ID         int       `json:"id" xml:"id"`
+	URL        string    `json:"url" xml:"url"`
+	EventTypes []string  `json:"event_types" xml:"event_types"`
+	UserID     *int      `json:"user_id,omitempty" xml:"user_id,omitempty"`
+	Secret     string    `json:"secret,omitempty" xml:"secret,omitempty"` // only returned on creation
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             int        `json:"id" xml:"id"`
+	SubscriptionID int        `json:"subscription_id" xml:"subscription_id"`
+	EventID        string     `json:"event_id" xml:"event_id"`
+	EventType      string     `json:"event_type" xml:"event_type"`
+	TransactionID  *int       `json:"transaction_id,omitempty" xml:"transaction_id,omitempty"`
+	Payload        string     `json:"payload" xml:"payload"`
+	Status         string     `json:"status" xml:"status"`
+	Attempts       int        `json:"attempts" xml:"attempts"`
+	LastStatusCode *int       `json:"last_status_code,omitempty" xml:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" xml:"next_attempt_at"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" xml:"delivered_at,omitempty"`
+}
+
+// TransactionStatusEvent is one status change of a transaction, as sent on
+// the SSE streams.
+type TransactionStatusEvent struct {
+	ID             int64     `json:"id"`
+	TransactionID  int       `json:"transaction_id"`
+	UserID         int       `json:"user_id"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Status         string    `json:"status"`
+	GatewayID      int       `json:"gateway_id,omitempty"`
+	ReasonCode     string    `json:"reason_code,omitempty"`
+	ErrorMessage   string    `json:"error_message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Callback is a gateway status notification after it has been parsed and
+// attributed to a gateway and transaction.
+type Callback struct {
+	TransactionID int
+	GatewayID     int
+	GatewayTxnID  string
+	Status        string
+	ErrorMessage  string
+	EventTime     time.Time // zero if the gateway didn't send one
+	Sequence      int64     // zero if the gateway didn't send one
 }
@@ -0,0 +1,137 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/requestid"
+	"payment-gateway/internal/schema"
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
+)
+
+// Encoder builds the outbox messages of events, serialized by Codec. Each
+// message carries its event type, the registry ID of its data schema, the
+// codec's content type and, when known, the request ID as headers.
+type Encoder struct {
+	codec     events.Codec
+	cipher    *encryption.Cipher
+	schemaIDs map[string]int // by event type
+}
+
+// NewEncoder checks registry has a schema in the codec's format for the
+// current version of every event type. Sensitive data is encrypted with
+// cipher.
+func NewEncoder(codec events.Codec, registry *schema.Registry, cipher *encryption.Cipher) (*Encoder, error) {
+	schemaIDs := map[string]int{}
+	for eventType, version := range events.SchemaVersions {
+		registered, err := registry.Lookup(eventType, version, codec.Format())
+		if err != nil {
+			return nil, err
+		}
+		schemaIDs[eventType] = registered.ID
+	}
+
+	return &Encoder{codec: codec, cipher: cipher, schemaIDs: schemaIDs}, nil
+}
+
+// CreatedMessages returns the db.OutboxMessageFunc publishing
+// TransactionCreated events to topic. The data is encrypted, it carries
+// amounts.
+func (e *Encoder) CreatedMessages(topic string) db.OutboxMessageFunc {
+	return func(ctx context.Context, tx db.Transaction) (db.OutboxMessage, error) {
+		envelope, err := e.encryptedEnvelope(ctx, events.EventID(events.TypeTransactionCreated, int64(tx.ID)),
+			events.TypeTransactionCreated, tx.CreatedAt, events.TransactionCreated{
+				TransactionID: tx.ID,
+				UserID:        tx.UserID,
+				Amount:        tx.Amount.String(),
+				Currency:      tx.Currency,
+				Type:          tx.Type,
+				Status:        tx.Status,
+				GatewayID:     tx.GatewayID,
+			})
+		if err != nil {
+			return db.OutboxMessage{}, err
+		}
+
+		return e.message(topic, tx.ID, envelope)
+	}
+}
+
+// StatusMessages returns the db.OutboxEventFunc publishing StatusChanged
+// events to topic. The data is encrypted too: it ties users to transactions
+// and gateway error messages can carry customer or card details.
+func (e *Encoder) StatusMessages(topic string) db.OutboxEventFunc {
+	return func(ctx context.Context, event db.TransactionEvent) (db.OutboxMessage, error) {
+		envelope, err := e.encryptedEnvelope(ctx, events.EventID(events.TypeStatusChanged, event.ID),
+			events.TypeStatusChanged, event.CreatedAt, events.StatusChanged{
+				EventID:        event.ID,
+				TransactionID:  event.TransactionID,
+				UserID:         event.UserID,
+				PreviousStatus: event.PreviousStatus,
+				Status:         event.Status,
+				GatewayID:      event.GatewayID,
+				ReasonCode:     event.ReasonCode,
+				ErrorMessage:   event.ErrorMessage,
+				OccurredAt:     event.CreatedAt.UTC(),
+			})
+		if err != nil {
+			return db.OutboxMessage{}, err
+		}
+
+		return e.message(topic, event.TransactionID, envelope)
+	}
+}
+
+// encryptedEnvelope wraps data sealed with the encoder's cipher.
+func (e *Encoder) encryptedEnvelope(ctx context.Context, id, eventType string, occurredAt time.Time, data any) (events.Envelope, error) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return events.Envelope{}, fmt.Errorf("failed to marshal %s data: %v", eventType, err)
+	}
+
+	ciphertext, err := e.cipher.Encrypt(ctx, plaintext)
+	if err != nil {
+		return events.Envelope{}, fmt.Errorf("failed to encrypt %s data: %v", eventType, err)
+	}
+
+	envelope, err := events.NewEnvelope(id, eventType, occurredAt, requestid.FromContext(ctx), ciphertext)
+	if err != nil {
+		return events.Envelope{}, err
+	}
+	envelope.Encrypted = true
+
+	return envelope, nil
+}
+
+// message keys by transaction, so a partition sees a transaction's events in
+// order.
+func (e *Encoder) message(topic string, transactionID int, envelope events.Envelope) (db.OutboxMessage, error) {
+	payload, err := e.codec.Marshal(envelope)
+	if err != nil {
+		return db.OutboxMessage{}, fmt.Errorf("failed to marshal %s event: %v", envelope.Type, err)
+	}
+
+	headers := map[string]string{
+		events.HeaderEventType:   envelope.Type,
+		events.HeaderSchemaID:    strconv.Itoa(e.schemaIDs[envelope.Type]),
+		events.HeaderContentType: e.codec.ContentType(),
+	}
+	if envelope.CorrelationID != "" {
+		headers[events.HeaderRequestID] = envelope.CorrelationID
+	}
+	if envelope.Encrypted {
+		headers[events.HeaderEncryptionKeyID] = e.cipher.KeyID()
+	}
+
+	return db.OutboxMessage{
+		Topic:   topic,
+		Key:     strconv.Itoa(transactionID),
+		Headers: headers,
+		Payload: payload,
+	}, nil
+}
@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"payment-gateway/db"
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
+)
+
+// ReencryptStats counts the messages Reencrypt went through.
+type ReencryptStats struct {
+	Scanned   int
+	Encrypted int // messages with encrypted data
+	Stale     int // of those, not sealed under the current key
+	Rewritten int
+}
+
+// Reencrypt seals the encrypted data of every stored outbox message, sent or
+// not, under the current key of cipher, so retired keys can be dropped. A
+// dry run decrypts the stale messages, proving the keys are there, but
+// writes nothing. It stops at the first message it can't rewrite.
+func Reencrypt(ctx context.Context, store db.OutboxStorage, cipher *encryption.Cipher, batchSize int, dryRun bool) (ReencryptStats, error) {
+	var stats ReencryptStats
+
+	var afterID int64
+	for {
+		messages, err := store.ListOutbox(ctx, afterID, batchSize)
+		if err != nil {
+			return stats, err
+		}
+		if len(messages) == 0 {
+			return stats, nil
+		}
+
+		for _, message := range messages {
+			afterID = message.ID
+			stats.Scanned++
+
+			rewritten, stale, err := reencryptMessage(ctx, cipher, message)
+			if err != nil {
+				return stats, fmt.Errorf("outbox message %d: %w", message.ID, err)
+			}
+			if rewritten.Payload == nil {
+				continue
+			}
+			stats.Encrypted++
+			if !stale {
+				continue
+			}
+			stats.Stale++
+			if dryRun {
+				continue
+			}
+
+			if err := store.RewriteOutboxMessage(ctx, message.ID, rewritten.Headers, rewritten.Payload); err != nil {
+				return stats, err
+			}
+			stats.Rewritten++
+		}
+	}
+}
+
+// reencryptMessage returns message with its data sealed under the current
+// key, and whether that changed anything. The payload is nil for messages
+// without encrypted data.
+func reencryptMessage(ctx context.Context, cipher *encryption.Cipher, message db.OutboxMessage) (db.OutboxMessage, bool, error) {
+	var codec events.Codec = events.JSONCodec{}
+	if contentType := message.Headers[events.HeaderContentType]; contentType != "" {
+		var err error
+		if codec, err = events.CodecByContentType(contentType); err != nil {
+			return db.OutboxMessage{}, false, err
+		}
+	}
+
+	envelope, err := codec.Unmarshal(message.Payload)
+	if err != nil {
+		return db.OutboxMessage{}, false, err
+	}
+	if !envelope.Encrypted {
+		return db.OutboxMessage{}, false, nil
+	}
+
+	var ciphertext string
+	if err := json.Unmarshal(envelope.Data, &ciphertext); err != nil {
+		return db.OutboxMessage{}, false, fmt.Errorf("encrypted data is not a string: %v", err)
+	}
+	if current, err := cipher.IsCurrent(ciphertext); err != nil || current {
+		return message, false, err
+	}
+
+	plaintext, err := cipher.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return db.OutboxMessage{}, false, err
+	}
+	if ciphertext, err = cipher.Encrypt(ctx, plaintext); err != nil {
+		return db.OutboxMessage{}, false, err
+	}
+	if envelope.Data, err = json.Marshal(ciphertext); err != nil {
+		return db.OutboxMessage{}, false, err
+	}
+
+	payload, err := codec.Marshal(envelope)
+	if err != nil {
+		return db.OutboxMessage{}, false, err
+	}
+
+	headers := make(map[string]string, len(message.Headers)+1)
+	for name, value := range message.Headers {
+		headers[name] = value
+	}
+	headers[events.HeaderEncryptionKeyID] = cipher.KeyID()
+
+	message.Headers, message.Payload = headers, payload
+	return message, true, nil
+}
@@ -0,0 +1,162 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sony/gobreaker"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/sink"
+	"payment-gateway/internal/utils"
+)
+
+// maxRetryBackoff caps the exponential backoff after failed publishes.
+const maxRetryBackoff = time.Minute
+
+// Relay publishes the outbox to an event sink, usually Kafka, in the order it
+// was written. A message that can't be published holds back everything after
+// it and is retried with exponential backoff, so consumers get every message,
+// in order, at least once. After maxAttempts failures it is parked instead,
+// logged as an error and counted in outbox_parked_total, and the messages
+// after it go out without it.
+type Relay struct {
+	Store db.OutboxStorage
+	Sink  sink.EventSink
+
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	failures  int
+	nextRetry time.Time
+
+	published atomic.Uint64
+	failed    atomic.Uint64
+	parked    atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewRelay(
+	store db.OutboxStorage,
+	eventSink sink.EventSink,
+	pollInterval time.Duration,
+	batchSize int,
+	maxAttempts int,
+	retryBackoff time.Duration,
+) *Relay {
+	return &Relay{
+		Store:        store,
+		Sink:         eventSink,
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		maxAttempts:  maxAttempts,
+		retryBackoff: retryBackoff,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (r *Relay) Start(ctx context.Context) {
+	metrics.RegisterCounter("outbox_published_total", "Outbox messages published to the event sink.", func() float64 {
+		return float64(r.published.Load())
+	})
+	metrics.RegisterCounter("outbox_failed_total", "Outbox publish attempts that failed and will be retried.", func() float64 {
+		return float64(r.failed.Load())
+	})
+	metrics.RegisterCounter("outbox_parked_total", "Outbox messages parked after failing too often, they need an operator.", func() float64 {
+		return float64(r.parked.Load())
+	})
+
+	r.wg.Add(1)
+	go r.run(ctx)
+}
+
+func (r *Relay) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if time.Now().Before(r.nextRetry) {
+				continue
+			}
+			r.Poll(ctx)
+		}
+	}
+}
+
+// Poll publishes unsent messages until the outbox is drained or a publish
+// fails.
+func (r *Relay) Poll(ctx context.Context) {
+	for {
+		sent, parked, err := r.Store.RelayOutbox(ctx, r.batchSize, r.maxAttempts, func(message db.OutboxMessage) error {
+			err := utils.ExecuteWithCircuitBreaker(func() error {
+				return r.Sink.PublishMessage(ctx, message.Topic, messageKey(message), message.Payload, message.Headers)
+			})
+			if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+				return fmt.Errorf("%w: %v", db.ErrOutboxNotAttempted, err)
+			}
+			return err
+		})
+		r.published.Add(uint64(sent))
+		for _, message := range parked {
+			r.parked.Add(1)
+			logger.Error("Outbox message parked, it will not be published until requeued",
+				"id", message.ID, "topic", message.Topic, "key", message.Key, "attempts", message.Attempts)
+		}
+
+		if err != nil {
+			r.failed.Add(1)
+			r.failures++
+			backoff := r.backoff(r.failures)
+			r.nextRetry = time.Now().Add(backoff)
+			logger.Warn("Failed to relay outbox, will retry", "failures", r.failures, "retryIn", backoff, "error", err)
+			return
+		}
+		r.failures = 0
+
+		if sent+len(parked) < r.batchSize {
+			return
+		}
+	}
+}
+
+func messageKey(message db.OutboxMessage) []byte {
+	if message.Key == "" {
+		return nil
+	}
+	return []byte(message.Key)
+}
+
+func (r *Relay) backoff(failures int) time.Duration {
+	backoff := r.retryBackoff
+	for i := 1; i < failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Result describes the state of a bucket after a single Allow call.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // time until the next token, zero if allowed
+	ResetAfter time.Duration // time until the bucket is full again
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// tokenBucketScript refills the bucket based on the elapsed time and takes a
+// single token from it. Keeping it in one script makes the read-modify-write
+// atomic across replicas.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry_after = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+
+return {allowed, tostring(tokens), retry_after}
+`)
+
+var _ Limiter = (*RedisLimiter)(nil)
+
+// RedisLimiter is a token bucket shared by all replicas through Redis.
+type RedisLimiter struct {
+	client *redis.Client
+	rate   int // tokens per second
+	burst  int
+}
+
+func NewRedisLimiter(client *redis.Client, rate, burst int) Limiter {
+	return &RedisLimiter{
+		client: client,
+		rate:   rate,
+		burst:  burst,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now().UnixMilli()
+
+	res, err := tokenBucketScript.Run(ctx, l.client, []string{"ratelimit:" + key}, l.rate, l.burst, now).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit: %v", err)
+	}
+
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	retryAfterMs, _ := res[2].(int64)
+
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to parse rate limit tokens: %v", err)
+	}
+
+	resetAfter := time.Duration((float64(l.burst) - tokens) / float64(l.rate) * float64(time.Second))
+
+	return Result{
+		Allowed:    allowed == 1,
+		Limit:      l.burst,
+		Remaining:  int(tokens),
+		RetryAfter: time.Duration(retryAfterMs) * time.Millisecond,
+		ResetAfter: resetAfter,
+	}, nil
+}
@@ -0,0 +1,192 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/internal/sink"
+	"payment-gateway/pkg/events"
+)
+
+// Options of a replay.
+type Options struct {
+	Filter db.ReplayFilter
+	// CreatedTopic and StatusTopic receive the transaction.created and
+	// transaction.status_changed events; an empty one skips its events.
+	CreatedTopic string
+	StatusTopic  string
+	Rate         float64 // messages per second, unlimited if 0
+	BatchSize    int     // transactions read per query
+	DryRun       bool    // log the messages instead of publishing them
+}
+
+// Stats counts what a replay went through.
+type Stats struct {
+	Transactions int
+	Messages     int
+	// WithoutHistory counts the transactions skipped for having no status
+	// history, created before it was recorded: their events can't be rebuilt.
+	WithoutHistory int
+	// LastTransactionID is the last transaction replayed in full, resume
+	// after it.
+	LastTransactionID int
+}
+
+// Replayer republishes the events of stored transactions, for consumers that
+// missed them. Envelopes are rebuilt from the transaction and its status
+// history, with the IDs of the originals so consumers can dedupe, but
+// without their correlation IDs, which aren't stored.
+type Replayer struct {
+	Store   db.ReplayStorage
+	Encoder *outbox.Encoder
+	Sink    sink.EventSink
+}
+
+func NewReplayer(store db.ReplayStorage, encoder *outbox.Encoder, eventSink sink.EventSink) *Replayer {
+	return &Replayer{Store: store, Encoder: encoder, Sink: eventSink}
+}
+
+// Run replays the transactions of opts.Filter in ID order, each one's events
+// in the order they happened. It stops at the first failure.
+func (r *Replayer) Run(ctx context.Context, opts Options) (Stats, error) {
+	var stats Stats
+	replayedAt := time.Now().UTC().Format(time.RFC3339)
+
+	var throttle <-chan time.Time
+	if interval := throttleInterval(opts.Rate); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	publish := func(message db.OutboxMessage) error {
+		if throttle != nil && stats.Messages > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-throttle:
+			}
+		}
+
+		headers := make(map[string]string, len(message.Headers)+1)
+		for name, value := range message.Headers {
+			headers[name] = value
+		}
+		headers[events.HeaderReplay] = replayedAt
+
+		if opts.DryRun {
+			logger.Info("Dry run, would replay event", "topic", message.Topic, "key", message.Key,
+				"eventType", headers[events.HeaderEventType])
+		} else if err := r.Sink.PublishMessage(ctx, message.Topic, []byte(message.Key), message.Payload, headers); err != nil {
+			return err
+		}
+		stats.Messages++
+		return nil
+	}
+
+	afterID := 0
+	for {
+		transactions, err := r.Store.ListReplayTransactions(ctx, opts.Filter, afterID, opts.BatchSize)
+		if err != nil {
+			return stats, err
+		}
+		if len(transactions) == 0 {
+			return stats, nil
+		}
+
+		for _, tx := range transactions {
+			afterID = tx.ID
+			history, err := r.history(ctx, tx.ID)
+			if err != nil {
+				return stats, fmt.Errorf("failed to replay transaction %d: %v", tx.ID, err)
+			}
+
+			// the created event would carry the current status instead of
+			// the one the transaction was created with
+			if len(history) == 0 {
+				logger.Warn("Transaction has no status history, skipped", "transactionID", tx.ID)
+				stats.WithoutHistory++
+				stats.LastTransactionID = tx.ID
+				continue
+			}
+
+			if err := r.replayTransaction(ctx, tx, history, opts, publish); err != nil {
+				return stats, fmt.Errorf("failed to replay transaction %d: %v", tx.ID, err)
+			}
+			stats.Transactions++
+			stats.LastTransactionID = tx.ID
+		}
+	}
+}
+
+// throttleInterval is the time between messages at rate per second, 0 for
+// no limit. Rates too high for a 1ns interval are as good as unlimited.
+func throttleInterval(rate float64) time.Duration {
+	if !(rate > 0) {
+		return 0
+	}
+	interval := float64(time.Second) / rate
+	if interval >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(interval)
+}
+
+func (r *Replayer) replayTransaction(ctx context.Context, tx db.Transaction, history []db.TransactionEvent, opts Options, publish func(db.OutboxMessage) error) error {
+	if opts.CreatedTopic != "" {
+		// the event announced the transaction as it was created, not as it is
+		if history[0].PreviousStatus == "" {
+			tx.Status, tx.GatewayID = history[0].Status, history[0].GatewayID
+		}
+
+		message, err := r.Encoder.CreatedMessages(opts.CreatedTopic)(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if err := publish(message); err != nil {
+			return err
+		}
+	}
+
+	if opts.StatusTopic != "" {
+		for _, event := range history {
+			message, err := r.Encoder.StatusMessages(opts.StatusTopic)(ctx, event)
+			if err != nil {
+				return err
+			}
+			if err := publish(message); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// history returns all status events of a transaction, oldest first.
+func (r *Replayer) history(ctx context.Context, transactionID int) ([]db.TransactionEvent, error) {
+	const pageSize = 100
+
+	var history []db.TransactionEvent
+	var afterID int64
+	for {
+		page, err := r.Store.ListTransactionEvents(ctx, db.TransactionEventFilter{
+			TransactionID: transactionID,
+			AfterID:       afterID,
+			Limit:         pageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, page...)
+		if len(page) < pageSize {
+			return history, nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
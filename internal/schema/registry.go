@@ -0,0 +1,135 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned for schemas missing from the registry.
+var ErrNotFound = errors.New("schema not found")
+
+// indexFile lists the schemas of a registry directory.
+const indexFile = "registry.json"
+
+// Schema is one registered schema file. IDs never change once published,
+// consumers look schemas up by the ID in the message headers.
+type Schema struct {
+	ID      int    `json:"id"`
+	Subject string `json:"subject"` // "envelope" or an event type
+	Version int    `json:"version"`
+	Format  string `json:"format"` // see the events.Format constants
+	File    string `json:"file"`
+}
+
+// Registry holds the schemas of a directory, see contract/events.
+type Registry struct {
+	dir     string
+	schemas []Schema
+}
+
+// Load reads the registry index of dir and checks every schema file exists.
+func Load(dir string) (*Registry, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry: %v", err)
+	}
+
+	var index struct {
+		Schemas []Schema `json:"schemas"`
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("invalid schema registry index: %v", err)
+	}
+
+	ids := map[int]bool{}
+	for _, schema := range index.Schemas {
+		if ids[schema.ID] {
+			return nil, fmt.Errorf("duplicate schema ID %d", schema.ID)
+		}
+		ids[schema.ID] = true
+
+		if _, err := os.Stat(filepath.Join(dir, schema.File)); err != nil {
+			return nil, fmt.Errorf("schema %d: %v", schema.ID, err)
+		}
+	}
+
+	return &Registry{dir: dir, schemas: index.Schemas}, nil
+}
+
+// Lookup finds the schema of subject at version in format.
+func (r *Registry) Lookup(subject string, version int, format string) (Schema, error) {
+	for _, schema := range r.schemas {
+		if schema.Subject == subject && schema.Version == version && schema.Format == format {
+			return schema, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: %s v%d (%s)", ErrNotFound, subject, version, format)
+}
+
+// ByID finds a schema by its registry ID, as sent in the schema-id header.
+func (r *Registry) ByID(id int) (Schema, error) {
+	for _, schema := range r.schemas {
+		if schema.ID == id {
+			return schema, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: id %d", ErrNotFound, id)
+}
+
+// Content reads the schema file.
+func (r *Registry) Content(schema Schema) ([]byte, error) {
+	return os.ReadFile(filepath.Join(r.dir, schema.File))
+}
+
+// Handler serves the registry read-only: GET /schemas lists the index and
+// GET /schemas/ids/{id} returns one schema, in the shape of the Confluent
+// schema registry API so its clients can be pointed at it.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/schemas", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.schemas)
+	})
+
+	mux.HandleFunc("/schemas/ids/", func(w http.ResponseWriter, req *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/schemas/ids/"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid schema ID"})
+			return
+		}
+
+		schema, err := r.ByID(id)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": err.Error()})
+			return
+		}
+
+		content, err := r.Content(schema)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id":         schema.ID,
+			"subject":    schema.Subject,
+			"version":    schema.Version,
+			"schemaType": strings.ToUpper(schema.Format),
+			"schema":     string(content),
+		})
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -2,22 +2,43 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	"payment-gateway/configs/envs"
 	"payment-gateway/configs/logger"
 	"payment-gateway/db"
 	"payment-gateway/internal/cache"
-	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/lock"
 	"payment-gateway/internal/models"
-	"payment-gateway/internal/utils"
+	"payment-gateway/internal/requestid"
 	"payment-gateway/internal/workers"
 )
 
+// ErrCallbackMismatch is returned when a callback names a gateway or gateway
+// transaction ID that doesn't match what we stored for the transaction.
+var ErrCallbackMismatch = errors.New("callback does not match transaction")
+
+// ErrCallbackTooEarly is returned for a callback about a transaction whose
+// gateway transaction ID isn't stored yet, e.g. because the gateway answered
+// before the worker wrote it. It is worth retrying.
+var ErrCallbackTooEarly = errors.New("transaction has no gateway transaction id yet")
+
+// ErrUnmappedStatus is returned for gateway statuses missing from
+// gateway_status_mappings. Such callbacks are rejected rather than guessed at.
+var ErrUnmappedStatus = errors.New("unmapped gateway status")
+
+// ErrStatusConflict is returned when a gateway reports a final status that
+// contradicts the final status we already have. A reconciliation alert is
+// raised instead of changing the transaction.
+var ErrStatusConflict = errors.New("callback conflicts with final transaction status")
+
+// securityEventCallbackMismatch is the security_events.event_type for ErrCallbackMismatch.
+const securityEventCallbackMismatch = "callback_mismatch"
+
 type GatewayServiceInterface interface {
 	ProcessTransaction(ctx context.Context, tx db.Transaction) error
-	HandleCallback(ctx context.Context, gatewayTxnID string, status string, transactionID int) error
+	HandleCallback(ctx context.Context, callback models.Callback) error
 	GetTransactionStatus(ctx context.Context, txID int) (db.Transaction, error)
 }
 
@@ -27,7 +48,8 @@ type GatewayService struct {
 	DB                   db.Storage
 	Cache                cache.Cache
 	TransactionProcessor workers.TransactionProcessor
-	kafkaProducer        kafka.Producer
+	locker               lock.Locker
+	createdMessage       db.OutboxMessageFunc
 	cfg                  *envs.Config
 }
 
@@ -35,38 +57,73 @@ func NewGateway(
 	db db.Storage,
 	cache cache.Cache,
 	processor workers.TransactionProcessor,
-	kafkaProducer kafka.Producer,
+	locker lock.Locker,
+	createdMessage db.OutboxMessageFunc,
 	cfg *envs.Config,
 ) GatewayServiceInterface {
 	return &GatewayService{
 		DB:                   db,
 		Cache:                cache,
 		TransactionProcessor: processor,
-		kafkaProducer:        kafkaProducer,
+		locker:               locker,
+		createdMessage:       createdMessage,
 		cfg:                  cfg,
 	}
 }
 
 func (s *GatewayService) ProcessTransaction(ctx context.Context, tx db.Transaction) error {
-	txID, err := s.DB.CreateTransaction(ctx, tx)
+	if tx.CommandID != "" {
+		return s.processCommandTransaction(ctx, tx)
+	}
+
+	// the Kafka message is written with the row and published by the outbox
+	// relay, so it is neither lost nor sent for a row that was never created
+	txID, err := s.DB.CreateTransaction(ctx, tx, s.createdMessage)
 	if err != nil {
-		return fmt.Errorf("failed to create transaction record: %v", err)
+		return fmt.Errorf("failed to create transaction record: %w", err)
 	}
-	tx.ID = txID
 
-	modelsTx := models.Transaction{
-		ID:        txID,
-		UserID:    tx.UserID,
-		Amount:    tx.Amount,
-		Currency:  tx.Currency,
-		Type:      tx.Type,
-		Status:    "pending",
-		GatewayID: tx.GatewayID,
+	if err := s.TransactionProcessor.ProcessTransaction(ctx, queuedTransaction(ctx, txID, tx)); err != nil {
+		// the row must not stay pending forever if no worker will ever pick
+		// it up, even when the request that created it was cancelled
+		rejectCtx := context.WithoutCancel(ctx)
+		if errors.Is(err, workers.ErrQueueFull) {
+			s.rejectTransaction(rejectCtx, txID, "Rejected: transaction queue is full", workers.ReasonQueueFull)
+		} else {
+			s.rejectTransaction(rejectCtx, txID, "Rejected: request ended before the transaction was queued", workers.ReasonNotQueued)
+		}
+		return fmt.Errorf("failed to enqueue transaction: %w", err)
+	}
+
+	return nil
+}
+
+// processCommandTransaction creates the transaction of a Kafka command only
+// once it has a place in the queue. A command that can't be queued is retried
+// by the consumer, and a row failed with queue_full would use up its ID.
+func (s *GatewayService) processCommandTransaction(ctx context.Context, tx db.Transaction) error {
+	reservation, err := s.TransactionProcessor.Reserve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue transaction: %w", err)
+	}
+
+	txID, err := s.DB.CreateTransaction(ctx, tx, s.createdMessage)
+	if err != nil {
+		reservation.Cancel()
+		return fmt.Errorf("failed to create transaction record: %w", err)
+	}
+
+	if err := reservation.Enqueue(queuedTransaction(ctx, txID, tx)); err != nil {
+		// only on shutdown, after which nothing picks the row up
+		s.rejectTransaction(context.WithoutCancel(ctx), txID, "Rejected: transaction processor stopped", workers.ReasonNotQueued)
+		return fmt.Errorf("failed to enqueue transaction: %w", err)
 	}
 
-	s.TransactionProcessor.ProcessTransaction(modelsTx)
+	return nil
+}
 
-	txData := models.Transaction{
+func queuedTransaction(ctx context.Context, txID int, tx db.Transaction) models.Transaction {
+	return models.Transaction{
 		ID:        txID,
 		UserID:    tx.UserID,
 		Amount:    tx.Amount,
@@ -74,62 +131,190 @@ func (s *GatewayService) ProcessTransaction(ctx context.Context, tx db.Transacti
 		Type:      tx.Type,
 		Status:    "pending",
 		GatewayID: tx.GatewayID,
+		RequestID: requestid.FromContext(ctx),
+	}
+}
+
+func (s *GatewayService) HandleCallback(ctx context.Context, callback models.Callback) error {
+	transactionID := callback.TransactionID
+
+	// the lock keeps the worker (or another callback) from writing the same
+	// transaction between our read and our write
+	lease, err := s.locker.Acquire(ctx, transactionID)
+	if err != nil {
+		return fmt.Errorf("failed to acquire transaction lock: %v", err)
+	}
+	defer func() {
+		if err := lease.Release(ctx); err != nil {
+			logger.Warn("Failed to release transaction lock", "id", transactionID, "error", err)
+		}
+	}()
+
+	tx, err := s.DB.GetTransactionByID(ctx, transactionID)
+	if err != nil {
+		return fmt.Errorf("transaction not found: %v", err)
+	}
+
+	if err := s.checkCallbackOrigin(ctx, tx, callback); err != nil {
+		return err
 	}
 
-	txDataBytes, err := json.Marshal(txData)
+	mapping, err := s.mapGatewayStatus(ctx, callback)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction data: %v", err)
+		return err
 	}
+	internalStatus := mapping.InternalStatus
 
-	maskedData := utils.MaskData(txDataBytes)
+	// gateways retry and reorder notifications, so anything that doesn't
+	// move the transaction forward is acknowledged without a write
+	if internalStatus == tx.Status {
+		logger.Info("Ignoring repeated callback", "id", transactionID, "status", tx.Status)
+		return nil
+	}
+
+	if isOlderCallback(tx, callback) {
+		logger.Info("Ignoring out-of-order callback", "id", transactionID, "status", callback.Status,
+			"sequence", callback.Sequence, "eventTime", callback.EventTime)
+		return nil
+	}
 
-	err = utils.ExecuteWithCircuitBreaker(func() error {
-		if s.kafkaProducer != nil {
-			return s.kafkaProducer.PublishMessage(ctx, s.cfg.Kafka.TransactionsTopic, []byte(maskedData))
+	if isFinalStatus(tx.Status) {
+		if !isFinalStatus(internalStatus) {
+			logger.Info("Ignoring late callback for finished transaction", "id", transactionID,
+				"status", tx.Status, "reported", internalStatus)
+			return nil
 		}
-		logger.Warn("Kafka producer not initialized, skipping message publication")
+		return s.raiseReconciliationAlert(ctx, tx, callback, internalStatus)
+	}
+
+	// gateways without sequences or timestamps can't be ordered by them, but
+	// a transaction never goes back, e.g. from processing to pending
+	if statusRank(internalStatus) < statusRank(tx.Status) {
+		logger.Info("Ignoring callback that would move the transaction back", "id", transactionID,
+			"status", tx.Status, "reported", internalStatus)
 		return nil
-	})
+	}
 
+	err = s.DB.ApplyCallback(ctx, db.CallbackUpdate{
+		TransactionID: transactionID,
+		Status:        internalStatus,
+		GatewayTxnID:  callback.GatewayTxnID,
+		ErrorMessage:  callback.ErrorMessage,
+		ReasonCode:    mapping.ReasonCode,
+		EventAt:       callback.EventTime,
+		EventSequence: callback.Sequence,
+	}, lease.Token())
 	if err != nil {
-		// Continue processing even if Kafka publish fails
-		// todo add logs
+		return fmt.Errorf("failed to update transaction status: %v", err)
 	}
 
 	return nil
 }
 
-func (s *GatewayService) HandleCallback(ctx context.Context, gatewayTxnID string, status string, transactionID int) error {
-	// todo this should be wrapped in a transaction
-	tx, err := s.DB.GetTransactionByID(ctx, transactionID)
-	if err != nil {
-		return fmt.Errorf("transaction not found: %v", err)
+// isOlderCallback reports whether the callback predates the last one applied
+// to tx. Sequence numbers win over timestamps when both sides have them.
+func isOlderCallback(tx db.Transaction, callback models.Callback) bool {
+	if callback.Sequence > 0 && tx.LastEventSequence > 0 {
+		return callback.Sequence <= tx.LastEventSequence
 	}
 
-	// todo move to enum
-	if tx.Status == "completed" || tx.Status == "failed" {
-		return fmt.Errorf("transaction already in final state: %s", tx.Status)
+	if !callback.EventTime.IsZero() && tx.LastEventAt != nil {
+		return callback.EventTime.Before(*tx.LastEventAt)
 	}
 
-	internalStatus := mapGatewayStatus(status)
+	return false
+}
 
-	err = s.DB.UpdateTransactionStatus(ctx, transactionID, internalStatus, gatewayTxnID, "")
-	if err != nil {
-		return fmt.Errorf("failed to update transaction status: %v", err)
+// statusRank orders statuses the way a transaction moves through them:
+// pending, processing, then a final status.
+func statusRank(status string) int {
+	switch status {
+	case "processing":
+		return 1
+	case "completed", "failed":
+		return 2
+	default:
+		return 0
 	}
+}
 
-	return nil
+// todo move to enum
+func isFinalStatus(status string) bool {
+	return status == "completed" || status == "failed"
+}
+
+func (s *GatewayService) raiseReconciliationAlert(ctx context.Context, tx db.Transaction, callback models.Callback, reportedStatus string) error {
+	logger.Error("Gateway reported conflicting final status, reconciliation needed", "id", tx.ID,
+		"gatewayID", callback.GatewayID, "status", tx.Status, "reported", reportedStatus)
+
+	alert := db.ReconciliationAlert{
+		TransactionID:  tx.ID,
+		GatewayID:      callback.GatewayID,
+		CurrentStatus:  tx.Status,
+		ReportedStatus: reportedStatus,
+		Details:        fmt.Sprintf("gateway status %q, gateway_txn_id %q", callback.Status, callback.GatewayTxnID),
+	}
+	if err := s.DB.CreateReconciliationAlert(ctx, alert); err != nil {
+		return fmt.Errorf("failed to create reconciliation alert: %v", err)
+	}
+
+	return fmt.Errorf("%w: %s, gateway reported %s", ErrStatusConflict, tx.Status, reportedStatus)
 }
 
-func mapGatewayStatus(gatewayStatus string) string {
-	switch gatewayStatus {
-	case "success", "completed", "approved":
-		return "completed"
-	case "failed", "declined", "rejected":
-		return "failed"
+// checkCallbackOrigin makes sure the callback comes from the gateway the
+// transaction was sent to and refers to the gateway transaction we stored.
+// Mismatches are recorded as security events.
+func (s *GatewayService) checkCallbackOrigin(ctx context.Context, tx db.Transaction, callback models.Callback) error {
+	var details string
+	switch {
+	case tx.GatewayID != callback.GatewayID:
+		details = fmt.Sprintf("gateway mismatch: stored %d, callback %d", tx.GatewayID, callback.GatewayID)
+	case tx.GatewayTxnID == "":
+		// nothing to compare against yet, which is no sign of forgery
+		return ErrCallbackTooEarly
+	case tx.GatewayTxnID != callback.GatewayTxnID:
+		details = fmt.Sprintf("gateway_txn_id mismatch: stored %q, callback %q", tx.GatewayTxnID, callback.GatewayTxnID)
 	default:
-		return "pending"
+		return nil
+	}
+
+	logger.Warn("Rejected callback", "id", tx.ID, "gatewayID", callback.GatewayID, "reason", details)
+
+	event := db.SecurityEvent{
+		Type:          securityEventCallbackMismatch,
+		TransactionID: tx.ID,
+		GatewayID:     callback.GatewayID,
+		Details:       details,
+	}
+	if err := s.DB.RecordSecurityEvent(ctx, event); err != nil {
+		logger.Error("Failed to record security event", "id", tx.ID, "error", err)
 	}
+
+	return fmt.Errorf("%w: %s", ErrCallbackMismatch, details)
+}
+
+// rejectTransaction fails a transaction that was never queued. No worker
+// has it, so it is still pending and needs no lock.
+func (s *GatewayService) rejectTransaction(ctx context.Context, txID int, errorMsg, reasonCode string) {
+	if err := s.DB.FailPendingTransaction(ctx, txID, errorMsg, reasonCode); err != nil {
+		logger.Error("Failed to mark rejected transaction as failed", "id", txID, "error", err)
+	}
+}
+
+// mapGatewayStatus looks the callback status up in the gateway's mapping
+// table. Unknown statuses are logged for review and rejected.
+func (s *GatewayService) mapGatewayStatus(ctx context.Context, callback models.Callback) (db.StatusMapping, error) {
+	mapping, err := s.DB.GetStatusMapping(ctx, callback.GatewayID, callback.Status)
+	if errors.Is(err, db.ErrStatusMappingNotFound) {
+		logger.Warn("Rejected callback with unmapped gateway status", "id", callback.TransactionID,
+			"gatewayID", callback.GatewayID, "status", callback.Status)
+		return db.StatusMapping{}, fmt.Errorf("%w: %q", ErrUnmappedStatus, callback.Status)
+	}
+	if err != nil {
+		return db.StatusMapping{}, fmt.Errorf("failed to map gateway status: %v", err)
+	}
+
+	return mapping, nil
 }
 
 func (s *GatewayService) GetTransactionStatus(ctx context.Context, txID int) (db.Transaction, error) {
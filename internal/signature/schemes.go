@@ -0,0 +1,210 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemeByName returns the scheme configured for a gateway, e.g. via
+// CALLBACK_GATEWAYS=stripe=stripe,paypal=paypal,adyen=adyen.
+func SchemeByName(name string) (Scheme, error) {
+	switch strings.ToLower(name) {
+	case "hmac", "":
+		return HMACScheme{}, nil
+	case "stripe":
+		return StripeScheme{}, nil
+	case "paypal":
+		return PayPalScheme{}, nil
+	case "adyen":
+		return AdyenScheme{}, nil
+	default:
+		return nil, fmt.Errorf("unknown signature scheme: %s", name)
+	}
+}
+
+// HMACScheme is the default: hex HMAC-SHA256 of "timestamp.nonce.body" with
+// the values in X-Signature, X-Timestamp (unix seconds) and X-Nonce.
+type HMACScheme struct{}
+
+func (HMACScheme) Sign(body []byte, secret string, timestamp time.Time, nonce string) (http.Header, error) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	header := http.Header{}
+	header.Set("X-Timestamp", ts)
+	header.Set("X-Nonce", nonce)
+	header.Set("X-Signature", hex.EncodeToString(hmacSHA256([]byte(secret), ts+"."+nonce+".", body)))
+	return header, nil
+}
+
+func (HMACScheme) Verify(header http.Header, body []byte, secret string) (Stamp, error) {
+	ts, nonce, sig := header.Get("X-Timestamp"), header.Get("X-Nonce"), header.Get("X-Signature")
+	if ts == "" || nonce == "" || sig == "" {
+		return Stamp{}, ErrMissingSignature
+	}
+
+	timestamp, err := parseUnix(ts)
+	if err != nil {
+		return Stamp{}, err
+	}
+
+	expected := hex.EncodeToString(hmacSHA256([]byte(secret), ts+"."+nonce+".", body))
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return Stamp{}, ErrInvalidSignature
+	}
+
+	return Stamp{Timestamp: timestamp, Nonce: nonce}, nil
+}
+
+// StripeScheme follows Stripe's "Stripe-Signature: t=<unix>,v1=<hex>" header
+// signing "t.body". Stripe sends no nonce, the signature itself is unique
+// per event and is used instead.
+type StripeScheme struct{}
+
+func (StripeScheme) Sign(body []byte, secret string, timestamp time.Time, _ string) (http.Header, error) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	sig := hex.EncodeToString(hmacSHA256([]byte(secret), ts+".", body))
+
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t="+ts+",v1="+sig)
+	return header, nil
+}
+
+func (StripeScheme) Verify(header http.Header, body []byte, secret string) (Stamp, error) {
+	value := header.Get("Stripe-Signature")
+	if value == "" {
+		return Stamp{}, ErrMissingSignature
+	}
+
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(value, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+
+	if ts == "" || len(sigs) == 0 {
+		return Stamp{}, ErrMissingSignature
+	}
+
+	timestamp, err := parseUnix(ts)
+	if err != nil {
+		return Stamp{}, err
+	}
+
+	// several v1 entries are sent while a secret is being rolled
+	expected := hex.EncodeToString(hmacSHA256([]byte(secret), ts+".", body))
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			return Stamp{Timestamp: timestamp, Nonce: sig}, nil
+		}
+	}
+
+	return Stamp{}, ErrInvalidSignature
+}
+
+// PayPalScheme uses PayPal's transmission headers; the signature is base64
+// HMAC-SHA256 of "transmissionID|transmissionTime|body". PayPal itself signs
+// a CRC32 of the body, which anyone can match with a different body, so the
+// body is signed as it is.
+type PayPalScheme struct{}
+
+func (PayPalScheme) Sign(body []byte, secret string, timestamp time.Time, nonce string) (http.Header, error) {
+	ts := timestamp.UTC().Format(time.RFC3339)
+
+	header := http.Header{}
+	header.Set("Paypal-Transmission-Id", nonce)
+	header.Set("Paypal-Transmission-Time", ts)
+	header.Set("Paypal-Transmission-Sig", base64.StdEncoding.EncodeToString(hmacSHA256([]byte(secret), nonce+"|"+ts+"|", body)))
+	return header, nil
+}
+
+func (PayPalScheme) Verify(header http.Header, body []byte, secret string) (Stamp, error) {
+	id := header.Get("Paypal-Transmission-Id")
+	ts := header.Get("Paypal-Transmission-Time")
+	sig := header.Get("Paypal-Transmission-Sig")
+	if id == "" || ts == "" || sig == "" {
+		return Stamp{}, ErrMissingSignature
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return Stamp{}, fmt.Errorf("%w: bad timestamp", ErrInvalidSignature)
+	}
+
+	expected := base64.StdEncoding.EncodeToString(hmacSHA256([]byte(secret), id+"|"+ts+"|", body))
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return Stamp{}, ErrInvalidSignature
+	}
+
+	return Stamp{Timestamp: timestamp, Nonce: id}, nil
+}
+
+// AdyenScheme uses Adyen's hex-encoded HMAC key and a base64 signature in
+// the HmacSignature header over "timestamp:nonce:body".
+type AdyenScheme struct{}
+
+func (AdyenScheme) Sign(body []byte, secret string, timestamp time.Time, nonce string) (http.Header, error) {
+	key, err := hex.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("adyen secret must be hex encoded: %v", err)
+	}
+
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	header := http.Header{}
+	header.Set("X-Adyen-Timestamp", ts)
+	header.Set("X-Adyen-Nonce", nonce)
+	header.Set("HmacSignature", base64.StdEncoding.EncodeToString(hmacSHA256(key, ts+":"+nonce+":", body)))
+	return header, nil
+}
+
+func (AdyenScheme) Verify(header http.Header, body []byte, secret string) (Stamp, error) {
+	ts, nonce, sig := header.Get("X-Adyen-Timestamp"), header.Get("X-Adyen-Nonce"), header.Get("HmacSignature")
+	if ts == "" || nonce == "" || sig == "" {
+		return Stamp{}, ErrMissingSignature
+	}
+
+	key, err := hex.DecodeString(secret)
+	if err != nil {
+		return Stamp{}, fmt.Errorf("adyen secret must be hex encoded: %v", err)
+	}
+
+	timestamp, err := parseUnix(ts)
+	if err != nil {
+		return Stamp{}, err
+	}
+
+	expected := base64.StdEncoding.EncodeToString(hmacSHA256(key, ts+":"+nonce+":", body))
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return Stamp{}, ErrInvalidSignature
+	}
+
+	return Stamp{Timestamp: timestamp, Nonce: nonce}, nil
+}
+
+func hmacSHA256(key []byte, prefix string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prefix))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func parseUnix(ts string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad timestamp", ErrInvalidSignature)
+	}
+	return time.Unix(seconds, 0), nil
+}
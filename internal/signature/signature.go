@@ -0,0 +1,140 @@
+package signature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing callback signature")
+	ErrInvalidSignature = errors.New("invalid callback signature")
+	ErrStaleTimestamp   = errors.New("callback timestamp outside tolerance")
+	ErrReplayedNonce    = errors.New("callback nonce already used")
+	ErrUnknownGateway   = errors.New("no callback signing configured for gateway")
+)
+
+// Stamp is what a scheme extracts from a correctly signed callback.
+type Stamp struct {
+	Timestamp time.Time
+	Nonce     string
+}
+
+// Scheme is a gateway-specific way of signing callbacks. Gateways put the
+// signature, timestamp and nonce in different headers and sign different
+// strings, so every style gets its own implementation.
+type Scheme interface {
+	Sign(body []byte, secret string, timestamp time.Time, nonce string) (http.Header, error)
+	Verify(header http.Header, body []byte, secret string) (Stamp, error)
+}
+
+// NonceStore remembers nonces for replay protection.
+type NonceStore interface {
+	// Seen reports whether nonce was remembered.
+	Seen(ctx context.Context, nonce string) (bool, error)
+	// Remember stores nonce and reports false if it was already present.
+	Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// GatewayConfig binds a gateway to its scheme and signing secret.
+type GatewayConfig struct {
+	Scheme Scheme
+	Secret string
+}
+
+// CallbackVerifier checks callbacks in two steps: Verify before the callback
+// is stored and MarkUsed once it is. A nonce recorded before the callback
+// was stored would turn the gateway's retry of a failed store into a replay.
+type CallbackVerifier interface {
+	Verify(ctx context.Context, gatewayName string, header http.Header, body []byte) (Stamp, error)
+	MarkUsed(ctx context.Context, gatewayName string, stamp Stamp) error
+}
+
+var _ CallbackVerifier = (*Verifier)(nil)
+
+type Verifier struct {
+	gateways  map[string]GatewayConfig
+	nonces    NonceStore
+	tolerance time.Duration
+}
+
+func NewVerifier(gateways map[string]GatewayConfig, nonces NonceStore, tolerance time.Duration) CallbackVerifier {
+	normalized := make(map[string]GatewayConfig, len(gateways))
+	for name, cfg := range gateways {
+		normalized[strings.ToLower(name)] = cfg
+	}
+
+	return &Verifier{
+		gateways:  normalized,
+		nonces:    nonces,
+		tolerance: tolerance,
+	}
+}
+
+// Verify checks the signature of a raw callback body sent by gatewayName,
+// rejects timestamps outside the tolerance and nonces already used.
+func (v *Verifier) Verify(ctx context.Context, gatewayName string, header http.Header, body []byte) (Stamp, error) {
+	cfg, ok := v.gateways[strings.ToLower(gatewayName)]
+	if !ok || cfg.Secret == "" {
+		return Stamp{}, fmt.Errorf("%w: %s", ErrUnknownGateway, gatewayName)
+	}
+
+	stamp, err := cfg.Scheme.Verify(header, body, cfg.Secret)
+	if err != nil {
+		return Stamp{}, err
+	}
+
+	age := time.Since(stamp.Timestamp)
+	if age > v.tolerance || age < -v.tolerance {
+		return Stamp{}, fmt.Errorf("%w: %s", ErrStaleTimestamp, stamp.Timestamp.Format(time.RFC3339))
+	}
+
+	seen, err := v.nonces.Seen(ctx, nonceKey(gatewayName, stamp))
+	if err != nil {
+		return Stamp{}, fmt.Errorf("failed to check callback nonce: %v", err)
+	}
+	if seen {
+		return Stamp{}, ErrReplayedNonce
+	}
+
+	return stamp, nil
+}
+
+// MarkUsed records the nonce of a verified callback. Two deliveries racing
+// between Verify and MarkUsed both get through, the inbox keeps one.
+func (v *Verifier) MarkUsed(ctx context.Context, gatewayName string, stamp Stamp) error {
+	// anything older than the tolerance is rejected by Verify, so nonces
+	// only need to be remembered for that long
+	if _, err := v.nonces.Remember(ctx, nonceKey(gatewayName, stamp), 2*v.tolerance); err != nil {
+		return fmt.Errorf("failed to record callback nonce: %v", err)
+	}
+	return nil
+}
+
+func nonceKey(gatewayName string, stamp Stamp) string {
+	return strings.ToLower(gatewayName) + ":" + stamp.Nonce
+}
+
+var _ NonceStore = (*RedisNonceStore)(nil)
+
+type RedisNonceStore struct {
+	client *redis.Client
+}
+
+func NewRedisNonceStore(client *redis.Client) NonceStore {
+	return &RedisNonceStore{client: client}
+}
+
+func (s *RedisNonceStore) Seen(ctx context.Context, nonce string) (bool, error) {
+	n, err := s.client.Exists(ctx, "callback:nonce:"+nonce).Result()
+	return n > 0, err
+}
+
+func (s *RedisNonceStore) Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, "callback:nonce:"+nonce, 1, ttl).Result()
+}
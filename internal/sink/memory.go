@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/pkg/events"
+)
+
+var _ EventSink = (*MemorySink)(nil)
+
+// Message is a message of a MemorySink.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// MemorySink hands every message to all current subscribers. Subscribers
+// with a full buffer miss the message rather than hold up the publisher.
+type MemorySink struct {
+	mu          sync.Mutex
+	subscribers map[int]chan Message
+	nextID      int
+	closed      bool
+
+	dropped atomic.Uint64
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{subscribers: map[int]chan Message{}}
+}
+
+// Subscribe returns a channel of the messages published from now on, with
+// room for buffer of them, and the function that unsubscribes and closes it.
+func (s *MemorySink) Subscribe(buffer int) (<-chan Message, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan Message, buffer)
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = ch
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if ch, ok := s.subscribers[id]; ok {
+			delete(s.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+// LogMessages logs every message published from now on, until the sink is
+// closed, so a service running with the memory sink shows its events.
+func (s *MemorySink) LogMessages(buffer int) {
+	messages, _ := s.Subscribe(buffer)
+	go func() {
+		for message := range messages {
+			logger.Info("Event published", "topic", message.Topic, "key", string(message.Key),
+				"eventType", message.Headers[events.HeaderEventType])
+		}
+	}()
+}
+
+// Dropped counts the messages subscribers missed.
+func (s *MemorySink) Dropped() uint64 { return s.dropped.Load() }
+
+func (s *MemorySink) PublishMessage(_ context.Context, topic string, key, message []byte, headers map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- Message{Topic: topic, Key: key, Value: message, Headers: headers}:
+		default:
+			s.dropped.Add(1)
+			logger.Warn("Event subscriber is full, message dropped", "topic", topic)
+		}
+	}
+	return nil
+}
+
+// Close closes the channels of all subscribers.
+func (s *MemorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, ch := range s.subscribers {
+		delete(s.subscribers, id)
+		close(ch)
+	}
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var _ EventSink = (*NATSSink)(nil)
+
+// NATSKeyHeader carries the message key, NATS has no keys of its own.
+const NATSKeyHeader = "message-key"
+
+// ErrInvalidSubject is returned for topics that aren't valid NATS subjects to
+// publish to.
+var ErrInvalidSubject = errors.New("invalid nats subject")
+
+// NATSSink publishes to core NATS, the topic being the subject, with the
+// official client. Each publish is flushed, so it returns once the server
+// has the message. The client reconnects by itself; publishes fail while it
+// does rather than wait in its buffer, since the relay retries them anyway.
+type NATSSink struct {
+	url       string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+// NewNATSSink connects lazily to url, nats://[user:password@]host:port or
+// nats://token@host:port, or tls://... to require TLS. tlsConfig, if not
+// nil, is used for TLS connections; without it the system roots verify the
+// server. Timeout bounds dialing and each publish, 5s if 0.
+func NewNATSSink(url string, tlsConfig *tls.Config, timeout time.Duration) *NATSSink {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &NATSSink{url: url, tlsConfig: tlsConfig, timeout: timeout}
+}
+
+func (s *NATSSink) PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error {
+	if err := validateNATSSubject(topic); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the client gives up after its reconnect attempts, dial again then
+	if s.conn == nil || s.conn.IsClosed() {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	msg := &nats.Msg{Subject: topic, Data: message, Header: nats.Header{}}
+	if len(key) > 0 {
+		msg.Header[NATSKeyHeader] = []string{string(key)}
+	}
+	for name, value := range headers {
+		msg.Header[name] = []string{value}
+	}
+
+	timeout := s.timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	// a denied subject only shows as the connection's last error, set before
+	// the flush returns
+	lastErr := s.conn.LastError()
+	if err := s.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish to nats: %v", err)
+	}
+	if err := s.conn.FlushTimeout(timeout); err != nil {
+		return fmt.Errorf("failed to publish to nats: %v", err)
+	}
+	if err := s.conn.LastError(); err != nil && err != lastErr {
+		return fmt.Errorf("failed to publish to nats: %v", err)
+	}
+	return nil
+}
+
+func (s *NATSSink) connect() error {
+	options := []nats.Option{
+		nats.Name("payment-gateway"),
+		nats.Timeout(s.timeout),
+		nats.ReconnectBufSize(-1),
+	}
+	if s.tlsConfig != nil {
+		options = append(options, nats.Secure(s.tlsConfig))
+	}
+
+	conn, err := nats.Connect(s.url, options...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to nats: %v", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// validateNATSSubject rejects subjects the server would split differently
+// or refuse: empty tokens, whitespace, and the wildcards, which only
+// subscriptions may use.
+func validateNATSSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidSubject, subject)
+		}
+	}
+	return nil
+}
+
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return nil
+}
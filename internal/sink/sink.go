@@ -0,0 +1,82 @@
+// Package sink holds the destinations the outbox relay can publish events
+// to. Kafka is the production one; NATS suits deployments without Kafka, the
+// file sink lets development see the events without any broker, and the
+// memory sink fans them out in process, to tests or to the log.
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/internal/kafka"
+)
+
+// EventSink publishes event messages. It has the method set of
+// kafka.Producer, so a Kafka producer is one.
+type EventSink interface {
+	// PublishMessage sends message to topic. Sinks that don't partition keep
+	// key and headers alongside the message.
+	PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error
+	Close() error
+}
+
+var _ EventSink = (kafka.Producer)(nil)
+
+// New returns the sink cfg.Events selects. The memory sink only logs the
+// messages: nothing else in the service subscribes to it, so they are gone
+// once published, which only suits local runs.
+func New(cfg *envs.Config) (EventSink, error) {
+	switch cfg.Events.Sink {
+	case "", "kafka":
+		return kafka.NewProducer(cfg)
+	case "nats":
+		tlsConfig, err := natsTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewNATSSink(cfg.Events.NATS.URL, tlsConfig, cfg.Events.NATS.Timeout), nil
+	case "file":
+		return NewFileSink(cfg.Events.FilePath)
+	case "memory":
+		memorySink := NewMemorySink()
+		memorySink.LogMessages(1000)
+		return memorySink, nil
+	}
+	return nil, fmt.Errorf("unknown event sink %q", cfg.Events.Sink)
+}
+
+// natsTLSConfig returns nil, for the defaults, without any NATS TLS setting.
+func natsTLSConfig(cfg *envs.Config) (*tls.Config, error) {
+	settings := cfg.Events.NATS.TLS
+	if settings.CAFile == "" && settings.CertFile == "" && settings.KeyFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if settings.CAFile != "" {
+		ca, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read nats CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in nats CA file %s", settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load nats client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
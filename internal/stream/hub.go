@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+)
+
+// channel is the Redis pub/sub channel status changes are announced on.
+const channel = "transactions:events"
+
+// subscriptionBuffer is how many events a slow client may fall behind before
+// it is disconnected. It can resume with Last-Event-ID.
+const subscriptionBuffer = 32
+
+// Subscription receives the live events of one transaction or one user.
+// Events is closed when the subscription is dropped for falling behind.
+type Subscription struct {
+	Events <-chan models.TransactionStatusEvent
+
+	transactionID int
+	userID        int
+	events        chan models.TransactionStatusEvent
+}
+
+func (s *Subscription) matches(event models.TransactionStatusEvent) bool {
+	if s.transactionID != 0 {
+		return event.TransactionID == s.transactionID
+	}
+	return event.UserID == s.userID
+}
+
+// Hub fans status changes out to the SSE clients connected to this replica.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+// SubscribeTransaction streams events of a single transaction.
+func (h *Hub) SubscribeTransaction(transactionID int) *Subscription {
+	return h.subscribe(&Subscription{transactionID: transactionID})
+}
+
+// SubscribeUser streams events of every transaction of a user.
+func (h *Hub) SubscribeUser(userID int) *Subscription {
+	return h.subscribe(&Subscription{userID: userID})
+}
+
+func (h *Hub) subscribe(sub *Subscription) *Subscription {
+	sub.events = make(chan models.TransactionStatusEvent, subscriptionBuffer)
+	sub.Events = sub.events
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Dispatch hands event to every matching subscription on this replica.
+func (h *Hub) Dispatch(event models.TransactionStatusEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !sub.matches(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			// never block the fan-out on one slow client
+			delete(h.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Listen feeds events published by any replica into the hub until ctx is
+// done. Then every subscription is closed so open streams don't hold up the
+// HTTP server shutdown.
+func (h *Hub) Listen(ctx context.Context, client *redis.Client) {
+	pubsub := client.Subscribe(ctx, channel)
+
+	go func() {
+		defer pubsub.Close()
+		defer h.closeAll()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				var event models.TransactionStatusEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					logger.Warn("Invalid transaction event on pub/sub", "error", err)
+					continue
+				}
+				h.Dispatch(event)
+			}
+		}
+	}()
+}
+
+// Publisher announces committed status changes to every replica.
+type Publisher struct {
+	client *redis.Client
+}
+
+func NewPublisher(client *redis.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+// Hook is a db.EventHook. A lost message only delays live clients, they
+// catch up from the history on reconnect, so failures are just logged.
+func (p *Publisher) Hook(ctx context.Context, event db.TransactionEvent) {
+	payload, err := json.Marshal(FromDB(event))
+	if err != nil {
+		logger.Warn("Failed to marshal transaction event", "id", event.ID, "error", err)
+		return
+	}
+
+	if err := p.client.Publish(ctx, channel, payload).Err(); err != nil {
+		logger.Warn("Failed to publish transaction event", "id", event.ID, "transactionID", event.TransactionID, "error", err)
+	}
+}
+
+func FromDB(event db.TransactionEvent) models.TransactionStatusEvent {
+	return models.TransactionStatusEvent{
+		ID:             event.ID,
+		TransactionID:  event.TransactionID,
+		UserID:         event.UserID,
+		PreviousStatus: event.PreviousStatus,
+		Status:         event.Status,
+		GatewayID:      event.GatewayID,
+		ReasonCode:     event.ReasonCode,
+		ErrorMessage:   event.ErrorMessage,
+		CreatedAt:      event.CreatedAt,
+	}
+}
@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payment-gateway/configs/logger"
+	"payment-gateway/db"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/signature"
+)
+
+// maxRetryBackoff caps the exponential backoff between attempts.
+const maxRetryBackoff = time.Hour
+
+// claimVisibility hides a claimed delivery from other replicas while it is
+// being sent. It must outlast the request timeout.
+const claimVisibility = 2 * time.Minute
+
+// Dispatcher sends queued deliveries to subscribers. A delivery counts as
+// done once the endpoint answers 2xx; anything else is retried with
+// exponential backoff until it runs out of attempts and is marked dead.
+// Subscribers may therefore see an event more than once and should dedupe on
+// its ID.
+type Dispatcher struct {
+	Store  db.WebhookStorage
+	Client *http.Client
+	Scheme signature.Scheme
+
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	delivered atomic.Uint64
+	retried   atomic.Uint64
+	dead      atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewDispatcher(
+	store db.WebhookStorage,
+	client *http.Client,
+	pollInterval time.Duration,
+	batchSize int,
+	maxAttempts int,
+	retryBackoff time.Duration,
+) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       client,
+		Scheme:       signature.HMACScheme{},
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		maxAttempts:  maxAttempts,
+		retryBackoff: retryBackoff,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (d *Dispatcher) Start(ctx context.Context) {
+	metrics.RegisterCounter("webhook_delivered_total", "Webhook deliveries acknowledged by subscribers.", func() float64 {
+		return float64(d.delivered.Load())
+	})
+	metrics.RegisterCounter("webhook_retried_total", "Webhook attempts that failed and were rescheduled.", func() float64 {
+		return float64(d.retried.Load())
+	})
+	metrics.RegisterCounter("webhook_dead_total", "Webhook deliveries given up on.", func() float64 {
+		return float64(d.dead.Load())
+	})
+
+	d.wg.Add(1)
+	go d.run(ctx)
+}
+
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.Poll(ctx)
+		}
+	}
+}
+
+// Poll claims one batch of due deliveries and sends them.
+func (d *Dispatcher) Poll(ctx context.Context) {
+	deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, d.batchSize, claimVisibility)
+	if err != nil {
+		logger.Error("Failed to claim webhook deliveries", "error", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.deliver(ctx, delivery)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery db.WebhookDelivery) {
+	statusCode, err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.Store.MarkWebhookDelivered(ctx, delivery.ID, statusCode); err != nil {
+			logger.Error("Failed to mark webhook delivered", "deliveryID", delivery.ID, "error", err)
+		}
+		d.delivered.Add(1)
+		return
+	}
+
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	dead := delivery.Attempts >= d.maxAttempts
+	nextAttemptAt := time.Now().Add(d.backoff(delivery.Attempts))
+
+	if markErr := d.Store.MarkWebhookFailed(ctx, delivery.ID, code, err.Error(), nextAttemptAt, dead); markErr != nil {
+		logger.Error("Failed to mark webhook failed", "deliveryID", delivery.ID, "error", markErr)
+	}
+
+	if dead {
+		d.dead.Add(1)
+		logger.Error("Webhook delivery gave up", "deliveryID", delivery.ID, "subscriptionID", delivery.SubscriptionID, "attempts", delivery.Attempts, "error", err)
+		return
+	}
+
+	d.retried.Add(1)
+	logger.Warn("Webhook delivery failed, will retry", "deliveryID", delivery.ID, "subscriptionID", delivery.SubscriptionID, "attempts", delivery.Attempts, "error", err)
+}
+
+// send POSTs the signed payload and returns the response status code, zero
+// if no response was received.
+func (d *Dispatcher) send(ctx context.Context, delivery db.WebhookDelivery) (int, error) {
+	// a fresh nonce per attempt, so subscribers checking nonces for replays
+	// don't reject our retries
+	header, err := d.Scheme.Sign(delivery.Payload, delivery.Secret, time.Now(), uuid.New().String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to sign webhook: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build webhook request: %v", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Event-Type", delivery.EventType)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// drain so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.retryBackoff
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrEndpointNotAllowed is returned for subscriber URLs that aren't https or
+// point at the gateway's own network.
+var ErrEndpointNotAllowed = errors.New("webhook endpoint not allowed")
+
+// ValidateURL checks a subscriber URL before it is stored. Host names are
+// not resolved here, they may resolve to anything by the time of delivery;
+// NewClient checks the address actually dialled.
+func ValidateURL(raw string) error {
+	endpoint, err := url.Parse(raw)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute https URL", ErrEndpointNotAllowed)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(endpoint.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is a local host", ErrEndpointNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrEndpointNotAllowed, host)
+	}
+
+	return nil
+}
+
+// IsPublicIP reports whether ip may receive webhooks, i.e. it is not a
+// private, loopback, link-local (which includes cloud metadata services),
+// multicast or unspecified address.
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// NewClient returns the HTTP client deliveries are sent with. It only speaks
+// https and refuses to connect to non-public addresses, whatever the host
+// name resolved to and wherever a redirect points, which also covers
+// subscriptions stored before ValidateURL existed. It doesn't go through a
+// proxy, which would hide the address from the check.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrEndpointNotAllowed, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{Timeout: timeout, Transport: httpsOnly{transport}}
+}
+
+type httpsOnly struct {
+	next http.RoundTripper
+}
+
+func (t httpsOnly) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s is not https", ErrEndpointNotAllowed, req.URL.Redacted())
+	}
+	return t.next.RoundTrip(req)
+}
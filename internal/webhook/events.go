@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"payment-gateway/db"
+)
+
+// Event types subscribers can register for. Nothing emits refund events
+// yet, there are no refunds; subscribing to them already means subscribers
+// won't have to register again once there are.
+const (
+	EventTransactionCompleted = "transaction.completed"
+	EventTransactionFailed    = "transaction.failed"
+	EventRefundSucceeded      = "refund.succeeded"
+)
+
+// EventTypes lists every event type a subscription may ask for.
+var EventTypes = []string{EventTransactionCompleted, EventTransactionFailed, EventRefundSucceeded}
+
+// IsEventType reports whether eventType is one of EventTypes.
+func IsEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON body POSTed to subscribers.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      TransactionData `json:"data"`
+}
+
+type TransactionData struct {
+	TransactionID int             `json:"transaction_id"`
+	UserID        int             `json:"user_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	GatewayID     int             `json:"gateway_id"`
+	GatewayTxnID  string          `json:"gateway_txn_id,omitempty"`
+	ErrorMessage  string          `json:"error_message,omitempty"`
+	ReasonCode    string          `json:"reason_code,omitempty"`
+}
+
+var _ db.WebhookEventFunc = StatusEvent
+
+// StatusEvent builds the webhook event for a transaction that reached a
+// final status. The storage layer queues it with the status change.
+func StatusEvent(_ context.Context, tx db.Transaction) (db.WebhookEvent, bool, error) {
+	eventType, ok := transactionEventType(tx.Status)
+	if !ok {
+		return db.WebhookEvent{}, false, nil
+	}
+
+	// a transaction reaches each final status once, so the event ID can be
+	// derived from it and queueing twice doesn't deliver twice
+	event := Event{
+		ID:        fmt.Sprintf("evt_%s_%d", eventType, tx.ID),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data: TransactionData{
+			TransactionID: tx.ID,
+			UserID:        tx.UserID,
+			Amount:        tx.Amount,
+			Currency:      tx.Currency,
+			Type:          tx.Type,
+			Status:        tx.Status,
+			GatewayID:     tx.GatewayID,
+			GatewayTxnID:  tx.GatewayTxnID,
+			ErrorMessage:  tx.ErrorMessage,
+			ReasonCode:    tx.ReasonCode,
+		},
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return db.WebhookEvent{}, false, fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+
+	transactionID := tx.ID
+	return db.WebhookEvent{
+		ID:            event.ID,
+		Type:          event.Type,
+		UserID:        tx.UserID,
+		TransactionID: &transactionID,
+		Payload:       payload,
+	}, true, nil
+}
+
+func transactionEventType(status string) (string, bool) {
+	switch status {
+	case "completed":
+		return EventTransactionCompleted, true
+	case "failed":
+		return EventTransactionFailed, true
+	default:
+		return "", false
+	}
+}
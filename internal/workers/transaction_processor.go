@@ -2,44 +2,124 @@ package workers
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"payment-gateway/configs/logger"
 	"payment-gateway/db"
+	"payment-gateway/internal/cache"
 	"payment-gateway/internal/gateway"
+	"payment-gateway/internal/lock"
+	"payment-gateway/internal/metrics"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/requestid"
+)
+
+// ErrQueueFull is returned by ProcessTransaction and Reserve when the job
+// queue stayed full for the whole enqueue timeout.
+var ErrQueueFull = errors.New("transaction queue is full")
+
+// ErrStopped is returned for transactions enqueued after Stop.
+var ErrStopped = errors.New("transaction processor stopped")
+
+// Reason codes of the status changes made here, next to the gateway ones
+// from gateway_status_mappings.
+const (
+	ReasonQueueFull       = "queue_full"
+	ReasonNotQueued       = "not_queued" // the request gave up before it was queued
+	ReasonNoRoute         = "route_unavailable"
+	ReasonGatewayFailover = "gateway_failover" // taken by a fallback gateway
+	ReasonGatewaysFailed  = "gateways_failed"
+	ReasonLockUnavailable = "lock_unavailable"
 )
 
 type TransactionProcessor interface {
 	Start(ctx context.Context)
 	Stop()
-	ProcessTransaction(tx models.Transaction)
+	ProcessTransaction(ctx context.Context, tx models.Transaction) error
+	// Reserve waits like ProcessTransaction for a place in the queue and
+	// holds it, so the transaction can be created knowing it will be queued.
+	Reserve(ctx context.Context) (Reservation, error)
+	QueueDepth() int
+}
+
+// Reservation is a place in the queue, see Reserve. Exactly one of Enqueue
+// and Cancel must be called.
+type Reservation interface {
+	// Enqueue queues tx in the reserved place without blocking.
+	Enqueue(tx models.Transaction) error
+	// Cancel gives the place back.
+	Cancel()
 }
 
 var _ TransactionProcessor = (*Processor)(nil)
 
 type Processor struct {
-	DB            db.Storage
-	WorkerCount   int
-	jobs          chan models.Transaction
-	gatewayClient gateway.GatewayClient
-	wg            sync.WaitGroup
+	DB          db.Storage
+	Cache       cache.Cache
+	Locker      lock.Locker
+	WorkerCount int
+	// LockRetries is how many times a job whose transaction lock can't be
+	// acquired goes back on the queue before the transaction is failed,
+	// LockRetryBackoff the wait before the first retry, doubled after each.
+	LockRetries      int
+	LockRetryBackoff time.Duration
+	jobs             chan job
+	enqueueTimeout   time.Duration
+	rejected         atomic.Uint64
+	gatewayClient    gateway.GatewayClient
+	wg               sync.WaitGroup
+
+	// slots holds a token for every job queued or reserved, so a reserved
+	// place is never taken by another job and sends on jobs never block
+	slots chan struct{}
+
+	// mu guards stopped, so a retry never sends on the closed queue
+	mu      sync.Mutex
+	stopped bool
+}
+
+type job struct {
+	tx           models.Transaction
+	lockAttempts int
 }
 
 func NewTransactionProcessor(
 	db db.Storage,
+	cache cache.Cache,
+	locker lock.Locker,
 	workerCount int,
+	queueSize int,
+	enqueueTimeout time.Duration,
 	gatewayClient gateway.GatewayClient,
 ) TransactionProcessor {
 	return &Processor{
-		DB:            db,
-		WorkerCount:   workerCount,
-		jobs:          make(chan models.Transaction, 100),
-		gatewayClient: gatewayClient,
+		DB:               db,
+		Cache:            cache,
+		Locker:           locker,
+		WorkerCount:      workerCount,
+		LockRetries:      3,
+		LockRetryBackoff: time.Second,
+		jobs:             make(chan job, queueSize),
+		slots:            make(chan struct{}, queueSize),
+		enqueueTimeout:   enqueueTimeout,
+		gatewayClient:    gatewayClient,
 	}
 }
 
 func (p *Processor) Start(ctx context.Context) {
+	metrics.RegisterGauge("transaction_queue_depth", "Transactions waiting for a worker.", func() float64 {
+		return float64(p.QueueDepth())
+	})
+	metrics.RegisterGauge("transaction_queue_capacity", "Maximum number of transactions waiting for a worker.", func() float64 {
+		return float64(p.QueueCapacity())
+	})
+	metrics.RegisterCounter("transaction_queue_rejected_total", "Transactions rejected because the queue was full.", func() float64 {
+		return float64(p.Rejected())
+	})
+
 	for i := 0; i < p.WorkerCount; i++ {
 		p.wg.Add(1)
 		go p.worker(ctx)
@@ -47,85 +127,230 @@ func (p *Processor) Start(ctx context.Context) {
 }
 
 func (p *Processor) Stop() {
+	p.mu.Lock()
+	p.stopped = true
 	close(p.jobs)
+	p.mu.Unlock()
+
 	p.wg.Wait()
 }
 
-func (p *Processor) ProcessTransaction(tx models.Transaction) {
-	p.jobs <- tx
+// ProcessTransaction enqueues tx for the workers. It never blocks longer than
+// the enqueue timeout, so a slow worker pool can't pin HTTP goroutines.
+func (p *Processor) ProcessTransaction(ctx context.Context, tx models.Transaction) error {
+	reservation, err := p.Reserve(ctx)
+	if err != nil {
+		return err
+	}
+	return reservation.Enqueue(tx)
+}
+
+func (p *Processor) Reserve(ctx context.Context) (Reservation, error) {
+	select {
+	case p.slots <- struct{}{}:
+		return &reservation{p: p}, nil
+	default:
+	}
+
+	timer := time.NewTimer(p.enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		return &reservation{p: p}, nil
+	case <-timer.C:
+		p.rejected.Add(1)
+		return nil, ErrQueueFull
+	case <-ctx.Done():
+		p.rejected.Add(1)
+		return nil, ctx.Err()
+	}
+}
+
+type reservation struct {
+	p *Processor
+}
+
+func (r *reservation) Enqueue(tx models.Transaction) error {
+	r.p.mu.Lock()
+	defer r.p.mu.Unlock()
+
+	if r.p.stopped {
+		<-r.p.slots
+		return ErrStopped
+	}
+	r.p.jobs <- job{tx: tx}
+	return nil
+}
+
+func (r *reservation) Cancel() {
+	<-r.p.slots
+}
+
+func (p *Processor) QueueDepth() int {
+	return len(p.jobs)
+}
+
+// QueueCapacity is the maximum number of jobs waiting for a worker.
+func (p *Processor) QueueCapacity() int {
+	return cap(p.jobs)
+}
+
+// Rejected is the number of transactions that could not be enqueued.
+func (p *Processor) Rejected() uint64 {
+	return p.rejected.Load()
 }
 
 func (p *Processor) worker(ctx context.Context) {
 	defer p.wg.Done()
 
-	for tx := range p.jobs {
-		user, err := p.DB.GetUserByID(ctx, tx.UserID)
-		if err != nil {
-			logger.Error("Failed to get user for transaction", "id", tx.ID, "error", err)
-			p.markTransactionFailed(ctx, tx.ID, "Failed to get user information")
-			continue
+	for j := range p.jobs {
+		<-p.slots
+		p.process(ctx, j)
+	}
+}
+
+func (p *Processor) process(ctx context.Context, j job) {
+	tx := j.tx
+	ctx = requestid.NewContext(ctx, tx.RequestID)
+
+	// hold the transaction lock for the whole job so a callback for the same
+	// transaction can't interleave with our status writes
+	lease, err := p.Locker.Acquire(ctx, tx.ID)
+	if err != nil {
+		j.lockAttempts++
+		if j.lockAttempts <= p.LockRetries {
+			logger.Warn("Failed to acquire transaction lock, retrying later", "id", tx.ID, "attempt", j.lockAttempts, "error", err)
+			p.retryLater(ctx, j)
+			return
+		}
+		logger.Error("Failed to acquire transaction lock", "id", tx.ID, "error", err)
+		p.failWithoutLock(ctx, tx, "Transaction lock unavailable")
+		return
+	}
+	defer func() {
+		if err := lease.Release(ctx); err != nil {
+			logger.Warn("Failed to release transaction lock", "id", tx.ID, "error", err)
 		}
+	}()
+
+	// user and gateway lookups are served from cache, so the only
+	// per-transaction DB work left is the status writes below
+	route, err := p.Cache.GetRoute(ctx, p.DB, tx.UserID)
+	if err != nil {
+		logger.Error("Failed to resolve payment route for transaction", "id", tx.ID, "error", err)
+		p.markTransactionFailed(ctx, tx, "Failed to resolve payment gateways", ReasonNoRoute, lease.Token())
+		return
+	}
+
+	gateways := route.Gateways
+
+	if len(gateways) == 0 {
+		// todo handle properly
+		gateways = []db.Gateway{{ID: tx.GatewayID}}
+	}
+
+	var lastError error
+
+	for i, gateway := range gateways {
+		currentTx := tx
+		currentTx.GatewayID = gateway.ID
+
+		gatewayTxnID, err := p.gatewayClient.ProcessPayment(ctx, currentTx)
 
-		gateways, err := p.DB.GetGatewaysByCountry(ctx, user.CountryID)
 		if err != nil {
-			logger.Error("Failed to get gateways for transaction", "id", tx.ID, "error", err)
-			p.markTransactionFailed(ctx, tx.ID, "Failed to get payment gateways")
+			logger.Warn("Gateway processing failed, trying fallback",
+				"txID", tx.ID,
+				"gatewayID", gateway.ID,
+				"error", err)
+			lastError = err
 			continue
 		}
 
-		if len(gateways) == 0 {
-			// todo handle properly
-			gateways = []db.Gateway{{ID: tx.GatewayID}}
+		// todo wrap to transaction or single execution
+		// the gateway goes first so the status event names the gateway
+		// that took the transaction
+		err = p.DB.UpdateTransactionGateway(ctx, tx.ID, gateway.ID, lease.Token())
+		if errors.Is(err, db.ErrStaleFencingToken) {
+			// another worker holds the transaction now, its writes win
+			logger.Error("Lost transaction lock before recording gateway, stopping", "id", tx.ID,
+				"gatewayID", gateway.ID, "gatewayTxnID", gatewayTxnID, "error", err)
+			return
+		}
+		if err != nil {
+			// the gateway has taken the payment, the log is what reconciles it
+			logger.Error("Failed to update transaction gateway, stopping", "id", tx.ID,
+				"gatewayID", gateway.ID, "gatewayTxnID", gatewayTxnID, "error", err)
+			return
 		}
 
-		var lastError error
-		var succeeded bool
-
-		for _, gateway := range gateways {
-			currentTx := tx
-			currentTx.GatewayID = gateway.ID
+		reasonCode := ""
+		if i > 0 {
+			reasonCode = ReasonGatewayFailover
+		}
 
-			gatewayTxnID, err := p.gatewayClient.ProcessPayment(ctx, currentTx)
+		err = p.DB.UpdateTransactionStatus(ctx, tx.ID, "processing", gatewayTxnID, "", reasonCode, lease.Token())
+		if err != nil {
+			logger.Warn("Failed to update transaction state", "id", tx.ID, "error", err)
+		}
 
-			if err != nil {
-				logger.Warn("Gateway processing failed, trying fallback",
-					"txID", tx.ID,
-					"gatewayID", gateway.ID,
-					"error", err)
-				lastError = err
-				continue
-			}
+		return
+	}
 
-			// todo wrap to transaction or single execution
-			err = p.DB.UpdateTransactionStatus(ctx, tx.ID, "processing", gatewayTxnID, "")
-			if err != nil {
-				logger.Warn("Failed to update transaction state", "id", tx.ID, "error", err)
-			}
+	errorMsg := "All payment gateways failed"
+	if lastError != nil {
+		errorMsg = lastError.Error()
+	}
 
-			err = p.DB.UpdateTransactionGateway(ctx, tx.ID, gateway.ID)
-			if err != nil {
-				logger.Warn("Failed to update transaction gateway", "id", tx.ID, "gatewayID", gateway.ID, "error", err)
-			}
+	p.markTransactionFailed(ctx, tx, errorMsg, ReasonGatewaysFailed, lease.Token())
+}
 
-			succeeded = true
+// retryLater puts j back on the queue after its backoff. The queue may be
+// full or closed by then; either way the transaction is failed rather than
+// left pending with nobody to pick it up.
+func (p *Processor) retryLater(ctx context.Context, j job) {
+	// the retry outlives this job, and a shutdown must still see it through
+	ctx = context.WithoutCancel(ctx)
 
-			break
-		}
+	p.wg.Add(1)
+	time.AfterFunc(p.LockRetryBackoff<<(j.lockAttempts-1), func() {
+		defer p.wg.Done()
 
-		if !succeeded {
-			errorMsg := "All payment gateways failed"
-			if lastError != nil {
-				errorMsg = lastError.Error()
+		p.mu.Lock()
+		requeued := false
+		if !p.stopped {
+			select {
+			case p.slots <- struct{}{}:
+				p.jobs <- j
+				requeued = true
+			default:
 			}
+		}
+		p.mu.Unlock()
 
-			p.markTransactionFailed(ctx, tx.ID, errorMsg)
+		if !requeued {
+			logger.Error("Failed to requeue transaction waiting for its lock", "id", j.tx.ID)
+			p.failWithoutLock(ctx, j.tx, "Transaction lock unavailable")
 		}
+	})
+}
+
+// failWithoutLock fails a transaction that never got its lock, unless it
+// moved on in the meantime.
+func (p *Processor) failWithoutLock(ctx context.Context, tx models.Transaction, errorMsg string) {
+	err := p.DB.FailPendingTransaction(ctx, tx.ID, errorMsg, ReasonLockUnavailable)
+	if errors.Is(err, db.ErrTransactionNotPending) {
+		logger.Info("Transaction moved on without its lock holder", "id", tx.ID, "error", err)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to fail transaction without its lock, it stays pending", "id", tx.ID, "error", err)
 	}
 }
 
-func (p *Processor) markTransactionFailed(ctx context.Context, txID int, errorMsg string) {
-	err := p.DB.UpdateTransactionStatus(ctx, txID, "failed", "", errorMsg)
+func (p *Processor) markTransactionFailed(ctx context.Context, tx models.Transaction, errorMsg, reasonCode string, fencingToken int64) {
+	err := p.DB.UpdateTransactionStatus(ctx, tx.ID, "failed", "", errorMsg, reasonCode, fencingToken)
 	if err != nil {
-		logger.Warn("Failed to update transaction status", "id", txID, "error", err)
+		logger.Warn("Failed to update transaction status", "id", tx.ID, "error", err)
 	}
 }
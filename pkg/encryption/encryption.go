@@ -0,0 +1,242 @@
+// Package encryption encrypts the sensitive data of published events, and is
+// what consumers decrypt it with. Every message is sealed with its own data
+// key, which is wrapped by a master key of a KeyProvider and stored in the
+// ciphertext, along with the master key's ID. Master keys can so be rotated:
+// the provider keeps old keys for decryption while new data keys are wrapped
+// with the current one.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrUnknownKey is returned for ciphertexts sealed with a master key the
+	// provider doesn't have.
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrMalformed is returned for input that isn't a ciphertext of this
+	// package, or was tampered with.
+	ErrMalformed = errors.New("malformed ciphertext")
+)
+
+// Ciphertexts are base64 and start with a format version byte:
+//
+//	1: len(key ID) | key ID | nonce | data sealed with the master key
+//	2: len(key ID) | key ID | len(wrapped key) (2 bytes) | wrapped data key |
+//	   nonce | data sealed with the data key
+//
+// Everything before the nonce is authenticated along with the data.
+const (
+	formatMasterKey = 1 // written before envelope encryption, still read
+	formatDataKey   = 2
+)
+
+const dataKeySize = 32 // AES-256
+
+// KeyProvider holds the master keys that wrap data keys.
+type KeyProvider interface {
+	// CurrentKeyID names the master key new data keys are wrapped with.
+	CurrentKeyID() string
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey returns ErrUnknownKey for a retired key it no longer has.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// masterKeys is implemented by providers that can read format 1
+// ciphertexts, which were sealed with the master key itself.
+type masterKeys interface {
+	masterKey(keyID string) (cipher.AEAD, bool)
+}
+
+// Cipher seals data with AES-256-GCM under per-message data keys.
+type Cipher struct {
+	provider KeyProvider
+}
+
+func New(provider KeyProvider) *Cipher {
+	return &Cipher{provider: provider}
+}
+
+// KeyID is the ID of the master key Encrypt uses.
+func (c *Cipher) KeyID() string { return c.provider.CurrentKeyID() }
+
+// Encrypt seals plaintext and returns the base64 ciphertext.
+func (c *Cipher) Encrypt(ctx context.Context, plaintext []byte) (string, error) {
+	keyID := c.provider.CurrentKeyID()
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %v", err)
+	}
+	wrapped, err := c.provider.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	if len(keyID) > 255 || len(wrapped) > 0xffff {
+		return "", fmt.Errorf("key ID or wrapped key too long")
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := []byte{formatDataKey, byte(len(keyID))}
+	header = append(header, keyID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	sealed, err := seal(aead, header, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a ciphertext of Encrypt, with whichever master key it names.
+func (c *Cipher) Decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
+	parsed, err := parse(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var aead cipher.AEAD
+	switch parsed.format {
+	case formatMasterKey:
+		keys, ok := c.provider.(masterKeys)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, parsed.keyID)
+		}
+		if aead, ok = keys.masterKey(parsed.keyID); !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, parsed.keyID)
+		}
+	default:
+		dataKey, err := c.provider.UnwrapKey(ctx, parsed.keyID, parsed.wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		if aead, err = newAEAD(dataKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return open(aead, parsed.header, parsed.body)
+}
+
+// IsCurrent reports whether ciphertext is in the current format under the
+// current master key, i.e. needs no re-encryption.
+func (c *Cipher) IsCurrent(ciphertext string) (bool, error) {
+	parsed, err := parse(ciphertext)
+	if err != nil {
+		return false, err
+	}
+	return parsed.format == formatDataKey && parsed.keyID == c.provider.CurrentKeyID(), nil
+}
+
+// KeyID returns the ID of the master key ciphertext was sealed with.
+func KeyID(ciphertext string) (string, error) {
+	parsed, err := parse(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return parsed.keyID, nil
+}
+
+// ParseKey decodes a base64 key, as kept in configuration.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %v", err)
+	}
+	return key, nil
+}
+
+type ciphertext struct {
+	format     byte
+	keyID      string
+	wrappedKey []byte
+	header     []byte // authenticated, up to the nonce
+	body       []byte // nonce and sealed data
+}
+
+func parse(encoded string) (ciphertext, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ciphertext{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(raw) < 2 || (raw[0] != formatMasterKey && raw[0] != formatDataKey) {
+		return ciphertext{}, ErrMalformed
+	}
+
+	parsed := ciphertext{format: raw[0]}
+	n := 2 + int(raw[1])
+	if len(raw) < n {
+		return ciphertext{}, ErrMalformed
+	}
+	parsed.keyID = string(raw[2:n])
+
+	if parsed.format == formatDataKey {
+		if len(raw) < n+2 {
+			return ciphertext{}, ErrMalformed
+		}
+		wrappedLen := int(binary.BigEndian.Uint16(raw[n:]))
+		n += 2
+		if len(raw) < n+wrappedLen {
+			return ciphertext{}, ErrMalformed
+		}
+		parsed.wrappedKey = raw[n : n+wrappedLen]
+		n += wrappedLen
+	}
+
+	parsed.header, parsed.body = raw[:n], raw[n:]
+	return parsed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %v", err)
+	}
+	return aead, nil
+}
+
+// seal returns header | nonce | sealed plaintext, authenticating header.
+func seal(aead cipher.AEAD, header, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	out := append(append([]byte(nil), header...), nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// open reverses seal, given the header and what follows it.
+func open(aead cipher.AEAD, header, body []byte) ([]byte, error) {
+	if len(body) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, sealed := body[:aead.NonceSize()], body[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,116 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var _ KeyProvider = (*LocalKeyProvider)(nil)
+
+// LocalKeyProvider holds master keys in memory, read from the environment or
+// a key file. To rotate, add a new key and make it current; keep the old one
+// until nothing sealed under it is left, see cmd/reencrypt.
+type LocalKeyProvider struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider wraps data keys with keys[currentID]. All keys must be
+// 32 bytes.
+func NewLocalKeyProvider(currentID string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("current encryption key %q is not in the key set", currentID)
+	}
+
+	p := &LocalKeyProvider{currentID: currentID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("encryption key ID must be 1-255 bytes, got %q", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %v", id, err)
+		}
+		p.keys[id] = aead
+	}
+	return p, nil
+}
+
+// keyFile is the format of LoadKeyFile.
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // base64, by ID
+}
+
+// LoadKeyFile reads a key set like
+//
+//	{"current": "v2", "keys": {"v1": "<base64>", "v2": "<base64>"}}
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %v", path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		if keys[id], err = ParseKey(encoded); err != nil {
+			return nil, fmt.Errorf("encryption key %q: %v", id, err)
+		}
+	}
+	return NewLocalKeyProvider(file.Current, keys)
+}
+
+// ParseKeys decodes a comma-separated list of id:base64 keys, as kept in
+// configuration.
+func ParseKeys(list string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption key %q is not id:base64", entry)
+		}
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %v", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string { return p.currentID }
+
+func (p *LocalKeyProvider) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	return seal(aead, nil, dataKey)
+}
+
+func (p *LocalKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	return open(aead, nil, wrapped)
+}
+
+func (p *LocalKeyProvider) masterKey(keyID string) (cipher.AEAD, bool) {
+	aead, ok := p.keys[keyID]
+	return aead, ok
+}
@@ -0,0 +1,106 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var _ KeyProvider = (*VaultKeyProvider)(nil)
+
+// VaultKeyProvider wraps data keys with a HashiCorp Vault transit key, so the
+// master key never leaves Vault. It is a minimal client: a static token, no
+// renewal, namespaces or batching. Vault versions the transit key itself, so
+// rotating it there needs no change here; the key ID is "vault:<key name>".
+type VaultKeyProvider struct {
+	addr    string
+	token   string
+	keyName string
+	client  *http.Client
+}
+
+func NewVaultKeyProvider(addr, token, keyName string, client *http.Client) *VaultKeyProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &VaultKeyProvider{addr: strings.TrimRight(addr, "/"), token: token, keyName: keyName, client: client}
+}
+
+func (p *VaultKeyProvider) CurrentKeyID() string { return "vault:" + p.keyName }
+
+func (p *VaultKeyProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	if keyID != p.CurrentKeyID() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	var response struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := p.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(response.Ciphertext), nil
+}
+
+func (p *VaultKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != p.CurrentKeyID() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	var response struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &response); err != nil {
+		return nil, err
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault returned a malformed key: %v", err)
+	}
+	return dataKey, nil
+}
+
+// call posts request to the transit endpoint operation and decodes the data
+// of the response into response.
+func (p *VaultKeyProvider) call(ctx context.Context, operation string, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vault request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/transit/%s/%s", p.addr, operation, p.keyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create vault request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault %s failed: %v", operation, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("vault %s returned %s: %v", operation, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s returned %s: %s", operation, resp.Status, strings.Join(envelope.Errors, "; "))
+	}
+
+	if err := json.Unmarshal(envelope.Data, response); err != nil {
+		return fmt.Errorf("failed to decode vault %s response: %v", operation, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Kafka headers set on every published message.
+const (
+	// HeaderSchemaID is the registry ID of the schema of the event data, in
+	// the format named by HeaderContentType.
+	HeaderSchemaID    = "schema-id"
+	HeaderContentType = "content-type"
+	HeaderEventType   = "event-type"
+	// HeaderRequestID is the envelope's CorrelationID, only set when there
+	// is one.
+	HeaderRequestID = "request-id"
+	// HeaderEncryptionKeyID names the key of encrypted events.
+	HeaderEncryptionKeyID = "encryption-key-id"
+	// HeaderReplay is set, to the RFC 3339 time of the replay, on events
+	// republished by cmd/replay. They keep their original IDs.
+	HeaderReplay = "replay"
+)
+
+// Formats of the schemas in the registry, one per Codec.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Codec turns envelopes into message values and back.
+type Codec interface {
+	// Format is the registry format of the schemas the codec writes.
+	Format() string
+	ContentType() string
+	Marshal(envelope Envelope) ([]byte, error)
+	Unmarshal(value []byte) (Envelope, error)
+}
+
+var (
+	_ Codec = JSONCodec{}
+	_ Codec = ProtobufCodec{}
+)
+
+// CodecByName returns the codec of a format, see the Format constants.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case FormatJSON:
+		return JSONCodec{}, nil
+	case FormatProtobuf:
+		return ProtobufCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown event format %q", name)
+}
+
+// CodecByContentType returns the codec that wrote a message, given its
+// HeaderContentType.
+func CodecByContentType(contentType string) (Codec, error) {
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown event content type %q", contentType)
+}
+
+// JSONCodec writes envelopes as JSON, see contract/events/*.json.
+type JSONCodec struct{}
+
+func (JSONCodec) Format() string { return FormatJSON }
+
+func (JSONCodec) ContentType() string { return "application/json" }
+
+func (JSONCodec) Marshal(envelope Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+func (JSONCodec) Unmarshal(value []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return Envelope{}, fmt.Errorf("failed to unmarshal envelope: %v", err)
+	}
+	return envelope, nil
+}
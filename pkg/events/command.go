@@ -0,0 +1,13 @@
+package events
+
+// TypeRequestPayout is a command other services send to the commands topic
+// to have a withdrawal made. The envelope ID identifies the command: a
+// command redelivered or resent with the same ID creates one transaction.
+const TypeRequestPayout = "command.request_payout"
+
+// RequestPayout is the data of TypeRequestPayout commands.
+type RequestPayout struct {
+	UserID   int    `json:"user_id" protobuf:"1"`
+	Amount   string `json:"amount" protobuf:"2"` // decimal
+	Currency string `json:"currency" protobuf:"3"`
+}
@@ -0,0 +1,95 @@
+// Package events defines the messages the payment gateway publishes to Kafka.
+// Every message is an Envelope; its Type and SchemaVersion tell consumers
+// which of the types below Data holds. A Codec serializes envelopes; the
+// JSON and protobuf schemas are in contract/events.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrEncrypted is returned by Envelope.Decode for encrypted data, which only
+// holders of the key can read, see DecodeWith.
+var ErrEncrypted = errors.New("event data is encrypted")
+
+// Decrypter opens the ciphertext of encrypted events, see pkg/encryption.
+type Decrypter interface {
+	Decrypt(ctx context.Context, ciphertext string) ([]byte, error)
+}
+
+// Envelope wraps every published event.
+type Envelope struct {
+	// ID is unique per event and stable across redeliveries, dedupe on it.
+	ID            string    `json:"id" protobuf:"1"`
+	Type          string    `json:"type" protobuf:"2"`
+	SchemaVersion int       `json:"schema_version" protobuf:"3"`
+	OccurredAt    time.Time `json:"occurred_at" protobuf:"4"`
+	// CorrelationID is the X-Request-ID of the request that caused the
+	// event, including the worker's processing of a transaction the request
+	// created. Empty for events of background work.
+	CorrelationID string `json:"correlation_id,omitempty" protobuf:"5"`
+	// Encrypted events carry Data as a JSON string holding the
+	// pkg/encryption ciphertext of the data object.
+	Encrypted bool            `json:"encrypted,omitempty" protobuf:"6"`
+	Data      json.RawMessage `json:"data" protobuf:"7"`
+}
+
+// NewEnvelope wraps data, which must be one of the event types of this
+// package, at its current schema version.
+func NewEnvelope(id, eventType string, occurredAt time.Time, correlationID string, data any) (Envelope, error) {
+	version, ok := SchemaVersions[eventType]
+	if !ok {
+		return Envelope{}, fmt.Errorf("unknown event type %q", eventType)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to marshal %s data: %v", eventType, err)
+	}
+
+	return Envelope{
+		ID:            id,
+		Type:          eventType,
+		SchemaVersion: version,
+		OccurredAt:    occurredAt.UTC(),
+		CorrelationID: correlationID,
+		Data:          raw,
+	}, nil
+}
+
+// Decode unmarshals Data into v.
+func (e Envelope) Decode(v any) error {
+	if e.Encrypted {
+		return ErrEncrypted
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// DecodeWith unmarshals Data into v, decrypting it with d if the envelope
+// is encrypted.
+func (e Envelope) DecodeWith(ctx context.Context, d Decrypter, v any) error {
+	if !e.Encrypted {
+		return e.Decode(v)
+	}
+
+	var ciphertext string
+	if err := json.Unmarshal(e.Data, &ciphertext); err != nil {
+		return fmt.Errorf("encrypted data is not a string: %v", err)
+	}
+
+	data, err := d.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s data: %w", e.Type, err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// EventID is the envelope ID of an event derived from a stored record, so
+// republishing the record yields the same ID.
+func EventID(eventType string, recordID int64) string {
+	return fmt.Sprintf("evt_%s_%d", eventType, recordID)
+}
@@ -0,0 +1,217 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ProtobufCodec writes envelopes in the protobuf wire format of
+// contract/events/*.proto. Fields are numbered by their protobuf struct tags;
+// occurred_at is a google.protobuf.Timestamp. The data field holds the event
+// message, or the ciphertext for encrypted events.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Format() string { return FormatProtobuf }
+
+func (ProtobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (ProtobufCodec) Marshal(envelope Envelope) ([]byte, error) {
+	if envelope.Encrypted {
+		var ciphertext string
+		if err := json.Unmarshal(envelope.Data, &ciphertext); err != nil {
+			return nil, fmt.Errorf("encrypted data is not a string: %v", err)
+		}
+		envelope.Data = []byte(ciphertext)
+	} else {
+		data, ok := newData(envelope.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown event type %q", envelope.Type)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(envelope.Data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(data); err != nil {
+			return nil, fmt.Errorf("data does not match %s: %v", envelope.Type, err)
+		}
+
+		encoded, err := marshalMessage(reflect.ValueOf(data).Elem())
+		if err != nil {
+			return nil, err
+		}
+		envelope.Data = encoded
+	}
+
+	return marshalMessage(reflect.ValueOf(envelope))
+}
+
+func (ProtobufCodec) Unmarshal(value []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := unmarshalMessage(value, reflect.ValueOf(&envelope).Elem()); err != nil {
+		return Envelope{}, fmt.Errorf("failed to unmarshal envelope: %v", err)
+	}
+
+	var data any = string(envelope.Data)
+	if !envelope.Encrypted {
+		typed, ok := newData(envelope.Type)
+		if !ok {
+			return Envelope{}, fmt.Errorf("unknown event type %q", envelope.Type)
+		}
+		if err := unmarshalMessage(envelope.Data, reflect.ValueOf(typed).Elem()); err != nil {
+			return Envelope{}, fmt.Errorf("failed to unmarshal %s: %v", envelope.Type, err)
+		}
+		data = typed
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, err
+	}
+	envelope.Data = raw
+
+	return envelope, nil
+}
+
+// marshalMessage encodes the tagged fields of a struct. Zero values are left
+// out, as proto3 does.
+func marshalMessage(message reflect.Value) ([]byte, error) {
+	var b []byte
+	for i := 0; i < message.NumField(); i++ {
+		number, ok := fieldNumber(message.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		field := message.Field(i)
+		if field.IsZero() {
+			continue
+		}
+
+		switch {
+		case field.Type() == timeType:
+			t := field.Interface().(time.Time)
+			var timestamp []byte
+			timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+			timestamp = protowire.AppendVarint(timestamp, uint64(t.Unix()))
+			if t.Nanosecond() != 0 {
+				timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+				timestamp = protowire.AppendVarint(timestamp, uint64(t.Nanosecond()))
+			}
+			b = protowire.AppendTag(b, number, protowire.BytesType)
+			b = protowire.AppendBytes(b, timestamp)
+		case field.Kind() == reflect.String:
+			b = protowire.AppendTag(b, number, protowire.BytesType)
+			b = protowire.AppendString(b, field.String())
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8:
+			b = protowire.AppendTag(b, number, protowire.BytesType)
+			b = protowire.AppendBytes(b, field.Bytes())
+		case field.Kind() == reflect.Bool:
+			b = protowire.AppendTag(b, number, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1)
+		case field.CanInt():
+			b = protowire.AppendTag(b, number, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(field.Int()))
+		default:
+			return nil, fmt.Errorf("unsupported field type %s", field.Type())
+		}
+	}
+
+	return b, nil
+}
+
+// unmarshalMessage decodes b into the tagged fields of a struct. Unknown
+// field numbers are skipped, a known field with the wrong wire type is an
+// error.
+func unmarshalMessage(b []byte, message reflect.Value) error {
+	fields := map[protowire.Number]reflect.Value{}
+	for i := 0; i < message.NumField(); i++ {
+		if number, ok := fieldNumber(message.Type().Field(i)); ok {
+			fields[number] = message.Field(i)
+		}
+	}
+
+	for len(b) > 0 {
+		number, wireType, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		field, known := fields[number]
+		if !known {
+			n = protowire.ConsumeFieldValue(number, wireType, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		want := protowire.VarintType
+		if field.Type() == timeType || field.Kind() == reflect.String || field.Kind() == reflect.Slice {
+			want = protowire.BytesType
+		}
+		if wireType != want {
+			return fmt.Errorf("field %d has wire type %d, want %d", number, wireType, want)
+		}
+
+		if want == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+
+			if field.Kind() == reflect.Bool {
+				field.SetBool(v != 0)
+			} else {
+				field.SetInt(int64(v))
+			}
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case field.Type() == timeType:
+			var timestamp struct {
+				Seconds int64 `protobuf:"1"`
+				Nanos   int32 `protobuf:"2"`
+			}
+			if err := unmarshalMessage(v, reflect.ValueOf(&timestamp).Elem()); err != nil {
+				return fmt.Errorf("field %d: %v", number, err)
+			}
+			field.Set(reflect.ValueOf(time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC()))
+		case field.Kind() == reflect.String:
+			field.SetString(string(v))
+		default:
+			field.SetBytes(append([]byte(nil), v...))
+		}
+	}
+
+	return nil
+}
+
+func fieldNumber(field reflect.StructField) (protowire.Number, bool) {
+	tag := field.Tag.Get("protobuf")
+	if tag == "" {
+		return 0, false
+	}
+
+	number, err := strconv.Atoi(tag)
+	if err != nil {
+		return 0, false
+	}
+	return protowire.Number(number), true
+}
@@ -0,0 +1,62 @@
+package events
+
+import "time"
+
+const (
+	// TypeTransactionCreated is published to the transactions topic, with
+	// encrypted data, when a deposit or withdrawal is accepted.
+	TypeTransactionCreated = "transaction.created"
+	// TypeStatusChanged is published to the status events topic, with
+	// encrypted data, for every status transition, including creation, keyed
+	// by transaction ID.
+	TypeStatusChanged = "transaction.status_changed"
+)
+
+// SchemaVersions is the current schema version of every event and command
+// type. A breaking change to a type needs a new version and a new contract
+// file.
+var SchemaVersions = map[string]int{
+	TypeTransactionCreated: 1,
+	TypeStatusChanged:      1,
+	TypeRequestPayout:      1,
+}
+
+// TransactionCreated is the data of TypeTransactionCreated events.
+type TransactionCreated struct {
+	TransactionID int    `json:"transaction_id" protobuf:"1"`
+	UserID        int    `json:"user_id" protobuf:"2"`
+	Amount        string `json:"amount" protobuf:"3"` // decimal
+	Currency      string `json:"currency" protobuf:"4"`
+	Type          string `json:"type" protobuf:"5"` // deposit or withdrawal
+	Status        string `json:"status" protobuf:"6"`
+	GatewayID     int    `json:"gateway_id,omitempty" protobuf:"7"` // requested gateway, if any
+}
+
+// StatusChanged is the data of TypeStatusChanged events.
+type StatusChanged struct {
+	// EventID is the ID of the change in the gateway's transaction_events
+	// table. It is global rather than per transaction, so it has gaps, but
+	// it increases with every change of the same transaction.
+	EventID        int64     `json:"event_id" protobuf:"1"`
+	TransactionID  int       `json:"transaction_id" protobuf:"2"`
+	UserID         int       `json:"user_id" protobuf:"3"`
+	PreviousStatus string    `json:"previous_status,omitempty" protobuf:"4"` // empty for the creation event
+	Status         string    `json:"status" protobuf:"5"`
+	GatewayID      int       `json:"gateway_id,omitempty" protobuf:"6"`
+	ReasonCode     string    `json:"reason_code,omitempty" protobuf:"7"`
+	ErrorMessage   string    `json:"error_message,omitempty" protobuf:"8"`
+	OccurredAt     time.Time `json:"occurred_at" protobuf:"9"`
+}
+
+// newData returns a pointer to a zero value of the data type of eventType.
+func newData(eventType string) (any, bool) {
+	switch eventType {
+	case TypeTransactionCreated:
+		return &TransactionCreated{}, true
+	case TypeStatusChanged:
+		return &StatusChanged{}, true
+	case TypeRequestPayout:
+		return &RequestPayout{}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/stream"
+)
+
+func newAuthTestRouter(ctrl *gomock.Controller, mockService *mocks.MockGatewayServiceInterface, mockKeys *mocks.MockKeyManager) http.Handler {
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{Allowed: true}, nil).AnyTimes()
+
+	return api.SetupRouter(mocks.NewMockStorage(ctrl), mockService, mockLimiter, mockLimiter, "", mockKeys, mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+}
+
+func newDepositRequest(apiKey string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/deposit",
+		strings.NewReader(`{"user_id": 123, "amount": "10.00", "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	return req
+}
+
+func TestAuth_MissingKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	rec := httptest.NewRecorder()
+	newAuthTestRouter(ctrl, mockService, mockKeys).ServeHTTP(rec, newDepositRequest(""))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuth_InvalidKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "bad").Return(auth.Identity{}, auth.ErrInvalidKey)
+
+	rec := httptest.NewRecorder()
+	newAuthTestRouter(ctrl, mockService, mockKeys).ServeHTTP(rec, newDepositRequest("bad"))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuth_MissingScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "read-only").
+		Return(auth.Identity{KeyID: 1, Scopes: []string{auth.ScopeRead}}, nil)
+	// ProcessTransaction must not be called
+
+	rec := httptest.NewRecorder()
+	newAuthTestRouter(ctrl, mockService, mockKeys).ServeHTTP(rec, newDepositRequest("read-only"))
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAuth_KeyBoundToAnotherUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	otherUser := 456
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "user-key").
+		Return(auth.Identity{KeyID: 1, Scopes: []string{auth.ScopeDeposit}, UserID: &otherUser}, nil)
+
+	rec := httptest.NewRecorder()
+	newAuthTestRouter(ctrl, mockService, mockKeys).ServeHTTP(rec, newDepositRequest("user-key"))
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAuth_AdminKeyCanDeposit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "admin").
+		Return(auth.Identity{KeyID: 1, Scopes: []string{auth.ScopeAdmin}}, nil)
+	mockService.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
+
+	rec := httptest.NewRecorder()
+	newAuthTestRouter(ctrl, mockService, mockKeys).ServeHTTP(rec, newDepositRequest("admin"))
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestKeyService_Authenticate_Success(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockAPIKeyStorage(ctrl)
+
+	ctx := context.Background()
+	key := db.APIKey{ID: 7, Name: "checkout", Scopes: []string{auth.ScopeDeposit}}
+
+	mockStore.EXPECT().GetAPIKeyByHash(gomock.Any(), auth.HashKey("raw-key")).Return(key, nil)
+	mockStore.EXPECT().TouchAPIKey(gomock.Any(), 7, gomock.Any()).Return(nil)
+
+	service := auth.NewKeyService(mockStore)
+
+	identity, err := service.Authenticate(ctx, "raw-key")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, identity.KeyID)
+	assert.True(t, identity.HasScope(auth.ScopeDeposit))
+	assert.False(t, identity.HasScope(auth.ScopeWithdrawal))
+}
+
+func TestKeyService_Authenticate_Expired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockAPIKeyStorage(ctrl)
+
+	ctx := context.Background()
+	expiredAt := time.Now().Add(-time.Minute)
+	key := db.APIKey{ID: 7, Scopes: []string{auth.ScopeDeposit}, ExpiresAt: &expiredAt}
+
+	mockStore.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(key, nil)
+
+	service := auth.NewKeyService(mockStore)
+
+	_, err := service.Authenticate(ctx, "raw-key")
+
+	assert.ErrorIs(t, err, auth.ErrExpiredKey)
+}
+
+func TestKeyService_RotateKey_KeepsScopes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockAPIKeyStorage(ctrl)
+
+	ctx := context.Background()
+	old := db.APIKey{ID: 7, Name: "checkout", Scopes: []string{auth.ScopeDeposit, auth.ScopeRead}}
+
+	mockStore.EXPECT().GetAPIKeyByID(gomock.Any(), 7).Return(old, nil)
+	mockStore.EXPECT().RotateAPIKey(gomock.Any(), 7, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ int, newKey db.APIKey, oldExpiresAt time.Time) (int, error) {
+			assert.Equal(t, old.Scopes, newKey.Scopes)
+			assert.Equal(t, old.Name, newKey.Name)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), oldExpiresAt, time.Minute)
+			return 8, nil
+		})
+
+	service := auth.NewKeyService(mockStore)
+
+	rawKey, key, err := service.RotateKey(ctx, 7, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 8, key.ID)
+	assert.Equal(t, auth.HashKey(rawKey), key.KeyHash)
+}
+
+func TestKeyService_CreateKey_RejectsUnknownScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockAPIKeyStorage(ctrl)
+
+	service := auth.NewKeyService(mockStore)
+
+	_, _, err := service.CreateKey(context.Background(), "bad", []string{"superuser"}, nil, nil)
+
+	assert.ErrorIs(t, err, auth.ErrBadScope)
+}
+
+func TestAPIKeys_RotateReadsChunkedBody(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body  io.Reader
+		grace time.Duration
+	}{
+		// a chunked body has ContentLength -1, the grace period must not be ignored
+		"chunked":       {body: io.MultiReader(strings.NewReader(`{"grace_period_seconds": 60}`)), grace: time.Minute},
+		"empty chunked": {body: io.MultiReader(strings.NewReader("")), grace: 24 * time.Hour},
+		"empty":         {body: nil, grace: 24 * time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockKeys := mocks.NewMockKeyManager(ctrl)
+			mockKeys.EXPECT().Authenticate(gomock.Any(), "admin").Return(auth.Identity{Scopes: []string{auth.ScopeAdmin}}, nil)
+			mockKeys.EXPECT().RotateKey(gomock.Any(), 7, tc.grace).Return("pgw_new", db.APIKey{ID: 8}, nil)
+
+			router := newAuthTestRouter(ctrl, mocks.NewMockGatewayServiceInterface(ctrl), mockKeys)
+
+			req := httptest.NewRequest(http.MethodPost, "/api-keys/7/rotate", tc.body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-Key", "admin")
+			if tc.body != nil {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		})
+	}
+}
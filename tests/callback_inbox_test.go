@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/inbox"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/stream"
+)
+
+// newInboxTestRouter verifies every callback; stored says whether the
+// callback ends up in the inbox and so uses up its nonce.
+func newInboxTestRouter(ctrl *gomock.Controller, mockDB *mocks.MockStorage, mockInbox *mocks.MockCallbackInboxStorage, parser callback.Parser, stored bool) http.Handler {
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{Allowed: true}, nil).AnyTimes()
+
+	stamp := signature.Stamp{Timestamp: time.Now(), Nonce: "nonce-1"}
+	mockVerifier := mocks.NewMockCallbackVerifier(ctrl)
+	mockVerifier.EXPECT().Verify(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(stamp, nil).AnyTimes()
+	if stored {
+		mockVerifier.EXPECT().MarkUsed(gomock.Any(), gomock.Any(), stamp).Return(nil)
+	}
+
+	// the handler must only store callbacks, never apply them
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	return api.SetupRouter(mockDB, mockService, mockLimiter, mockLimiter, "", mocks.NewMockKeyManager(ctrl), mockVerifier, parser, mockInbox, mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+}
+
+func TestGatewayCallbackHandler_StoresInInbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+
+	body := `{"event_id": "evt-1", "gateway_txn_id": "gateway-txn-1", "status": "success"}`
+
+	mockDB.EXPECT().GetGatewayByName(gomock.Any(), "stripe").Return(db.Gateway{ID: 1, Name: "Stripe"}, nil)
+	mockInbox.EXPECT().EnqueueCallback(gomock.Any(), db.InboxCallback{
+		GatewayID:   1,
+		EventID:     "evt-1",
+		ContentType: "application/json",
+		Body:        []byte(body),
+	}).Return(10, false, nil)
+
+	router := newInboxTestRouter(ctrl, mockDB, mockInbox, callback.NewParser(nil), true)
+
+	req := httptest.NewRequest(http.MethodPost, "/callbacks/stripe", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGatewayCallbackHandler_DuplicateIsAcknowledged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+
+	mockDB.EXPECT().GetGatewayByName(gomock.Any(), "stripe").Return(db.Gateway{ID: 1, Name: "Stripe"}, nil)
+	mockInbox.EXPECT().EnqueueCallback(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, cb db.InboxCallback) (int, bool, error) {
+			// no event_id in the payload, so the body hash is used
+			assert.True(t, strings.HasPrefix(cb.EventID, "sha256:"))
+			return 0, true, nil
+		})
+
+	router := newInboxTestRouter(ctrl, mockDB, mockInbox, callback.NewParser(nil), true)
+
+	req := httptest.NewRequest(http.MethodPost, "/callbacks/stripe", strings.NewReader(callbackBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGatewayCallbackHandler_XMLDefaultsToGatewayFormat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+
+	body := `<notification><pspReference>txn-3</pspReference><status>declined</status></notification>`
+	parser := callback.NewParser(map[string]callback.FieldMapping{
+		"adyen": {Reference: "pspReference"},
+	})
+
+	mockDB.EXPECT().GetGatewayByName(gomock.Any(), "adyen").Return(db.Gateway{ID: 3, Name: "Adyen", DataFormatSupported: "XML"}, nil)
+	mockInbox.EXPECT().EnqueueCallback(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, cb db.InboxCallback) (int, bool, error) {
+			assert.Equal(t, "application/xml", cb.ContentType)
+			return 11, false, nil
+		})
+
+	router := newInboxTestRouter(ctrl, mockDB, mockInbox, parser, true)
+
+	// no Content-Type, so the gateway's XML data format is assumed
+	req := httptest.NewRequest(http.MethodPost, "/callbacks/adyen", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGatewayCallbackHandler_StoreFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+
+	mockDB.EXPECT().GetGatewayByName(gomock.Any(), "stripe").Return(db.Gateway{ID: 1, Name: "Stripe"}, nil)
+	mockInbox.EXPECT().EnqueueCallback(gomock.Any(), gomock.Any()).Return(0, false, assert.AnError)
+
+	// the nonce stays unused, so the gateway's retry isn't taken for a replay
+	router := newInboxTestRouter(ctrl, mockDB, mockInbox, callback.NewParser(nil), false)
+
+	req := httptest.NewRequest(http.MethodPost, "/callbacks/stripe", strings.NewReader(callbackBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func newTestInboxProcessor(ctrl *gomock.Controller, mockInbox *mocks.MockCallbackInboxStorage, mockDB *mocks.MockStorage, mockService *mocks.MockGatewayServiceInterface, parser callback.Parser) *inbox.Processor {
+	return inbox.NewProcessor(mockInbox, mockDB, mockService, parser, time.Second, 10, 3, time.Second)
+}
+
+func TestInboxProcessor_ResolvesByGatewayReference(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	cb := db.InboxCallback{
+		ID:          1,
+		GatewayID:   3,
+		GatewayName: "Adyen",
+		ContentType: "application/xml",
+		Body:        []byte(`<notification><pspReference>txn-3</pspReference><status>declined</status><reason>Card expired</reason></notification>`),
+		Attempts:    1,
+	}
+	parser := callback.NewParser(map[string]callback.FieldMapping{
+		"adyen": {Reference: "pspReference", ErrorMessage: "reason"},
+	})
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockDB.EXPECT().GetTransactionByGatewayTxnID(gomock.Any(), 3, "txn-3").Return(db.Transaction{ID: 7, GatewayID: 3}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), models.Callback{
+		TransactionID: 7, GatewayID: 3, GatewayTxnID: "txn-3", Status: "declined", ErrorMessage: "Card expired",
+	}).Return(nil)
+	mockInbox.EXPECT().MarkCallbackProcessed(gomock.Any(), 1, "").Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, parser).Poll(context.Background())
+}
+
+func TestInboxProcessor_RetriesTransientFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 2, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 1}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(assert.AnError)
+	mockInbox.EXPECT().MarkCallbackFailed(gomock.Any(), 2, gomock.Any(), gomock.Any(), false).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
+
+func TestInboxProcessor_DeadAfterMaxAttempts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 3, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 3}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(assert.AnError)
+	mockInbox.EXPECT().MarkCallbackFailed(gomock.Any(), 3, gomock.Any(), gomock.Any(), true).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
+
+func TestInboxProcessor_MismatchIsDeadImmediately(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 4, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 1}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(services.ErrCallbackMismatch)
+	mockInbox.EXPECT().MarkCallbackFailed(gomock.Any(), 4, gomock.Any(), gomock.Any(), true).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
+
+func TestInboxProcessor_StatusConflictIsNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 5, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 2}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(services.ErrStatusConflict)
+	mockInbox.EXPECT().MarkCallbackProcessed(gomock.Any(), 5, gomock.Any()).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
+
+func TestInboxProcessor_UnmappedStatusIsDeadImmediately(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 6, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 1}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(services.ErrUnmappedStatus)
+	mockInbox.EXPECT().MarkCallbackFailed(gomock.Any(), 6, gomock.Any(), gomock.Any(), true).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
+
+func TestInboxProcessor_TooEarlyCallbackIsRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockInbox := mocks.NewMockCallbackInboxStorage(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+
+	txID := 1
+	cb := db.InboxCallback{ID: 6, GatewayID: 1, GatewayName: "Stripe", TransactionID: &txID,
+		ContentType: "application/json", Body: []byte(callbackBody), Attempts: 1}
+
+	mockInbox.EXPECT().ClaimCallbacks(gomock.Any(), 10, gomock.Any()).Return([]db.InboxCallback{cb}, nil)
+	mockService.EXPECT().HandleCallback(gomock.Any(), gomock.Any()).Return(services.ErrCallbackTooEarly)
+	mockInbox.EXPECT().MarkCallbackFailed(gomock.Any(), 6, gomock.Any(), gomock.Any(), false).Return(nil)
+
+	newTestInboxProcessor(ctrl, mockInbox, mockDB, mockService, callback.NewParser(nil)).Poll(context.Background())
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+)
+
+// newOrderingTestService returns a service whose lock and read of tx are
+// already expected.
+func newOrderingTestService(ctrl *gomock.Controller, mockDB *mocks.MockStorage, tx db.Transaction) services.GatewayServiceInterface {
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), tx.ID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), tx.ID).Return(tx, nil)
+	expectStatusMappings(mockDB)
+
+	return services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mocks.NewMockTransactionProcessor(ctrl),
+		mockLocker, nil, &envs.Config{})
+}
+
+func TestHandleCallback_OlderSequenceIgnored(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1", LastEventSequence: 5}
+	// ApplyCallback must not be called
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "failed", Sequence: 3,
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_OlderTimestampIgnored(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	lastEventAt := time.Now()
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1", LastEventAt: &lastEventAt}
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "success", EventTime: lastEventAt.Add(-time.Minute),
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_NewerSequenceApplied(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1", LastEventSequence: 5}
+
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{
+		TransactionID: 1, Status: "completed", GatewayTxnID: "gateway-txn-1", EventSequence: 6,
+	}, int64(1)).Return(nil)
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "success", Sequence: 6,
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_PendingAfterCompletedIgnored(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	tx := db.Transaction{ID: 1, Status: "completed", GatewayID: 1, GatewayTxnID: "gateway-txn-1"}
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "pending",
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_PendingAfterProcessingIgnored(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	// neither side has a sequence or timestamp to order by
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1"}
+	// ApplyCallback must not be called
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "pending",
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_ConflictingFinalStatusRaisesAlert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	tx := db.Transaction{ID: 1, Status: "completed", GatewayID: 1, GatewayTxnID: "gateway-txn-1"}
+
+	mockDB.EXPECT().CreateReconciliationAlert(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, alert db.ReconciliationAlert) error {
+			assert.Equal(t, 1, alert.TransactionID)
+			assert.Equal(t, "completed", alert.CurrentStatus)
+			assert.Equal(t, "failed", alert.ReportedStatus)
+			return nil
+		})
+	// ApplyCallback must not be called
+
+	service := newOrderingTestService(ctrl, mockDB, tx)
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "declined",
+	})
+
+	assert.ErrorIs(t, err, services.ErrStatusConflict)
+}
+
+func TestCallbackParser_EventPosition(t *testing.T) {
+	parser := callback.NewParser(nil)
+
+	payload, err := parser.Parse("Stripe", "application/json",
+		[]byte(`{"gateway_txn_id": "txn-1", "status": "success", "event_time": "2024-05-01T10:00:00Z", "sequence": 42}`))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), payload.EventTime.UTC())
+	assert.Equal(t, int64(42), payload.Sequence)
+
+	payload, err = parser.Parse("Stripe", "application/x-www-form-urlencoded",
+		[]byte(`gateway_txn_id=txn-1&status=success&event_time=1714557600`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1714557600), payload.EventTime.Unix())
+
+	_, err = parser.Parse("Stripe", "application/json",
+		[]byte(`{"gateway_txn_id": "txn-1", "status": "success", "sequence": "first"}`))
+	assert.ErrorIs(t, err, callback.ErrInvalidField)
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+)
+
+func TestHandleCallback_GatewayTxnIDMismatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1"}
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil)
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), 1).Return(tx, nil)
+	mockDB.EXPECT().RecordSecurityEvent(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, event db.SecurityEvent) error {
+			assert.Equal(t, "callback_mismatch", event.Type)
+			assert.Equal(t, 1, event.TransactionID)
+			assert.Equal(t, 1, event.GatewayID)
+			return nil
+		})
+	// ApplyCallback must not be called
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mocks.NewMockTransactionProcessor(ctrl),
+		mockLocker, nil, &envs.Config{})
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "someone-elses-txn", Status: "success",
+	})
+
+	assert.ErrorIs(t, err, services.ErrCallbackMismatch)
+}
+
+func TestHandleCallback_GatewayMismatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	tx := db.Transaction{ID: 1, Status: "processing", GatewayID: 1, GatewayTxnID: "gateway-txn-1"}
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil)
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), 1).Return(tx, nil)
+	mockDB.EXPECT().RecordSecurityEvent(gomock.Any(), gomock.Any()).Return(nil)
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mocks.NewMockTransactionProcessor(ctrl),
+		mockLocker, nil, &envs.Config{})
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 2, GatewayTxnID: "gateway-txn-1", Status: "success",
+	})
+
+	assert.ErrorIs(t, err, services.ErrCallbackMismatch)
+}
+
+func TestHandleCallback_NoGatewayTxnIDYetIsRetryable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	tx := db.Transaction{ID: 1, Status: "pending", GatewayID: 1}
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil)
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), 1).Return(tx, nil)
+	// no security event, nothing was forged
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mocks.NewMockTransactionProcessor(ctrl),
+		mockLocker, nil, &envs.Config{})
+
+	err := service.HandleCallback(context.Background(), models.Callback{
+		TransactionID: 1, GatewayID: 1, GatewayTxnID: "gateway-txn-1", Status: "success",
+	})
+
+	assert.ErrorIs(t, err, services.ErrCallbackTooEarly)
+	assert.NotErrorIs(t, err, services.ErrCallbackMismatch)
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-gateway/internal/callback"
+)
+
+func TestCallbackParser_ContentTypes(t *testing.T) {
+	parser := callback.NewParser(map[string]callback.FieldMapping{
+		"Adyen":  {Reference: "pspReference", Status: "status", ErrorMessage: "reason"},
+		"PayPal": {Reference: "resource.id", Status: "resource.status"},
+	})
+
+	tests := []struct {
+		name        string
+		gateway     string
+		contentType string
+		body        string
+		expected    callback.Payload
+	}{
+		{
+			name:        "json",
+			gateway:     "Stripe",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"gateway_txn_id": "txn-1", "status": "failed", "error_message": "Insufficient funds"}`,
+			expected:    callback.Payload{GatewayTxnID: "txn-1", Status: "failed", ErrorMessage: "Insufficient funds"},
+		},
+		{
+			name:        "nested json",
+			gateway:     "paypal",
+			contentType: "application/json",
+			body:        `{"resource": {"id": "txn-2", "status": "completed"}}`,
+			expected:    callback.Payload{GatewayTxnID: "txn-2", Status: "completed"},
+		},
+		{
+			name:        "xml",
+			gateway:     "adyen",
+			contentType: "application/xml",
+			body:        `<notification><pspReference>txn-3</pspReference><status>declined</status><reason>Card expired</reason></notification>`,
+			expected:    callback.Payload{GatewayTxnID: "txn-3", Status: "declined", ErrorMessage: "Card expired"},
+		},
+		{
+			name:        "form",
+			gateway:     "Stripe",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `gateway_txn_id=txn-4&status=success`,
+			expected:    callback.Payload{GatewayTxnID: "txn-4", Status: "success"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := parser.Parse(tt.gateway, tt.contentType, []byte(tt.body))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, payload)
+		})
+	}
+}
+
+func TestCallbackParser_UnsupportedContentType(t *testing.T) {
+	_, err := callback.NewParser(nil).Parse("Stripe", "text/plain", []byte("gateway_txn_id=txn-1"))
+
+	assert.ErrorIs(t, err, callback.ErrUnsupportedContentType)
+}
+
+func TestCallbackParser_MissingReference(t *testing.T) {
+	_, err := callback.NewParser(nil).Parse("Stripe", "application/json", []byte(`{"status": "success"}`))
+
+	assert.ErrorIs(t, err, callback.ErrMissingField)
+}
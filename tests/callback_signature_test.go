@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/stream"
+)
+
+const callbackBody = `{"gateway_txn_id": "gateway-txn-1", "status": "success"}`
+
+func TestCallbackSignature_SchemesRoundTrip(t *testing.T) {
+	schemes := map[string]string{
+		"hmac":   "top-secret",
+		"stripe": "whsec_top-secret",
+		"paypal": "top-secret",
+		"adyen":  "44782DEF547AAA06C910C43932B1EB0C71FC68D9D0C057550C48EC2ACF6BA056",
+	}
+
+	for name, secret := range schemes {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockNonces := mocks.NewMockNonceStore(ctrl)
+			mockNonces.EXPECT().Seen(gomock.Any(), gomock.Any()).Return(false, nil)
+
+			scheme, err := signature.SchemeByName(name)
+			assert.NoError(t, err)
+
+			verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+				"Gateway": {Scheme: scheme, Secret: secret},
+			}, mockNonces, 5*time.Minute)
+
+			header, err := scheme.Sign([]byte(callbackBody), secret, time.Now(), "nonce-1")
+			assert.NoError(t, err)
+
+			_, err = verifier.Verify(context.Background(), "gateway", header, []byte(callbackBody))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCallbackSignature_TamperedBody(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockNonces := mocks.NewMockNonceStore(ctrl)
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+		"stripe": {Scheme: signature.StripeScheme{}, Secret: "secret"},
+	}, mockNonces, 5*time.Minute)
+
+	header, _ := signature.StripeScheme{}.Sign([]byte(callbackBody), "secret", time.Now(), "")
+
+	tampered := strings.Replace(callbackBody, "success", "failed", 1)
+	_, err := verifier.Verify(context.Background(), "stripe", header, []byte(tampered))
+
+	assert.ErrorIs(t, err, signature.ErrInvalidSignature)
+}
+
+func TestCallbackSignature_PayPalTamperedBody(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockNonces := mocks.NewMockNonceStore(ctrl)
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+		"paypal": {Scheme: signature.PayPalScheme{}, Secret: "secret"},
+	}, mockNonces, 5*time.Minute)
+
+	header, _ := signature.PayPalScheme{}.Sign([]byte(callbackBody), "secret", time.Now(), "nonce-1")
+
+	// a body with the checksum of the signed one takes no secret to make
+	tampered := sameCRC32([]byte(strings.Replace(callbackBody, "success", "failed", 1)), []byte(callbackBody))
+	assert.Equal(t, crc32.ChecksumIEEE([]byte(callbackBody)), crc32.ChecksumIEEE(tampered))
+
+	_, err := verifier.Verify(context.Background(), "paypal", header, tampered)
+
+	assert.ErrorIs(t, err, signature.ErrInvalidSignature)
+}
+
+// sameCRC32 appends four bytes to data so that its CRC-32 is the one of
+// original. The checksum of a fixed-length message is affine in its bits,
+// so the suffix is found by solving a linear system over GF(2).
+func sameCRC32(data, original []byte) []byte {
+	withSuffix := func(suffix uint32) []byte {
+		return binary.LittleEndian.AppendUint32(append([]byte{}, data...), suffix)
+	}
+	base := crc32.ChecksumIEEE(withSuffix(0))
+
+	// basis[bit] is a combination of suffix bits, combos[bit], whose effect
+	// on the checksum has bit as its highest bit
+	var basis, combos [32]uint32
+	for i := 0; i < 32; i++ {
+		column, combo := crc32.ChecksumIEEE(withSuffix(1<<i))^base, uint32(1)<<i
+		for bit := 31; bit >= 0 && column != 0; bit-- {
+			if column&(1<<bit) == 0 {
+				continue
+			}
+			if basis[bit] == 0 {
+				basis[bit], combos[bit] = column, combo
+				break
+			}
+			column, combo = column^basis[bit], combo^combos[bit]
+		}
+	}
+
+	want := crc32.ChecksumIEEE(original) ^ base
+	var suffix uint32
+	for bit := 31; bit >= 0; bit-- {
+		if want&(1<<bit) != 0 {
+			want, suffix = want^basis[bit], suffix^combos[bit]
+		}
+	}
+	return withSuffix(suffix)
+}
+
+func TestCallbackSignature_StaleTimestamp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockNonces := mocks.NewMockNonceStore(ctrl)
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+		"stripe": {Scheme: signature.StripeScheme{}, Secret: "secret"},
+	}, mockNonces, 5*time.Minute)
+
+	header, _ := signature.StripeScheme{}.Sign([]byte(callbackBody), "secret", time.Now().Add(-time.Hour), "")
+
+	_, err := verifier.Verify(context.Background(), "stripe", header, []byte(callbackBody))
+
+	assert.ErrorIs(t, err, signature.ErrStaleTimestamp)
+}
+
+func TestCallbackSignature_ReplayedNonce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockNonces := mocks.NewMockNonceStore(ctrl)
+	mockNonces.EXPECT().Seen(gomock.Any(), "paypal:nonce-1").Return(true, nil)
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+		"paypal": {Scheme: signature.PayPalScheme{}, Secret: "secret"},
+	}, mockNonces, 5*time.Minute)
+
+	header, _ := signature.PayPalScheme{}.Sign([]byte(callbackBody), "secret", time.Now(), "nonce-1")
+
+	_, err := verifier.Verify(context.Background(), "paypal", header, []byte(callbackBody))
+
+	assert.ErrorIs(t, err, signature.ErrReplayedNonce)
+}
+
+func TestCallbackSignature_UnknownGateway(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{}, mocks.NewMockNonceStore(ctrl), 5*time.Minute)
+
+	_, err := verifier.Verify(context.Background(), "stripe", http.Header{}, []byte(callbackBody))
+
+	assert.ErrorIs(t, err, signature.ErrUnknownGateway)
+}
+
+func TestCallbackHandler_RejectsInvalidSignature(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockVerifier := mocks.NewMockCallbackVerifier(ctrl)
+
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{Allowed: true}, nil).AnyTimes()
+	mockService.EXPECT().GetTransactionStatus(gomock.Any(), 1).Return(db.Transaction{ID: 1, GatewayID: 1}, nil)
+	mockDB.EXPECT().GetGatewayByID(gomock.Any(), 1).Return(db.Gateway{ID: 1, Name: "Stripe"}, nil)
+	mockVerifier.EXPECT().Verify(gomock.Any(), "Stripe", gomock.Any(), []byte(callbackBody)).Return(signature.Stamp{}, signature.ErrInvalidSignature)
+	// HandleCallback must not be called
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, mockLimiter, "", mocks.NewMockKeyManager(ctrl), mockVerifier, callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	req := httptest.NewRequest(http.MethodPost, "/callback/1", strings.NewReader(callbackBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestCallbackSignature_NonceUsedOnlyWhenMarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockNonces := mocks.NewMockNonceStore(ctrl)
+
+	verifier := signature.NewVerifier(map[string]signature.GatewayConfig{
+		"paypal": {Scheme: signature.PayPalScheme{}, Secret: "secret"},
+	}, mockNonces, 5*time.Minute)
+
+	header, _ := signature.PayPalScheme{}.Sign([]byte(callbackBody), "secret", time.Now(), "nonce-1")
+
+	// Verify only looks, a callback that fails to be stored can be retried
+	mockNonces.EXPECT().Seen(gomock.Any(), "paypal:nonce-1").Return(false, nil).Times(2)
+	stamp, err := verifier.Verify(context.Background(), "paypal", header, []byte(callbackBody))
+	assert.NoError(t, err)
+	_, err = verifier.Verify(context.Background(), "paypal", header, []byte(callbackBody))
+	assert.NoError(t, err)
+
+	mockNonces.EXPECT().Remember(gomock.Any(), "paypal:nonce-1", 10*time.Minute).Return(true, nil)
+	assert.NoError(t, verifier.MarkUsed(context.Background(), "paypal", stamp))
+}
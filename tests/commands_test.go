@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/commands"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/requestid"
+	"payment-gateway/internal/workers"
+	"payment-gateway/pkg/events"
+)
+
+func newPayoutCommand(t *testing.T, codec events.Codec, id string, data any) kafka.Message {
+	envelope, err := events.NewEnvelope(id, events.TypeRequestPayout, time.Now(), "req-7", data)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	value, err := codec.Marshal(envelope)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return kafka.Message{
+		Topic:   "payment-commands",
+		Key:     []byte("3"),
+		Value:   value,
+		Headers: map[string]string{events.HeaderContentType: codec.ContentType()},
+	}
+}
+
+func newCommandConsumer(ctrl *gomock.Controller) (*commands.Consumer, *mocks.MockGatewayServiceInterface, *mocks.MockProducer) {
+	service := mocks.NewMockGatewayServiceInterface(ctrl)
+	producer := mocks.NewMockProducer(ctrl)
+	consumer := commands.NewConsumer(mocks.NewMockConsumer(ctrl), mocks.NewMockConsumer(ctrl), producer, service,
+		"payment-commands-retry", "payment-commands-dlq", 3, time.Second)
+	return consumer, service, producer
+}
+
+func TestCommandConsumer_CreatesWithdrawal(t *testing.T) {
+	for _, codec := range []events.Codec{events.JSONCodec{}, events.ProtobufCodec{}} {
+		t.Run(codec.Format(), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			consumer, service, _ := newCommandConsumer(ctrl)
+
+			service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, tx db.Transaction) error {
+					assert.Equal(t, "req-7", requestid.FromContext(ctx))
+					assert.Equal(t, "cmd-1", tx.CommandID)
+					assert.Equal(t, 3, tx.UserID)
+					assert.True(t, decimal.RequireFromString("25.50").Equal(tx.Amount))
+					assert.Equal(t, "EUR", tx.Currency)
+					assert.Equal(t, "withdrawal", tx.Type)
+					assert.Equal(t, "pending", tx.Status)
+					return nil
+				})
+
+			message := newPayoutCommand(t, codec, "cmd-1", events.RequestPayout{UserID: 3, Amount: "25.50", Currency: "EUR"})
+			assert.NoError(t, consumer.Handle(context.Background(), message))
+		})
+	}
+}
+
+func TestCommandConsumer_DuplicateIsDone(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	consumer, service, _ := newCommandConsumer(ctrl)
+
+	service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).
+		Return(fmt.Errorf("failed to create transaction record: %w", db.ErrDuplicateCommand))
+
+	message := newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "10", Currency: "EUR"})
+	assert.NoError(t, consumer.Handle(context.Background(), message))
+}
+
+func TestCommandConsumer_QueueFullIsRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	consumer, service, producer := newCommandConsumer(ctrl)
+
+	// no transaction was created, so the command ID is still free
+	service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).
+		Return(fmt.Errorf("failed to enqueue transaction: %w", workers.ErrQueueFull))
+
+	message := newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "10", Currency: "EUR"})
+	producer.EXPECT().PublishMessage(gomock.Any(), "payment-commands-retry", message.Key, message.Value, gomock.Any()).Return(nil)
+
+	assert.NoError(t, consumer.Handle(context.Background(), message))
+}
+
+func TestCommandConsumer_InvalidCommandGoesToDeadLetterTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		message func(t *testing.T) kafka.Message
+	}{
+		{name: "non-positive amount", message: func(t *testing.T) kafka.Message {
+			return newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "-5", Currency: "EUR"})
+		}},
+		{name: "malformed amount", message: func(t *testing.T) kafka.Message {
+			return newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "lots", Currency: "EUR"})
+		}},
+		{name: "no command ID", message: func(t *testing.T) kafka.Message {
+			return newPayoutCommand(t, events.JSONCodec{}, "", events.RequestPayout{UserID: 3, Amount: "5", Currency: "EUR"})
+		}},
+		{name: "not an envelope", message: func(t *testing.T) kafka.Message {
+			return kafka.Message{Topic: "payment-commands", Value: []byte("{")}
+		}},
+		{name: "unknown content type", message: func(t *testing.T) kafka.Message {
+			message := newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "5", Currency: "EUR"})
+			message.Headers[events.HeaderContentType] = "text/csv"
+			return message
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			consumer, _, producer := newCommandConsumer(ctrl)
+			message := tt.message(t)
+
+			producer.EXPECT().PublishMessage(gomock.Any(), "payment-commands-dlq", message.Key, message.Value, gomock.Any()).DoAndReturn(
+				func(_ context.Context, _ string, _, _ []byte, headers map[string]string) error {
+					assert.Equal(t, "1", headers[commands.HeaderAttempt])
+					assert.NotEmpty(t, headers[commands.HeaderError])
+					assert.Empty(t, headers[commands.HeaderNotBefore])
+					return nil
+				})
+
+			assert.NoError(t, consumer.Handle(context.Background(), message))
+		})
+	}
+}
+
+func TestCommandConsumer_FailureGoesToRetryTopicThenDeadLetter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	consumer, service, producer := newCommandConsumer(ctrl)
+	service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(errors.New("database down")).Times(2)
+
+	message := newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "10", Currency: "EUR"})
+
+	var retry map[string]string
+	producer.EXPECT().PublishMessage(gomock.Any(), "payment-commands-retry", message.Key, message.Value, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _, _ []byte, headers map[string]string) error {
+			retry = headers
+			return nil
+		})
+	assert.NoError(t, consumer.Handle(context.Background(), message))
+
+	assert.Equal(t, "1", retry[commands.HeaderAttempt])
+	assert.Equal(t, "database down", retry[commands.HeaderError])
+	notBefore, err := time.Parse(time.RFC3339Nano, retry[commands.HeaderNotBefore])
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Second), notBefore, 500*time.Millisecond)
+
+	// the last attempt, 3 of 3, gives up; it was due already
+	message.Headers = retry
+	message.Headers[commands.HeaderAttempt] = "2"
+	message.Headers[commands.HeaderNotBefore] = time.Now().Add(-time.Second).Format(time.RFC3339Nano)
+	producer.EXPECT().PublishMessage(gomock.Any(), "payment-commands-dlq", message.Key, message.Value, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, _, _ []byte, headers map[string]string) error {
+			assert.Equal(t, "3", headers[commands.HeaderAttempt])
+			assert.Empty(t, headers[commands.HeaderNotBefore])
+			return nil
+		})
+	assert.NoError(t, consumer.Handle(context.Background(), message))
+}
+
+func TestCommandConsumer_CommitsOnlyAfterHandling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	commandReader := mocks.NewMockConsumer(ctrl)
+	retryReader := mocks.NewMockConsumer(ctrl)
+	service := mocks.NewMockGatewayServiceInterface(ctrl)
+	producer := mocks.NewMockProducer(ctrl)
+
+	message := newPayoutCommand(t, events.JSONCodec{}, "cmd-1", events.RequestPayout{UserID: 3, Amount: "10", Currency: "EUR"})
+	blockUntilDone := func(ctx context.Context) (kafka.Message, error) {
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+
+	committed := make(chan struct{})
+	gomock.InOrder(
+		commandReader.EXPECT().FetchMessage(gomock.Any()).Return(message, nil),
+		// a failed attempt to reach the retry topic must not let the offset move
+		service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(errors.New("database down")),
+		producer.EXPECT().PublishMessage(gomock.Any(), "payment-commands-retry", gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("kafka down")),
+		service.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil),
+		commandReader.EXPECT().CommitMessage(gomock.Any(), message).DoAndReturn(func(context.Context, kafka.Message) error {
+			close(committed)
+			return nil
+		}),
+		commandReader.EXPECT().FetchMessage(gomock.Any()).DoAndReturn(blockUntilDone),
+	)
+	retryReader.EXPECT().FetchMessage(gomock.Any()).DoAndReturn(blockUntilDone)
+
+	consumer := commands.NewConsumer(commandReader, retryReader, producer, service,
+		"payment-commands-retry", "payment-commands-dlq", 3, 10*time.Millisecond)
+	consumer.Start(context.Background())
+
+	select {
+	case <-committed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("command was not committed")
+	}
+	consumer.Stop()
+}
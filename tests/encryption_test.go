@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
+)
+
+var (
+	testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	testRotatedKey    = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func newTestKeys(t *testing.T, currentID string, keys map[string][]byte) *encryption.LocalKeyProvider {
+	provider, err := encryption.NewLocalKeyProvider(currentID, keys)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return provider
+}
+
+func newTestCipher(t *testing.T) *encryption.Cipher {
+	return encryption.New(newTestKeys(t, "test-key", map[string][]byte{"test-key": testEncryptionKey}))
+}
+
+func TestCipher_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cipher := newTestCipher(t)
+
+	ciphertext, err := cipher.Encrypt(ctx, []byte(`{"amount":"10.00"}`))
+	assert.NoError(t, err)
+
+	keyID, err := encryption.KeyID(ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-key", keyID)
+
+	plaintext, err := cipher.Decrypt(ctx, ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"amount":"10.00"}`, string(plaintext))
+
+	again, err := cipher.Encrypt(ctx, []byte(`{"amount":"10.00"}`))
+	assert.NoError(t, err)
+	assert.NotEqual(t, ciphertext, again, "data keys and nonces must differ")
+}
+
+func TestCipher_RejectsOtherKeysAndTampering(t *testing.T) {
+	ctx := context.Background()
+	cipher := newTestCipher(t)
+	ciphertext, err := cipher.Encrypt(ctx, []byte("secret"))
+	assert.NoError(t, err)
+
+	other := encryption.New(newTestKeys(t, "other-key", map[string][]byte{"other-key": testEncryptionKey}))
+	_, err = other.Decrypt(ctx, ciphertext)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+
+	raw, _ := base64.StdEncoding.DecodeString(ciphertext)
+	for _, i := range []int{len(raw) - 1, len("\x02\x08test-key") + 3} { // the data, the wrapped key
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 1
+		_, err = cipher.Decrypt(ctx, base64.StdEncoding.EncodeToString(tampered))
+		assert.ErrorIs(t, err, encryption.ErrMalformed)
+	}
+
+	_, err = cipher.Decrypt(ctx, "not base64!")
+	assert.ErrorIs(t, err, encryption.ErrMalformed)
+	_, err = cipher.Decrypt(ctx, "")
+	assert.ErrorIs(t, err, encryption.ErrMalformed)
+}
+
+func TestCipher_RotationKeepsOldKeysReadable(t *testing.T) {
+	ctx := context.Background()
+	before := newTestCipher(t)
+	old, err := before.Encrypt(ctx, []byte("old"))
+	assert.NoError(t, err)
+
+	after := encryption.New(newTestKeys(t, "rotated", map[string][]byte{
+		"test-key": testEncryptionKey,
+		"rotated":  testRotatedKey,
+	}))
+	assert.Equal(t, "rotated", after.KeyID())
+
+	plaintext, err := after.Decrypt(ctx, old)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(plaintext))
+
+	current, err := after.IsCurrent(old)
+	assert.NoError(t, err)
+	assert.False(t, current)
+
+	fresh, err := after.Encrypt(ctx, []byte("new"))
+	assert.NoError(t, err)
+	current, err = after.IsCurrent(fresh)
+	assert.NoError(t, err)
+	assert.True(t, current)
+
+	// once the old key is retired, what it sealed can't be read
+	retired := encryption.New(newTestKeys(t, "rotated", map[string][]byte{"rotated": testRotatedKey}))
+	_, err = retired.Decrypt(ctx, old)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+}
+
+func TestCipher_DecryptsMasterKeyFormat(t *testing.T) {
+	// format 1, sealed with the master key directly before envelope encryption
+	block, _ := aes.NewCipher(testEncryptionKey)
+	aead, _ := cipher.NewGCM(block)
+	header := append([]byte{1, byte(len("test-key"))}, "test-key"...)
+	nonce := make([]byte, aead.NonceSize())
+	raw := aead.Seal(append(append([]byte(nil), header...), nonce...), nonce, []byte("legacy"), header)
+	ciphertext := base64.StdEncoding.EncodeToString(raw)
+
+	c := newTestCipher(t)
+	plaintext, err := c.Decrypt(context.Background(), ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", string(plaintext))
+
+	current, err := c.IsCurrent(ciphertext)
+	assert.NoError(t, err)
+	assert.False(t, current, "format 1 must be re-encrypted")
+}
+
+func TestLocalKeyProvider_ValidatesKeys(t *testing.T) {
+	// the 22-byte key MaskData used to be given, which AES rejects
+	_, err := encryption.NewLocalKeyProvider("v1", map[string][]byte{"v1": []byte("super-puper-secret-key")})
+	assert.Error(t, err)
+
+	_, err = encryption.NewLocalKeyProvider("", map[string][]byte{"": testEncryptionKey})
+	assert.Error(t, err)
+
+	_, err = encryption.NewLocalKeyProvider("v2", map[string][]byte{"v1": testEncryptionKey})
+	assert.Error(t, err, "the current key must be in the set")
+
+	key, err := encryption.ParseKey(base64.StdEncoding.EncodeToString(testEncryptionKey))
+	assert.NoError(t, err)
+	assert.Equal(t, testEncryptionKey, key)
+
+	_, err = encryption.ParseKey("%%%")
+	assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := encryption.ParseKeys(" v1:" + base64.StdEncoding.EncodeToString(testEncryptionKey) +
+		", v2:" + base64.StdEncoding.EncodeToString(testRotatedKey) + ",")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"v1": testEncryptionKey, "v2": testRotatedKey}, keys)
+
+	keys, err = encryption.ParseKeys("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = encryption.ParseKeys("v1")
+	assert.Error(t, err)
+	_, err = encryption.ParseKeys("v1:%%%")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	file, _ := json.Marshal(map[string]any{
+		"current": "v2",
+		"keys": map[string]string{
+			"v1": base64.StdEncoding.EncodeToString(testEncryptionKey),
+			"v2": base64.StdEncoding.EncodeToString(testRotatedKey),
+		},
+	})
+	assert.NoError(t, os.WriteFile(path, file, 0o600))
+
+	provider, err := encryption.LoadKeyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", provider.CurrentKeyID())
+
+	// it reads what the v1 key alone sealed
+	ctx := context.Background()
+	old, err := encryption.New(newTestKeys(t, "v1", map[string][]byte{"v1": testEncryptionKey})).Encrypt(ctx, []byte("old"))
+	assert.NoError(t, err)
+	plaintext, err := encryption.New(provider).Decrypt(ctx, old)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(plaintext))
+
+	_, err = encryption.LoadKeyFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+// fakeTransit mimics the encrypt and decrypt endpoints of the Vault transit
+// engine for key "payments", without any actual encryption.
+func fakeTransit(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.test" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+
+		var request map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/payments":
+			data = map[string]string{"ciphertext": "vault:v1:" + request["plaintext"]}
+		case "/v1/transit/decrypt/payments":
+			data = map[string]string{"plaintext": strings.TrimPrefix(request["ciphertext"], "vault:v1:")}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+}
+
+func TestVaultKeyProvider_WrapsDataKeysWithTransit(t *testing.T) {
+	server := fakeTransit(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := encryption.NewVaultKeyProvider(server.URL+"/", "s.test", "payments", &http.Client{Timeout: time.Second})
+	assert.Equal(t, "vault:payments", provider.CurrentKeyID())
+
+	c := encryption.New(provider)
+	ciphertext, err := c.Encrypt(ctx, []byte("secret"))
+	assert.NoError(t, err)
+	keyID, _ := encryption.KeyID(ciphertext)
+	assert.Equal(t, "vault:payments", keyID)
+
+	plaintext, err := c.Decrypt(ctx, ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	// ciphertexts of local keys aren't Vault's to open
+	local, err := newTestCipher(t).Encrypt(ctx, []byte("secret"))
+	assert.NoError(t, err)
+	_, err = c.Decrypt(ctx, local)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+
+	denied := encryption.New(encryption.NewVaultKeyProvider(server.URL, "s.wrong", "payments", nil))
+	_, err = denied.Encrypt(ctx, []byte("secret"))
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestEnvelope_DecodeWithDecryptsData(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCipher(t)
+	ciphertext, err := c.Encrypt(ctx, []byte(`{"transaction_id":5,"amount":"1.50"}`))
+	assert.NoError(t, err)
+
+	envelope, err := events.NewEnvelope("evt_transaction.created_5", events.TypeTransactionCreated, time.Now(), "", ciphertext)
+	assert.NoError(t, err)
+	envelope.Encrypted = true
+
+	var data events.TransactionCreated
+	assert.NoError(t, envelope.DecodeWith(ctx, c, &data))
+	assert.Equal(t, 5, data.TransactionID)
+	assert.Equal(t, "1.50", data.Amount)
+
+	other := encryption.New(newTestKeys(t, "other-key", map[string][]byte{"other-key": testEncryptionKey}))
+	assert.ErrorIs(t, envelope.DecodeWith(ctx, other, &data), encryption.ErrUnknownKey)
+}
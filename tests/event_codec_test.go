@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"payment-gateway/internal/schema"
+	"payment-gateway/pkg/events"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	occurredAt := time.Date(2026, 3, 4, 5, 6, 7, 8, time.UTC)
+	created, err := events.NewEnvelope("evt_transaction.created_1", events.TypeTransactionCreated, occurredAt, "req-1",
+		events.TransactionCreated{TransactionID: 1, UserID: 2, Amount: "10.50", Currency: "USD", Type: "deposit", Status: "pending"})
+	assert.NoError(t, err)
+
+	encrypted, err := events.NewEnvelope("evt_transaction.created_2", events.TypeTransactionCreated, occurredAt, "", "Y2lwaGVydGV4dA==")
+	assert.NoError(t, err)
+	encrypted.Encrypted = true
+
+	for _, codec := range []events.Codec{events.JSONCodec{}, events.ProtobufCodec{}} {
+		for _, envelope := range []events.Envelope{created, encrypted} {
+			value, err := codec.Marshal(envelope)
+			if !assert.NoError(t, err, codec.Format()) {
+				continue
+			}
+
+			decoded, err := codec.Unmarshal(value)
+			assert.NoError(t, err, codec.Format())
+			assert.Equal(t, envelope.ID, decoded.ID)
+			assert.Equal(t, envelope.SchemaVersion, decoded.SchemaVersion)
+			assert.Equal(t, envelope.CorrelationID, decoded.CorrelationID)
+			assert.Equal(t, envelope.Encrypted, decoded.Encrypted)
+			assert.True(t, envelope.OccurredAt.Equal(decoded.OccurredAt), codec.Format())
+			assert.JSONEq(t, string(envelope.Data), string(decoded.Data), codec.Format())
+		}
+	}
+}
+
+func TestProtobufCodec_TimestampIsWellKnownType(t *testing.T) {
+	occurredAt := time.Date(2026, 3, 4, 5, 6, 7, 8, time.UTC)
+	envelope, err := events.NewEnvelope("evt_1", events.TypeStatusChanged, occurredAt, "",
+		events.StatusChanged{TransactionID: 1, Status: "pending"})
+	assert.NoError(t, err)
+
+	value, err := events.ProtobufCodec{}.Marshal(envelope)
+	assert.NoError(t, err)
+
+	// occurred_at is field 4 of the envelope
+	for len(value) > 0 {
+		number, wireType, n := protowire.ConsumeTag(value)
+		assert.Greater(t, n, 0)
+		value = value[n:]
+		if number != 4 {
+			value = value[protowire.ConsumeFieldValue(number, wireType, value):]
+			continue
+		}
+
+		raw, _ := protowire.ConsumeBytes(value)
+		var timestamp timestamppb.Timestamp
+		assert.NoError(t, proto.Unmarshal(raw, &timestamp))
+		assert.True(t, occurredAt.Equal(timestamp.AsTime()))
+		return
+	}
+	t.Fatal("occurred_at not encoded")
+}
+
+func TestProtobufCodec_RejectsUnknownData(t *testing.T) {
+	envelope, err := events.NewEnvelope("evt_1", events.TypeStatusChanged, time.Now(), "",
+		map[string]any{"transaction_id": 1, "surprise": true})
+	assert.NoError(t, err)
+
+	_, err = events.ProtobufCodec{}.Marshal(envelope)
+	assert.Error(t, err)
+}
+
+func TestSchemaRegistry_ServesSchemasByID(t *testing.T) {
+	registry, err := schema.Load(filepath.Join("..", "contract", "events"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/schemas/ids/6")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Subject    string `json:"subject"`
+		SchemaType string `json:"schemaType"`
+		Schema     string `json:"schema"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, events.TypeStatusChanged, body.Subject)
+	assert.Equal(t, "PROTOBUF", body.SchemaType)
+	assert.Contains(t, body.Schema, "message StatusChanged")
+
+	missing, err := http.Get(server.URL + "/schemas/ids/999")
+	if assert.NoError(t, err) {
+		missing.Body.Close()
+		assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+	}
+}
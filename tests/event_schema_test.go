@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-gateway/internal/schema"
+	"payment-gateway/pkg/events"
+)
+
+// eventDataTypes maps every published event type to its Go data type.
+var eventDataTypes = map[string]reflect.Type{
+	events.TypeTransactionCreated: reflect.TypeOf(events.TransactionCreated{}),
+	events.TypeStatusChanged:      reflect.TypeOf(events.StatusChanged{}),
+	events.TypeRequestPayout:      reflect.TypeOf(events.RequestPayout{}),
+}
+
+type jsonSchema struct {
+	Required   []string `json:"required"`
+	Properties map[string]struct {
+		Type string `json:"type"`
+	} `json:"properties"`
+}
+
+// TestEventSchemas_MatchContract fails when a Go event type drifts from its
+// contract/events schema: a removed, renamed or retyped field, or a change of
+// what is required. Such changes break consumers and need a new schema
+// version; additions need the contract updated.
+func TestEventSchemas_MatchContract(t *testing.T) {
+	checkSchema(t, "envelope.v1.json", reflect.TypeOf(events.Envelope{}))
+
+	for eventType, version := range events.SchemaVersions {
+		dataType, ok := eventDataTypes[eventType]
+		if !assert.True(t, ok, "no Go type registered in the test for %s", eventType) {
+			continue
+		}
+		checkSchema(t, fmt.Sprintf("%s.v%d.json", eventType, version), dataType)
+	}
+}
+
+func checkSchema(t *testing.T, file string, goType reflect.Type) {
+	raw, err := os.ReadFile(filepath.Join("..", "contract", "events", file))
+	if !assert.NoError(t, err, "missing contract for %s", goType.Name()) {
+		return
+	}
+
+	var schema jsonSchema
+	if !assert.NoError(t, json.Unmarshal(raw, &schema), file) {
+		return
+	}
+
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	fields := map[string]bool{}
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = true
+
+		property, ok := schema.Properties[name]
+		if !assert.True(t, ok, "%s: field %s is not in the contract", file, name) {
+			continue
+		}
+		if property.Type != "" {
+			assert.Equal(t, property.Type, jsonType(field.Type), "%s: type of %s", file, name)
+		}
+		assert.Equal(t, required[name], !strings.Contains(options, "omitempty"), "%s: %s required", file, name)
+	}
+
+	for name := range schema.Properties {
+		assert.True(t, fields[name], "%s: %s was removed or renamed", file, name)
+	}
+}
+
+// protoField matches the field lines of contract/events/*.proto.
+var protoField = regexp.MustCompile(`(?m)^\s*([\w.]+) (\w+) = (\d+);`)
+
+// TestProtoSchemas_MatchStructTags fails when the protobuf struct tags the
+// ProtobufCodec encodes by drift from the .proto contracts: every field must
+// keep its number and a compatible type.
+func TestProtoSchemas_MatchStructTags(t *testing.T) {
+	checkProto(t, "envelope.v1.proto", reflect.TypeOf(events.Envelope{}))
+
+	for eventType, version := range events.SchemaVersions {
+		if dataType, ok := eventDataTypes[eventType]; ok {
+			checkProto(t, fmt.Sprintf("%s.v%d.proto", eventType, version), dataType)
+		}
+	}
+}
+
+func checkProto(t *testing.T, file string, goType reflect.Type) {
+	raw, err := os.ReadFile(filepath.Join("..", "contract", "events", file))
+	if !assert.NoError(t, err, "missing contract for %s", goType.Name()) {
+		return
+	}
+
+	type protoDef struct {
+		protoType string
+		number    string
+	}
+	defs := map[string]protoDef{}
+	for _, match := range protoField.FindAllStringSubmatch(string(raw), -1) {
+		defs[match[2]] = protoDef{protoType: match[1], number: match[3]}
+	}
+
+	fields := map[string]bool{}
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		number := field.Tag.Get("protobuf")
+		if number == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		fields[name] = true
+
+		def, ok := defs[name]
+		if !assert.True(t, ok, "%s: field %s is not in the contract", file, name) {
+			continue
+		}
+		assert.Equal(t, def.number, number, "%s: number of %s", file, name)
+		assert.Contains(t, protoTypes(field.Type), def.protoType, "%s: type of %s", file, name)
+	}
+
+	for name := range defs {
+		assert.True(t, fields[name], "%s: %s was removed or renamed", file, name)
+	}
+}
+
+// protoTypes lists the .proto types a Go field can be declared as.
+func protoTypes(goType reflect.Type) []string {
+	switch {
+	case goType == reflect.TypeOf(time.Time{}):
+		return []string{"google.protobuf.Timestamp"}
+	case goType == reflect.TypeOf(json.RawMessage{}):
+		return []string{"bytes"}
+	case goType.Kind() == reflect.String:
+		return []string{"string"}
+	case goType.Kind() == reflect.Bool:
+		return []string{"bool"}
+	case goType.Kind() == reflect.Int || goType.Kind() == reflect.Int32 || goType.Kind() == reflect.Int64:
+		return []string{"int32", "int64"}
+	}
+	return nil
+}
+
+// TestSchemaRegistry_ListsEveryContract checks registry.json has both formats
+// of the current version of every event type and the envelope.
+func TestSchemaRegistry_ListsEveryContract(t *testing.T) {
+	registry, err := schema.Load(filepath.Join("..", "contract", "events"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, format := range []string{events.FormatJSON, events.FormatProtobuf} {
+		_, err := registry.Lookup("envelope", 1, format)
+		assert.NoError(t, err)
+		for eventType, version := range events.SchemaVersions {
+			registered, err := registry.Lookup(eventType, version, format)
+			if assert.NoError(t, err) {
+				assert.Contains(t, registered.File, fmt.Sprintf("%s.v%d.", eventType, version))
+			}
+		}
+	}
+}
+
+func jsonType(goType reflect.Type) string {
+	if goType == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+	if goType == reflect.TypeOf(json.RawMessage{}) {
+		return ""
+	}
+
+	switch goType.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func TestEnvelope_DecodeRefusesEncryptedData(t *testing.T) {
+	envelope, err := events.NewEnvelope("evt_1", events.TypeTransactionCreated, time.Now(), "", "ciphertext")
+	assert.NoError(t, err)
+	envelope.Encrypted = true
+
+	var data events.TransactionCreated
+	assert.ErrorIs(t, envelope.Decode(&data), events.ErrEncrypted)
+
+	_, err = events.NewEnvelope("evt_2", "transaction.exploded", time.Now(), "", nil)
+	assert.Error(t, err)
+}
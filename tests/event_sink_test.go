@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/db"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/internal/sink"
+)
+
+func TestNewSink_SelectedByConfig(t *testing.T) {
+	cfg := &envs.Config{}
+
+	for name, want := range map[string]any{
+		"nats":   &sink.NATSSink{},
+		"file":   &sink.FileSink{},
+		"memory": &sink.MemorySink{},
+	} {
+		cfg.Events.Sink = name
+		cfg.Events.FilePath = filepath.Join(t.TempDir(), "events.jsonl")
+
+		eventSink, err := sink.New(cfg)
+		assert.NoError(t, err, name)
+		assert.IsType(t, want, eventSink, name)
+		assert.NoError(t, eventSink.Close())
+	}
+
+	cfg.Events.Sink = "carrier-pigeon"
+	_, err := sink.New(cfg)
+	assert.Error(t, err)
+}
+
+func TestFileSink_WritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	fileSink, err := sink.NewFileSink(path)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, fileSink.PublishMessage(ctx, "payment-transactions", []byte("7"), []byte(`{"id":"evt_1"}`),
+		map[string]string{"event-type": "transaction.created"}))
+	assert.NoError(t, fileSink.PublishMessage(ctx, "payment-transactions", nil, []byte{0x0a, 0x01, 0xff}, nil))
+	assert.NoError(t, fileSink.Close())
+
+	file, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(file)), "\n")
+	assert.Len(t, lines, 2)
+
+	var record sink.FileRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "payment-transactions", record.Topic)
+	assert.Equal(t, "7", record.Key)
+	assert.Equal(t, "transaction.created", record.Headers["event-type"])
+	assert.JSONEq(t, `{"id":"evt_1"}`, string(record.Value))
+
+	// protobuf isn't JSON, it is kept as base64
+	record = sink.FileRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Empty(t, record.Value)
+	assert.Equal(t, []byte{0x0a, 0x01, 0xff}, record.ValueBase64)
+}
+
+func TestMemorySink_FansOut(t *testing.T) {
+	memory := sink.NewMemorySink()
+	first, _ := memory.Subscribe(1)
+	second, unsubscribe := memory.Subscribe(1)
+
+	ctx := context.Background()
+	assert.NoError(t, memory.PublishMessage(ctx, "payment-transactions", []byte("1"), []byte("a"), nil))
+	assert.Equal(t, "a", string((<-first).Value))
+	assert.Equal(t, "a", string((<-second).Value))
+
+	// an unsubscribed channel is closed, a full one misses messages
+	unsubscribe()
+	_, open := <-second
+	assert.False(t, open)
+
+	assert.NoError(t, memory.PublishMessage(ctx, "payment-transactions", nil, []byte("b"), nil))
+	assert.NoError(t, memory.PublishMessage(ctx, "payment-transactions", nil, []byte("c"), nil))
+	assert.Equal(t, uint64(1), memory.Dropped())
+	assert.Equal(t, "b", string((<-first).Value))
+
+	assert.NoError(t, memory.Close())
+	_, open = <-first
+	assert.False(t, open)
+}
+
+func TestOutboxRelay_PublishesToMemorySink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockOutboxStorage(ctrl)
+	mockStore.EXPECT().RelayOutbox(gomock.Any(), 10, 5, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ int, publish func(db.OutboxMessage) error) (int, []db.OutboxMessage, error) {
+			assert.NoError(t, publish(db.OutboxMessage{ID: 1, Topic: "payment-transactions", Key: "7", Payload: []byte("a")}))
+			return 1, nil, nil
+		})
+
+	memory := sink.NewMemorySink()
+	messages, _ := memory.Subscribe(10)
+
+	outbox.NewRelay(mockStore, memory, time.Second, 10, 5, time.Second).Poll(context.Background())
+
+	message := <-messages
+	assert.Equal(t, "payment-transactions", message.Topic)
+	assert.Equal(t, []byte("7"), message.Key)
+	assert.Equal(t, []byte("a"), message.Value)
+}
+
+// fakeNATS accepts clients on a local port and hands the subject, headers
+// and payload of each publish to published. Subjects starting with "denied."
+// get an -ERR, as a server with permissions would answer.
+func fakeNATS(t *testing.T, published chan<- []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeNATS(t, conn, published)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveFakeNATS(t *testing.T, conn net.Conn, published chan<- []string) {
+	defer conn.Close()
+
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"headers\":true,\"max_payload\":1024,\"proto\":1}\r\n")
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "CONNECT":
+			var options map[string]any
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "CONNECT ")), &options))
+			assert.Equal(t, "gateway", options["user"])
+			assert.Equal(t, "secret", options["pass"])
+		case fields[0] == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case fields[0] == "PUB" && len(fields) == 3, fields[0] == "HPUB" && len(fields) == 4:
+			// messages without headers are sent as PUB
+			headerLen := 0
+			if fields[0] == "HPUB" {
+				headerLen, _ = strconv.Atoi(fields[2])
+			}
+			totalLen, _ := strconv.Atoi(fields[len(fields)-1])
+			frame := make([]byte, totalLen+2)
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				return
+			}
+			if strings.HasPrefix(fields[1], "denied.") {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish to \""+fields[1]+"\"'\r\n")
+				continue
+			}
+			published <- []string{fields[1], string(frame[:headerLen]), string(frame[headerLen:totalLen])}
+		}
+	}
+}
+
+func TestNATSSink_PublishesWithHeaders(t *testing.T) {
+	published := make(chan []string, 2)
+	addr := fakeNATS(t, published)
+
+	natsSink := sink.NewNATSSink("nats://gateway:secret@"+addr, nil, time.Second)
+	defer natsSink.Close()
+
+	ctx := context.Background()
+	err := natsSink.PublishMessage(ctx, "payment-transactions", []byte("7"), []byte(`{"id":"evt_1"}`),
+		map[string]string{"event-type": "transaction.created", "error": "line\r\nbreak"})
+	assert.NoError(t, err)
+
+	message := <-published
+	assert.Equal(t, "payment-transactions", message[0])
+	assert.True(t, strings.HasPrefix(message[1], "NATS/1.0\r\n"))
+	assert.Contains(t, message[1], "message-key: 7\r\n")
+	assert.Contains(t, message[1], "event-type: transaction.created\r\n")
+	assert.Contains(t, message[1], "error: line  break\r\n")
+	assert.True(t, strings.HasSuffix(message[1], "\r\n\r\n"))
+	assert.Equal(t, `{"id":"evt_1"}`, message[2])
+
+	// the server drops it, the relay must not take it as sent
+	err = natsSink.PublishMessage(ctx, "denied.topic", nil, []byte("x"), nil)
+	assert.ErrorContains(t, err, "Permissions Violation")
+
+	// later publishes aren't failed by the earlier denial
+	assert.NoError(t, natsSink.PublishMessage(ctx, "payment-transactions", nil, []byte("y"), nil))
+	assert.Equal(t, "y", (<-published)[2])
+}
+
+func TestNATSSink_RejectsInvalidSubjects(t *testing.T) {
+	// no server, the subject is checked first
+	natsSink := sink.NewNATSSink("nats://127.0.0.1:1", nil, time.Second)
+
+	for _, subject := range []string{"", "payments..created", ".payments", "payments.", "payments.*", ">", "payment events"} {
+		err := natsSink.PublishMessage(context.Background(), subject, nil, []byte("x"), nil)
+		assert.ErrorIs(t, err, sink.ErrInvalidSubject, subject)
+	}
+}
+
+func TestNATSSink_FailsWithoutServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	natsSink := sink.NewNATSSink("nats://"+addr, nil, 100*time.Millisecond)
+	err = natsSink.PublishMessage(context.Background(), "payment-transactions", nil, []byte("x"), nil)
+	assert.ErrorContains(t, err, "failed to connect to nats")
+}
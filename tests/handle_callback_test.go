@@ -14,6 +14,8 @@ import (
 	"go.uber.org/mock/gomock"
 
 	"payment-gateway/db"
+	"payment-gateway/internal/lock"
+	"payment-gateway/internal/models"
 	"payment-gateway/internal/services"
 )
 
@@ -24,7 +26,8 @@ func TestHandleCallback_Success(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -32,24 +35,29 @@ func TestHandleCallback_Success(t *testing.T) {
 	status := "success"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "completed", gatewayTxnID, "").Return(nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "completed", GatewayTxnID: gatewayTxnID}, int64(1)).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.NoError(t, err)
 }
@@ -61,19 +69,23 @@ func TestHandleCallback_TransactionNotFound(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 999
 	gatewayTxnID := "gateway-txn-1"
 	status := "success"
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(db.Transaction{}, assert.AnError)
 
 	cfg := &envs.Config{}
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: 1, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.Error(t, err)
 }
@@ -85,7 +97,8 @@ func TestHandleCallback_FailedStatus(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -93,24 +106,29 @@ func TestHandleCallback_FailedStatus(t *testing.T) {
 	status := "failed"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "failed", gatewayTxnID, "").Return(nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "failed", GatewayTxnID: gatewayTxnID, ReasonCode: "gateway_failed"}, int64(1)).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.NoError(t, err)
 }
@@ -122,7 +140,8 @@ func TestHandleCallback_AlreadyInFinalState(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -130,27 +149,31 @@ func TestHandleCallback_AlreadyInFinalState(t *testing.T) {
 	status := "success"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "completed", // Already in final state
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "completed", // Already in final state
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	// No update should be called
+	expectStatusMappings(mockDB)
+	// A repeated "success" is a no-op, no update should be called
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "already in final state")
+	assert.NoError(t, err)
 }
 
 func TestHandleCallback_UpdateTransactionError(t *testing.T) {
@@ -160,7 +183,8 @@ func TestHandleCallback_UpdateTransactionError(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -168,25 +192,30 @@ func TestHandleCallback_UpdateTransactionError(t *testing.T) {
 	status := "success"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "completed", gatewayTxnID, "").
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "completed", GatewayTxnID: gatewayTxnID}, int64(1)).
 		Return(fmt.Errorf("database error"))
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to update transaction status")
@@ -199,7 +228,8 @@ func TestHandleCallback_WithFallbackGateway(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -207,24 +237,29 @@ func TestHandleCallback_WithFallbackGateway(t *testing.T) {
 	status := "success"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 2, // This is a fallback gateway ID (not the original one)
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    2, // This is a fallback gateway ID (not the original one)
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "completed", gatewayTxnID, "").Return(nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "completed", GatewayTxnID: gatewayTxnID}, int64(1)).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.NoError(t, err)
 }
@@ -236,7 +271,8 @@ func TestHandleCallback_WithCustomStatus(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -244,24 +280,29 @@ func TestHandleCallback_WithCustomStatus(t *testing.T) {
 	status := "approved" // Different status string that should map to "completed"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "completed", gatewayTxnID, "").Return(nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "completed", GatewayTxnID: gatewayTxnID}, int64(1)).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
 
 	assert.NoError(t, err)
 }
@@ -273,7 +314,8 @@ func TestHandleCallback_WithRejectedStatus(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -281,24 +323,154 @@ func TestHandleCallback_WithRejectedStatus(t *testing.T) {
 	status := "rejected" // Different status string that should map to "failed"
 	now := time.Now()
 	tx := db.Transaction{
-		ID:        txID,
-		UserID:    1,
-		Amount:    decimal.NewFromFloat(100.0),
-		Currency:  "USD",
-		Type:      "deposit",
-		Status:    "processing",
-		GatewayID: 1,
-		CreatedAt: now.Add(-time.Hour),
-		UpdatedAt: now.Add(-time.Hour),
+		ID:           txID,
+		UserID:       1,
+		Amount:       decimal.NewFromFloat(100.0),
+		Currency:     "USD",
+		Type:         "deposit",
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+		CreatedAt:    now.Add(-time.Hour),
+		UpdatedAt:    now.Add(-time.Hour),
+	}
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "failed", GatewayTxnID: gatewayTxnID, ReasonCode: "rejected"}, int64(1)).Return(nil)
+
+	cfg := envs.Load()
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
+
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: tx.GatewayID, GatewayTxnID: gatewayTxnID, Status: status})
+
+	assert.NoError(t, err)
+}
+
+func TestHandleCallback_LockNotAcquired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+
+	ctx := context.Background()
+	txID := 1
+	gatewayTxnID := "gateway-txn-1"
+	status := "success"
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(nil, lock.ErrNotAcquired)
+	// Neither read nor write should happen without the lock
+
+	cfg := envs.Load()
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
+
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: 1, GatewayTxnID: gatewayTxnID, Status: status})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to acquire transaction lock")
+}
+
+func TestHandleCallback_PersistsErrorMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	ctx := context.Background()
+	txID := 1
+	gatewayTxnID := "gateway-txn-1"
+	tx := db.Transaction{
+		ID:           txID,
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
 	}
 
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
-	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), txID, "failed", gatewayTxnID, "").Return(nil)
+	expectStatusMappings(mockDB)
+	mockDB.EXPECT().ApplyCallback(gomock.Any(), db.CallbackUpdate{TransactionID: txID, Status: "failed", GatewayTxnID: gatewayTxnID, ReasonCode: "gateway_failed", ErrorMessage: "Insufficient funds"}, int64(1)).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
-	err := service.HandleCallback(ctx, gatewayTxnID, status, txID)
+	err := service.HandleCallback(ctx, models.Callback{
+		TransactionID: txID,
+		GatewayID:     1,
+		GatewayTxnID:  gatewayTxnID,
+		Status:        "failed",
+		ErrorMessage:  "Insufficient funds",
+	})
 
 	assert.NoError(t, err)
 }
+
+// expectStatusMappings stubs gateway_status_mappings with the generic
+// statuses seeded in db/migrations/init.sql.
+func expectStatusMappings(mockDB *mocks.MockStorage) {
+	mappings := map[string]db.StatusMapping{
+		"success":  {InternalStatus: "completed"},
+		"approved": {InternalStatus: "completed"},
+		"pending":  {InternalStatus: "pending"},
+		"failed":   {InternalStatus: "failed", ReasonCode: "gateway_failed"},
+		"declined": {InternalStatus: "failed", ReasonCode: "declined"},
+		"rejected": {InternalStatus: "failed", ReasonCode: "rejected"},
+	}
+
+	mockDB.EXPECT().GetStatusMapping(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, gatewayID int, status string) (db.StatusMapping, error) {
+			mapping, ok := mappings[status]
+			if !ok {
+				return db.StatusMapping{}, db.ErrStatusMappingNotFound
+			}
+			mapping.GatewayID = gatewayID
+			mapping.GatewayStatus = status
+			return mapping, nil
+		}).AnyTimes()
+}
+
+func TestHandleCallback_UnmappedStatusRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+
+	ctx := context.Background()
+	txID := 1
+	gatewayTxnID := "gateway-txn-1"
+	tx := db.Transaction{
+		ID:           txID,
+		Status:       "processing",
+		GatewayID:    1,
+		GatewayTxnID: gatewayTxnID,
+	}
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), txID).Return(mockLease, nil)
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(tx, nil)
+	expectStatusMappings(mockDB)
+	// The transaction must not be left pending, no update should be called
+
+	cfg := envs.Load()
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
+
+	err := service.HandleCallback(ctx, models.Callback{TransactionID: txID, GatewayID: 1, GatewayTxnID: gatewayTxnID, Status: "on_hold"})
+
+	assert.ErrorIs(t, err, services.ErrUnmappedStatus)
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-gateway/configs/envs"
+	"payment-gateway/internal/kafka"
+)
+
+func TestNewBalancer(t *testing.T) {
+	for _, name := range []string{"", "hash", "murmur2", "crc32", "round_robin", "least_bytes"} {
+		balancer, err := kafka.NewBalancer(name)
+		assert.NoError(t, err, name)
+		assert.NotNil(t, balancer, name)
+	}
+
+	_, err := kafka.NewBalancer("random")
+	assert.Error(t, err)
+}
+
+func TestNewProducer_Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *envs.Config)
+		wantErr bool
+	}{
+		{name: "defaults", modify: func(cfg *envs.Config) {}},
+		{name: "cluster", modify: func(cfg *envs.Config) {
+			cfg.Kafka.Brokers = []string{"kafka-1:9093", "kafka-2:9093", "kafka-3:9093"}
+			cfg.Kafka.Compression = "zstd"
+			cfg.Kafka.TLS.Enabled = true
+			cfg.Kafka.SASL.Mechanism = "scram-sha-512"
+			cfg.Kafka.SASL.Username = "gateway"
+			cfg.Kafka.SASL.Password = "secret"
+		}},
+		{name: "acks one with retries", modify: func(cfg *envs.Config) {
+			cfg.Kafka.NoRetries = false
+			cfg.Kafka.RequiredAcks = "one"
+		}},
+		{name: "no retries needs acks all", wantErr: true, modify: func(cfg *envs.Config) {
+			cfg.Kafka.RequiredAcks = "one"
+		}},
+		{name: "unknown acks", wantErr: true, modify: func(cfg *envs.Config) {
+			cfg.Kafka.RequiredAcks = "most"
+		}},
+		{name: "unknown compression", wantErr: true, modify: func(cfg *envs.Config) {
+			cfg.Kafka.Compression = "brotli"
+		}},
+		{name: "unknown SASL mechanism", wantErr: true, modify: func(cfg *envs.Config) {
+			cfg.Kafka.SASL.Mechanism = "oauthbearer"
+		}},
+		{name: "missing CA file", wantErr: true, modify: func(cfg *envs.Config) {
+			cfg.Kafka.TLS.Enabled = true
+			cfg.Kafka.TLS.CAFile = "/nonexistent/ca.pem"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := envs.Load()
+			tt.modify(cfg)
+
+			producer, err := kafka.NewProducer(cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, producer.Close())
+		})
+	}
+}
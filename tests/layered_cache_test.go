@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/cache"
+)
+
+func TestLayeredCache_GetGatewaysByCountry_LocalHit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+	gateways := []db.Gateway{{ID: 1, Name: "Stripe"}, {ID: 2, Name: "PayPal"}}
+
+	mockRemote.EXPECT().GetGatewaysByCountry(gomock.Any(), mockDB, 1).Return(gateways, nil).Times(1)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		result, err := layered.GetGatewaysByCountry(ctx, mockDB, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, gateways, result)
+	}
+
+	stats := layered.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Loads)
+}
+
+func TestLayeredCache_GetGatewaysByCountry_NegativeCaching(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+
+	mockRemote.EXPECT().GetGatewaysByCountry(gomock.Any(), mockDB, 42).Return(nil, nil).Times(1)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		result, err := layered.GetGatewaysByCountry(ctx, mockDB, 42)
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+	}
+
+	assert.Equal(t, uint64(1), layered.Stats().NegativeHits)
+}
+
+func TestLayeredCache_GetGatewaysByCountry_SingleflightDeduplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+	gateways := []db.Gateway{{ID: 1, Name: "Stripe"}}
+	release := make(chan struct{})
+
+	mockRemote.EXPECT().GetGatewaysByCountry(gomock.Any(), mockDB, 1).DoAndReturn(
+		func(_ context.Context, _ db.Storage, _ int) ([]db.Gateway, error) {
+			<-release
+			return gateways, nil
+		}).Times(1)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := layered.GetGatewaysByCountry(ctx, mockDB, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, gateways, result)
+		}()
+	}
+
+	// give the goroutines a chance to pile up on the in-flight load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, uint64(1), layered.Stats().Loads)
+}
+
+func TestLayeredCache_Get_ExpiresLocalEntry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+
+	ctx := context.Background()
+
+	mockRemote.EXPECT().Get(gomock.Any(), "key").Return("value", nil).Times(2)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, 10*time.Millisecond, time.Minute)
+
+	val, err := layered.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	time.Sleep(20 * time.Millisecond)
+
+	val, err = layered.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", val)
+}
+
+func TestLayeredCache_GetRoute_UsesCachedUserAndGateways(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+	user := db.User{ID: 7, CountryID: 2}
+	gateways := []db.Gateway{{ID: 1, Name: "Stripe"}}
+
+	mockRemote.EXPECT().GetUser(gomock.Any(), mockDB, 7).Return(user, nil).Times(1)
+	mockRemote.EXPECT().GetGatewaysByCountry(gomock.Any(), mockDB, 2).Return(gateways, nil).Times(1)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		route, err := layered.GetRoute(ctx, mockDB, 7)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, route.UserID)
+		assert.Equal(t, 2, route.CountryID)
+		assert.Equal(t, gateways, route.Gateways)
+	}
+}
+
+func TestLayeredCache_Invalidate_DropsLocalEntry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+	user := db.User{ID: 7, CountryID: 2}
+
+	mockRemote.EXPECT().GetUser(gomock.Any(), mockDB, 7).Return(user, nil).Times(2)
+	mockRemote.EXPECT().Invalidate(gomock.Any(), cache.UserKey(7)).Return(nil)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	_, err := layered.GetUser(ctx, mockDB, 7)
+	assert.NoError(t, err)
+
+	err = layered.Invalidate(ctx, cache.UserKey(7))
+	assert.NoError(t, err)
+
+	_, err = layered.GetUser(ctx, mockDB, 7)
+	assert.NoError(t, err)
+}
+
+func TestLayeredCache_AccessorsShareKeysSafely(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx := context.Background()
+	user := db.User{ID: 7, CountryID: 2}
+
+	mockRemote.EXPECT().Get(gomock.Any(), cache.UserKey(7)).Return(`{"id":7}`, nil)
+	mockRemote.EXPECT().GetUser(gomock.Any(), mockDB, 7).Return(user, nil)
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+
+	raw, err := layered.Get(ctx, cache.UserKey(7))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":7}`, raw)
+
+	// the raw string cached under the key must not come back as a user
+	loaded, err := layered.GetUser(ctx, mockDB, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, user, loaded)
+
+	raw, err = layered.Get(ctx, cache.UserKey(7))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":7}`, raw)
+}
+
+func TestLayeredCache_LoadOutlivesCancelledCaller(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRemote := mocks.NewMockCache(ctrl)
+	mockDB := mocks.NewMockStorage(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the load is shared with other waiters, it runs without the caller's cancellation
+	mockRemote.EXPECT().GetUser(gomock.Any(), mockDB, 7).DoAndReturn(
+		func(ctx context.Context, _ db.Storage, _ int) (db.User, error) {
+			assert.NoError(t, ctx.Err())
+			return db.User{ID: 7}, nil
+		})
+
+	layered := cache.NewLayeredCache(mockRemote, 10, time.Minute, time.Minute)
+	_, err := layered.GetUser(ctx, mockDB, 7)
+	assert.NoError(t, err)
+}
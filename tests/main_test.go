@@ -0,0 +1,15 @@
+package tests
+
+import (
+	"os"
+	"testing"
+
+	"payment-gateway/configs/logger"
+)
+
+func TestMain(m *testing.M) {
+	// code under test logs through the global logger
+	logger.Init("error")
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,131 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/api_keys.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/api_keys.go -destination=tests/mocks/mock_api_key_storage.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockAPIKeyStorage is a mock of APIKeyStorage interface.
+type MockAPIKeyStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockAPIKeyStorageMockRecorder
+}
+
+// MockAPIKeyStorageMockRecorder is the mock recorder for MockAPIKeyStorage.
+type MockAPIKeyStorageMockRecorder struct {
+	mock *MockAPIKeyStorage
+}
+
+// NewMockAPIKeyStorage creates a new mock instance.
+func NewMockAPIKeyStorage(ctrl *gomock.Controller) *MockAPIKeyStorage {
+	mock := &MockAPIKeyStorage{ctrl: ctrl}
+	mock.recorder = &MockAPIKeyStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAPIKeyStorage) EXPECT() *MockAPIKeyStorageMockRecorder {
+	return m.recorder
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockAPIKeyStorage) CreateAPIKey(ctx context.Context, key db.APIKey) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, key)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockAPIKeyStorageMockRecorder) CreateAPIKey(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockAPIKeyStorage)(nil).CreateAPIKey), ctx, key)
+}
+
+// GetAPIKeyByHash mocks base method.
+func (m *MockAPIKeyStorage) GetAPIKeyByHash(ctx context.Context, keyHash string) (db.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", ctx, keyHash)
+	ret0, _ := ret[0].(db.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockAPIKeyStorageMockRecorder) GetAPIKeyByHash(ctx, keyHash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockAPIKeyStorage)(nil).GetAPIKeyByHash), ctx, keyHash)
+}
+
+// GetAPIKeyByID mocks base method.
+func (m *MockAPIKeyStorage) GetAPIKeyByID(ctx context.Context, id int) (db.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByID", ctx, id)
+	ret0, _ := ret[0].(db.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByID indicates an expected call of GetAPIKeyByID.
+func (mr *MockAPIKeyStorageMockRecorder) GetAPIKeyByID(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByID", reflect.TypeOf((*MockAPIKeyStorage)(nil).GetAPIKeyByID), ctx, id)
+}
+
+// RevokeAPIKey mocks base method.
+func (m *MockAPIKeyStorage) RevokeAPIKey(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAPIKey", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAPIKey indicates an expected call of RevokeAPIKey.
+func (mr *MockAPIKeyStorageMockRecorder) RevokeAPIKey(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAPIKey", reflect.TypeOf((*MockAPIKeyStorage)(nil).RevokeAPIKey), ctx, id)
+}
+
+// RotateAPIKey mocks base method.
+func (m *MockAPIKeyStorage) RotateAPIKey(ctx context.Context, oldID int, newKey db.APIKey, oldExpiresAt time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateAPIKey", ctx, oldID, newKey, oldExpiresAt)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateAPIKey indicates an expected call of RotateAPIKey.
+func (mr *MockAPIKeyStorageMockRecorder) RotateAPIKey(ctx, oldID, newKey, oldExpiresAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateAPIKey", reflect.TypeOf((*MockAPIKeyStorage)(nil).RotateAPIKey), ctx, oldID, newKey, oldExpiresAt)
+}
+
+// TouchAPIKey mocks base method.
+func (m *MockAPIKeyStorage) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchAPIKey", ctx, id, usedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchAPIKey indicates an expected call of TouchAPIKey.
+func (mr *MockAPIKeyStorageMockRecorder) TouchAPIKey(ctx, id, usedAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchAPIKey", reflect.TypeOf((*MockAPIKeyStorage)(nil).TouchAPIKey), ctx, id, usedAt)
+}
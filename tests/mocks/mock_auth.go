@@ -0,0 +1,143 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/auth/auth.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/auth/auth.go -destination=tests/mocks/mock_auth.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/auth"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockAuthenticator is a mock of Authenticator interface.
+type MockAuthenticator struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuthenticatorMockRecorder
+}
+
+// MockAuthenticatorMockRecorder is the mock recorder for MockAuthenticator.
+type MockAuthenticatorMockRecorder struct {
+	mock *MockAuthenticator
+}
+
+// NewMockAuthenticator creates a new mock instance.
+func NewMockAuthenticator(ctrl *gomock.Controller) *MockAuthenticator {
+	mock := &MockAuthenticator{ctrl: ctrl}
+	mock.recorder = &MockAuthenticatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuthenticator) EXPECT() *MockAuthenticatorMockRecorder {
+	return m.recorder
+}
+
+// Authenticate mocks base method.
+func (m *MockAuthenticator) Authenticate(ctx context.Context, rawKey string) (auth.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Authenticate", ctx, rawKey)
+	ret0, _ := ret[0].(auth.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Authenticate indicates an expected call of Authenticate.
+func (mr *MockAuthenticatorMockRecorder) Authenticate(ctx, rawKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authenticate", reflect.TypeOf((*MockAuthenticator)(nil).Authenticate), ctx, rawKey)
+}
+
+// MockKeyManager is a mock of KeyManager interface.
+type MockKeyManager struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyManagerMockRecorder
+}
+
+// MockKeyManagerMockRecorder is the mock recorder for MockKeyManager.
+type MockKeyManagerMockRecorder struct {
+	mock *MockKeyManager
+}
+
+// NewMockKeyManager creates a new mock instance.
+func NewMockKeyManager(ctrl *gomock.Controller) *MockKeyManager {
+	mock := &MockKeyManager{ctrl: ctrl}
+	mock.recorder = &MockKeyManagerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeyManager) EXPECT() *MockKeyManagerMockRecorder {
+	return m.recorder
+}
+
+// Authenticate mocks base method.
+func (m *MockKeyManager) Authenticate(ctx context.Context, rawKey string) (auth.Identity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Authenticate", ctx, rawKey)
+	ret0, _ := ret[0].(auth.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Authenticate indicates an expected call of Authenticate.
+func (mr *MockKeyManagerMockRecorder) Authenticate(ctx, rawKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authenticate", reflect.TypeOf((*MockKeyManager)(nil).Authenticate), ctx, rawKey)
+}
+
+// CreateKey mocks base method.
+func (m *MockKeyManager) CreateKey(ctx context.Context, name string, scopes []string, userID *int, expiresAt *time.Time) (string, db.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateKey", ctx, name, scopes, userID, expiresAt)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(db.APIKey)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateKey indicates an expected call of CreateKey.
+func (mr *MockKeyManagerMockRecorder) CreateKey(ctx, name, scopes, userID, expiresAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateKey", reflect.TypeOf((*MockKeyManager)(nil).CreateKey), ctx, name, scopes, userID, expiresAt)
+}
+
+// RevokeKey mocks base method.
+func (m *MockKeyManager) RevokeKey(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeKey", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeKey indicates an expected call of RevokeKey.
+func (mr *MockKeyManagerMockRecorder) RevokeKey(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeKey", reflect.TypeOf((*MockKeyManager)(nil).RevokeKey), ctx, id)
+}
+
+// RotateKey mocks base method.
+func (m *MockKeyManager) RotateKey(ctx context.Context, id int, gracePeriod time.Duration) (string, db.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateKey", ctx, id, gracePeriod)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(db.APIKey)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// RotateKey indicates an expected call of RotateKey.
+func (mr *MockKeyManagerMockRecorder) RotateKey(ctx, id, gracePeriod any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateKey", reflect.TypeOf((*MockKeyManager)(nil).RotateKey), ctx, id, gracePeriod)
+}
@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -source=internal/cache/redis.go -destination=internal/tests/mocks/mock_cache.go -package=mocks
+//	mockgen -source=internal/cache/redis.go -destination=tests/mocks/mock_cache.go -package=mocks
 //
 
 // Package mocks is a generated GoMock package.
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"payment-gateway/db"
+	"payment-gateway/internal/cache"
 
 	"go.uber.org/mock/gomock"
 )
@@ -72,6 +73,55 @@ func (mr *MockCacheMockRecorder) GetGatewaysByCountry(ctx, dbHandler, countryID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGatewaysByCountry", reflect.TypeOf((*MockCache)(nil).GetGatewaysByCountry), ctx, dbHandler, countryID)
 }
 
+// GetRoute mocks base method.
+func (m *MockCache) GetRoute(ctx context.Context, dbHandler db.Storage, userID int) (cache.Route, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRoute", ctx, dbHandler, userID)
+	ret0, _ := ret[0].(cache.Route)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRoute indicates an expected call of GetRoute.
+func (mr *MockCacheMockRecorder) GetRoute(ctx, dbHandler, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRoute", reflect.TypeOf((*MockCache)(nil).GetRoute), ctx, dbHandler, userID)
+}
+
+// GetUser mocks base method.
+func (m *MockCache) GetUser(ctx context.Context, dbHandler db.Storage, userID int) (db.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", ctx, dbHandler, userID)
+	ret0, _ := ret[0].(db.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockCacheMockRecorder) GetUser(ctx, dbHandler, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockCache)(nil).GetUser), ctx, dbHandler, userID)
+}
+
+// Invalidate mocks base method.
+func (m *MockCache) Invalidate(ctx context.Context, keys ...string) error {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx}
+	for _, a := range keys {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Invalidate", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Invalidate indicates an expected call of Invalidate.
+func (mr *MockCacheMockRecorder) Invalidate(ctx any, keys ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx}, keys...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Invalidate", reflect.TypeOf((*MockCache)(nil).Invalidate), varargs...)
+}
+
 // Set mocks base method.
 func (m *MockCache) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
 	m.ctrl.T.Helper()
@@ -85,3 +135,38 @@ func (mr *MockCacheMockRecorder) Set(ctx, key, value, expiration any) *gomock.Ca
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockCache)(nil).Set), ctx, key, value, expiration)
 }
+
+// MockInvalidationSource is a mock of InvalidationSource interface.
+type MockInvalidationSource struct {
+	ctrl     *gomock.Controller
+	recorder *MockInvalidationSourceMockRecorder
+}
+
+// MockInvalidationSourceMockRecorder is the mock recorder for MockInvalidationSource.
+type MockInvalidationSourceMockRecorder struct {
+	mock *MockInvalidationSource
+}
+
+// NewMockInvalidationSource creates a new mock instance.
+func NewMockInvalidationSource(ctrl *gomock.Controller) *MockInvalidationSource {
+	mock := &MockInvalidationSource{ctrl: ctrl}
+	mock.recorder = &MockInvalidationSourceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockInvalidationSource) EXPECT() *MockInvalidationSourceMockRecorder {
+	return m.recorder
+}
+
+// SubscribeInvalidations mocks base method.
+func (m *MockInvalidationSource) SubscribeInvalidations(ctx context.Context, handler func(string)) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SubscribeInvalidations", ctx, handler)
+}
+
+// SubscribeInvalidations indicates an expected call of SubscribeInvalidations.
+func (mr *MockInvalidationSourceMockRecorder) SubscribeInvalidations(ctx, handler any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeInvalidations", reflect.TypeOf((*MockInvalidationSource)(nil).SubscribeInvalidations), ctx, handler)
+}
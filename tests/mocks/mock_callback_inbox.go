@@ -0,0 +1,131 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/callback_inbox.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/callback_inbox.go -destination=tests/mocks/mock_callback_inbox.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockCallbackInboxStorage is a mock of CallbackInboxStorage interface.
+type MockCallbackInboxStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockCallbackInboxStorageMockRecorder
+}
+
+// MockCallbackInboxStorageMockRecorder is the mock recorder for MockCallbackInboxStorage.
+type MockCallbackInboxStorageMockRecorder struct {
+	mock *MockCallbackInboxStorage
+}
+
+// NewMockCallbackInboxStorage creates a new mock instance.
+func NewMockCallbackInboxStorage(ctrl *gomock.Controller) *MockCallbackInboxStorage {
+	mock := &MockCallbackInboxStorage{ctrl: ctrl}
+	mock.recorder = &MockCallbackInboxStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCallbackInboxStorage) EXPECT() *MockCallbackInboxStorageMockRecorder {
+	return m.recorder
+}
+
+// ClaimCallbacks mocks base method.
+func (m *MockCallbackInboxStorage) ClaimCallbacks(ctx context.Context, limit int, visibility time.Duration) ([]db.InboxCallback, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimCallbacks", ctx, limit, visibility)
+	ret0, _ := ret[0].([]db.InboxCallback)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimCallbacks indicates an expected call of ClaimCallbacks.
+func (mr *MockCallbackInboxStorageMockRecorder) ClaimCallbacks(ctx, limit, visibility any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimCallbacks", reflect.TypeOf((*MockCallbackInboxStorage)(nil).ClaimCallbacks), ctx, limit, visibility)
+}
+
+// EnqueueCallback mocks base method.
+func (m *MockCallbackInboxStorage) EnqueueCallback(ctx context.Context, cb db.InboxCallback) (int, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueCallback", ctx, cb)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// EnqueueCallback indicates an expected call of EnqueueCallback.
+func (mr *MockCallbackInboxStorageMockRecorder) EnqueueCallback(ctx, cb any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueCallback", reflect.TypeOf((*MockCallbackInboxStorage)(nil).EnqueueCallback), ctx, cb)
+}
+
+// ListCallbacks mocks base method.
+func (m *MockCallbackInboxStorage) ListCallbacks(ctx context.Context, status string, limit int) ([]db.InboxCallback, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCallbacks", ctx, status, limit)
+	ret0, _ := ret[0].([]db.InboxCallback)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCallbacks indicates an expected call of ListCallbacks.
+func (mr *MockCallbackInboxStorageMockRecorder) ListCallbacks(ctx, status, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCallbacks", reflect.TypeOf((*MockCallbackInboxStorage)(nil).ListCallbacks), ctx, status, limit)
+}
+
+// MarkCallbackFailed mocks base method.
+func (m *MockCallbackInboxStorage) MarkCallbackFailed(ctx context.Context, id int, errorMsg string, nextAttemptAt time.Time, dead bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkCallbackFailed", ctx, id, errorMsg, nextAttemptAt, dead)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkCallbackFailed indicates an expected call of MarkCallbackFailed.
+func (mr *MockCallbackInboxStorageMockRecorder) MarkCallbackFailed(ctx, id, errorMsg, nextAttemptAt, dead any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkCallbackFailed", reflect.TypeOf((*MockCallbackInboxStorage)(nil).MarkCallbackFailed), ctx, id, errorMsg, nextAttemptAt, dead)
+}
+
+// MarkCallbackProcessed mocks base method.
+func (m *MockCallbackInboxStorage) MarkCallbackProcessed(ctx context.Context, id int, note string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkCallbackProcessed", ctx, id, note)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkCallbackProcessed indicates an expected call of MarkCallbackProcessed.
+func (mr *MockCallbackInboxStorageMockRecorder) MarkCallbackProcessed(ctx, id, note any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkCallbackProcessed", reflect.TypeOf((*MockCallbackInboxStorage)(nil).MarkCallbackProcessed), ctx, id, note)
+}
+
+// RequeueCallback mocks base method.
+func (m *MockCallbackInboxStorage) RequeueCallback(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequeueCallback", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequeueCallback indicates an expected call of RequeueCallback.
+func (mr *MockCallbackInboxStorageMockRecorder) RequeueCallback(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequeueCallback", reflect.TypeOf((*MockCallbackInboxStorage)(nil).RequeueCallback), ctx, id)
+}
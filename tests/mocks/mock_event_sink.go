@@ -0,0 +1,68 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/sink/sink.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/sink/sink.go -destination=tests/mocks/mock_event_sink.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockEventSink is a mock of EventSink interface.
+type MockEventSink struct {
+	ctrl     *gomock.Controller
+	recorder *MockEventSinkMockRecorder
+}
+
+// MockEventSinkMockRecorder is the mock recorder for MockEventSink.
+type MockEventSinkMockRecorder struct {
+	mock *MockEventSink
+}
+
+// NewMockEventSink creates a new mock instance.
+func NewMockEventSink(ctrl *gomock.Controller) *MockEventSink {
+	mock := &MockEventSink{ctrl: ctrl}
+	mock.recorder = &MockEventSinkMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockEventSink) EXPECT() *MockEventSinkMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockEventSink) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockEventSinkMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockEventSink)(nil).Close))
+}
+
+// PublishMessage mocks base method.
+func (m *MockEventSink) PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PublishMessage", ctx, topic, key, message, headers)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PublishMessage indicates an expected call of PublishMessage.
+func (mr *MockEventSinkMockRecorder) PublishMessage(ctx, topic, key, message, headers any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishMessage", reflect.TypeOf((*MockEventSink)(nil).PublishMessage), ctx, topic, key, message, headers)
+}
@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -source=internal/services/gateway_service.go -destination=internal/tests/mocks/mock_gateway_service.go -package=mocks
+//	mockgen -source=internal/services/gateway_service.go -destination=tests/mocks/mock_gateway_service.go -package=mocks
 //
 
 // Package mocks is a generated GoMock package.
@@ -14,6 +14,7 @@ import (
 	"reflect"
 
 	"payment-gateway/db"
+	"payment-gateway/internal/models"
 
 	"go.uber.org/mock/gomock"
 )
@@ -57,17 +58,17 @@ func (mr *MockGatewayServiceInterfaceMockRecorder) GetTransactionStatus(ctx, txI
 }
 
 // HandleCallback mocks base method.
-func (m *MockGatewayServiceInterface) HandleCallback(ctx context.Context, gatewayTxnID, status string, transactionID int) error {
+func (m *MockGatewayServiceInterface) HandleCallback(ctx context.Context, callback models.Callback) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "HandleCallback", ctx, gatewayTxnID, status, transactionID)
+	ret := m.ctrl.Call(m, "HandleCallback", ctx, callback)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // HandleCallback indicates an expected call of HandleCallback.
-func (mr *MockGatewayServiceInterfaceMockRecorder) HandleCallback(ctx, gatewayTxnID, status, transactionID any) *gomock.Call {
+func (mr *MockGatewayServiceInterfaceMockRecorder) HandleCallback(ctx, callback any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleCallback", reflect.TypeOf((*MockGatewayServiceInterface)(nil).HandleCallback), ctx, gatewayTxnID, status, transactionID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleCallback", reflect.TypeOf((*MockGatewayServiceInterface)(nil).HandleCallback), ctx, callback)
 }
 
 // ProcessTransaction mocks base method.
@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -source=internal/kafka/producer.go -destination=internal/tests/mocks/mock_kafka_producer.go -package=mocks
+//	mockgen -source=internal/kafka/producer.go -destination=tests/mocks/mock_kafka_producer.go -package=mocks
 //
 
 // Package mocks is a generated GoMock package.
@@ -54,15 +54,15 @@ func (mr *MockProducerMockRecorder) Close() *gomock.Call {
 }
 
 // PublishMessage mocks base method.
-func (m *MockProducer) PublishMessage(ctx context.Context, topic string, message []byte) error {
+func (m *MockProducer) PublishMessage(ctx context.Context, topic string, key, message []byte, headers map[string]string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PublishMessage", ctx, topic, message)
+	ret := m.ctrl.Call(m, "PublishMessage", ctx, topic, key, message, headers)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // PublishMessage indicates an expected call of PublishMessage.
-func (mr *MockProducerMockRecorder) PublishMessage(ctx, topic, message any) *gomock.Call {
+func (mr *MockProducerMockRecorder) PublishMessage(ctx, topic, key, message, headers any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishMessage", reflect.TypeOf((*MockProducer)(nil).PublishMessage), ctx, topic, message)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishMessage", reflect.TypeOf((*MockProducer)(nil).PublishMessage), ctx, topic, key, message, headers)
 }
@@ -0,0 +1,108 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/lock/lock.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/lock/lock.go -destination=tests/mocks/mock_lock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+
+	"payment-gateway/internal/lock"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockLocker is a mock of Locker interface.
+type MockLocker struct {
+	ctrl     *gomock.Controller
+	recorder *MockLockerMockRecorder
+}
+
+// MockLockerMockRecorder is the mock recorder for MockLocker.
+type MockLockerMockRecorder struct {
+	mock *MockLocker
+}
+
+// NewMockLocker creates a new mock instance.
+func NewMockLocker(ctrl *gomock.Controller) *MockLocker {
+	mock := &MockLocker{ctrl: ctrl}
+	mock.recorder = &MockLockerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLocker) EXPECT() *MockLockerMockRecorder {
+	return m.recorder
+}
+
+// Acquire mocks base method.
+func (m *MockLocker) Acquire(ctx context.Context, txID int) (lock.Lease, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Acquire", ctx, txID)
+	ret0, _ := ret[0].(lock.Lease)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Acquire indicates an expected call of Acquire.
+func (mr *MockLockerMockRecorder) Acquire(ctx, txID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Acquire", reflect.TypeOf((*MockLocker)(nil).Acquire), ctx, txID)
+}
+
+// MockLease is a mock of Lease interface.
+type MockLease struct {
+	ctrl     *gomock.Controller
+	recorder *MockLeaseMockRecorder
+}
+
+// MockLeaseMockRecorder is the mock recorder for MockLease.
+type MockLeaseMockRecorder struct {
+	mock *MockLease
+}
+
+// NewMockLease creates a new mock instance.
+func NewMockLease(ctrl *gomock.Controller) *MockLease {
+	mock := &MockLease{ctrl: ctrl}
+	mock.recorder = &MockLeaseMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLease) EXPECT() *MockLeaseMockRecorder {
+	return m.recorder
+}
+
+// Release mocks base method.
+func (m *MockLease) Release(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Release indicates an expected call of Release.
+func (mr *MockLeaseMockRecorder) Release(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockLease)(nil).Release), ctx)
+}
+
+// Token mocks base method.
+func (m *MockLease) Token() int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Token")
+	ret0, _ := ret[0].(int64)
+	return ret0
+}
+
+// Token indicates an expected call of Token.
+func (mr *MockLeaseMockRecorder) Token() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Token", reflect.TypeOf((*MockLease)(nil).Token))
+}
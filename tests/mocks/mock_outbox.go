@@ -0,0 +1,87 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/outbox.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/outbox.go -destination=tests/mocks/mock_outbox.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockOutboxStorage is a mock of OutboxStorage interface.
+type MockOutboxStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockOutboxStorageMockRecorder
+}
+
+// MockOutboxStorageMockRecorder is the mock recorder for MockOutboxStorage.
+type MockOutboxStorageMockRecorder struct {
+	mock *MockOutboxStorage
+}
+
+// NewMockOutboxStorage creates a new mock instance.
+func NewMockOutboxStorage(ctrl *gomock.Controller) *MockOutboxStorage {
+	mock := &MockOutboxStorage{ctrl: ctrl}
+	mock.recorder = &MockOutboxStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOutboxStorage) EXPECT() *MockOutboxStorageMockRecorder {
+	return m.recorder
+}
+
+// ListOutbox mocks base method.
+func (m *MockOutboxStorage) ListOutbox(ctx context.Context, afterID int64, limit int) ([]db.OutboxMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListOutbox", ctx, afterID, limit)
+	ret0, _ := ret[0].([]db.OutboxMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListOutbox indicates an expected call of ListOutbox.
+func (mr *MockOutboxStorageMockRecorder) ListOutbox(ctx, afterID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListOutbox", reflect.TypeOf((*MockOutboxStorage)(nil).ListOutbox), ctx, afterID, limit)
+}
+
+// RelayOutbox mocks base method.
+func (m *MockOutboxStorage) RelayOutbox(ctx context.Context, limit, maxAttempts int, publish func(db.OutboxMessage) error) (int, []db.OutboxMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RelayOutbox", ctx, limit, maxAttempts, publish)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].([]db.OutboxMessage)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// RelayOutbox indicates an expected call of RelayOutbox.
+func (mr *MockOutboxStorageMockRecorder) RelayOutbox(ctx, limit, maxAttempts, publish any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RelayOutbox", reflect.TypeOf((*MockOutboxStorage)(nil).RelayOutbox), ctx, limit, maxAttempts, publish)
+}
+
+// RewriteOutboxMessage mocks base method.
+func (m *MockOutboxStorage) RewriteOutboxMessage(ctx context.Context, id int64, headers map[string]string, payload []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RewriteOutboxMessage", ctx, id, headers, payload)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RewriteOutboxMessage indicates an expected call of RewriteOutboxMessage.
+func (mr *MockOutboxStorageMockRecorder) RewriteOutboxMessage(ctx, id, headers, payload any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RewriteOutboxMessage", reflect.TypeOf((*MockOutboxStorage)(nil).RewriteOutboxMessage), ctx, id, headers, payload)
+}
@@ -0,0 +1,72 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/replay.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/replay.go -destination=tests/mocks/mock_replay.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockReplayStorage is a mock of ReplayStorage interface.
+type MockReplayStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockReplayStorageMockRecorder
+}
+
+// MockReplayStorageMockRecorder is the mock recorder for MockReplayStorage.
+type MockReplayStorageMockRecorder struct {
+	mock *MockReplayStorage
+}
+
+// NewMockReplayStorage creates a new mock instance.
+func NewMockReplayStorage(ctrl *gomock.Controller) *MockReplayStorage {
+	mock := &MockReplayStorage{ctrl: ctrl}
+	mock.recorder = &MockReplayStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReplayStorage) EXPECT() *MockReplayStorageMockRecorder {
+	return m.recorder
+}
+
+// ListReplayTransactions mocks base method.
+func (m *MockReplayStorage) ListReplayTransactions(ctx context.Context, filter db.ReplayFilter, afterID, limit int) ([]db.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListReplayTransactions", ctx, filter, afterID, limit)
+	ret0, _ := ret[0].([]db.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListReplayTransactions indicates an expected call of ListReplayTransactions.
+func (mr *MockReplayStorageMockRecorder) ListReplayTransactions(ctx, filter, afterID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListReplayTransactions", reflect.TypeOf((*MockReplayStorage)(nil).ListReplayTransactions), ctx, filter, afterID, limit)
+}
+
+// ListTransactionEvents mocks base method.
+func (m *MockReplayStorage) ListTransactionEvents(ctx context.Context, filter db.TransactionEventFilter) ([]db.TransactionEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTransactionEvents", ctx, filter)
+	ret0, _ := ret[0].([]db.TransactionEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTransactionEvents indicates an expected call of ListTransactionEvents.
+func (mr *MockReplayStorageMockRecorder) ListTransactionEvents(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTransactionEvents", reflect.TypeOf((*MockReplayStorage)(nil).ListTransactionEvents), ctx, filter)
+}
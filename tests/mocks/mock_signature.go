@@ -0,0 +1,179 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/signature/signature.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/signature/signature.go -destination=tests/mocks/mock_signature.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"payment-gateway/internal/signature"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockScheme is a mock of Scheme interface.
+type MockScheme struct {
+	ctrl     *gomock.Controller
+	recorder *MockSchemeMockRecorder
+}
+
+// MockSchemeMockRecorder is the mock recorder for MockScheme.
+type MockSchemeMockRecorder struct {
+	mock *MockScheme
+}
+
+// NewMockScheme creates a new mock instance.
+func NewMockScheme(ctrl *gomock.Controller) *MockScheme {
+	mock := &MockScheme{ctrl: ctrl}
+	mock.recorder = &MockSchemeMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockScheme) EXPECT() *MockSchemeMockRecorder {
+	return m.recorder
+}
+
+// Sign mocks base method.
+func (m *MockScheme) Sign(body []byte, secret string, timestamp time.Time, nonce string) (http.Header, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sign", body, secret, timestamp, nonce)
+	ret0, _ := ret[0].(http.Header)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Sign indicates an expected call of Sign.
+func (mr *MockSchemeMockRecorder) Sign(body, secret, timestamp, nonce any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sign", reflect.TypeOf((*MockScheme)(nil).Sign), body, secret, timestamp, nonce)
+}
+
+// Verify mocks base method.
+func (m *MockScheme) Verify(header http.Header, body []byte, secret string) (signature.Stamp, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", header, body, secret)
+	ret0, _ := ret[0].(signature.Stamp)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockSchemeMockRecorder) Verify(header, body, secret any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockScheme)(nil).Verify), header, body, secret)
+}
+
+// MockNonceStore is a mock of NonceStore interface.
+type MockNonceStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockNonceStoreMockRecorder
+}
+
+// MockNonceStoreMockRecorder is the mock recorder for MockNonceStore.
+type MockNonceStoreMockRecorder struct {
+	mock *MockNonceStore
+}
+
+// NewMockNonceStore creates a new mock instance.
+func NewMockNonceStore(ctrl *gomock.Controller) *MockNonceStore {
+	mock := &MockNonceStore{ctrl: ctrl}
+	mock.recorder = &MockNonceStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNonceStore) EXPECT() *MockNonceStoreMockRecorder {
+	return m.recorder
+}
+
+// Remember mocks base method.
+func (m *MockNonceStore) Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Remember", ctx, nonce, ttl)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Remember indicates an expected call of Remember.
+func (mr *MockNonceStoreMockRecorder) Remember(ctx, nonce, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remember", reflect.TypeOf((*MockNonceStore)(nil).Remember), ctx, nonce, ttl)
+}
+
+// Seen mocks base method.
+func (m *MockNonceStore) Seen(ctx context.Context, nonce string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Seen", ctx, nonce)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Seen indicates an expected call of Seen.
+func (mr *MockNonceStoreMockRecorder) Seen(ctx, nonce any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Seen", reflect.TypeOf((*MockNonceStore)(nil).Seen), ctx, nonce)
+}
+
+// MockCallbackVerifier is a mock of CallbackVerifier interface.
+type MockCallbackVerifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockCallbackVerifierMockRecorder
+}
+
+// MockCallbackVerifierMockRecorder is the mock recorder for MockCallbackVerifier.
+type MockCallbackVerifierMockRecorder struct {
+	mock *MockCallbackVerifier
+}
+
+// NewMockCallbackVerifier creates a new mock instance.
+func NewMockCallbackVerifier(ctrl *gomock.Controller) *MockCallbackVerifier {
+	mock := &MockCallbackVerifier{ctrl: ctrl}
+	mock.recorder = &MockCallbackVerifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCallbackVerifier) EXPECT() *MockCallbackVerifierMockRecorder {
+	return m.recorder
+}
+
+// MarkUsed mocks base method.
+func (m *MockCallbackVerifier) MarkUsed(ctx context.Context, gatewayName string, stamp signature.Stamp) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkUsed", ctx, gatewayName, stamp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkUsed indicates an expected call of MarkUsed.
+func (mr *MockCallbackVerifierMockRecorder) MarkUsed(ctx, gatewayName, stamp any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUsed", reflect.TypeOf((*MockCallbackVerifier)(nil).MarkUsed), ctx, gatewayName, stamp)
+}
+
+// Verify mocks base method.
+func (m *MockCallbackVerifier) Verify(ctx context.Context, gatewayName string, header http.Header, body []byte) (signature.Stamp, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", ctx, gatewayName, header, body)
+	ret0, _ := ret[0].(signature.Stamp)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockCallbackVerifierMockRecorder) Verify(ctx, gatewayName, header, body any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockCallbackVerifier)(nil).Verify), ctx, gatewayName, header, body)
+}
@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -source=db/db_helpers.go -destination=internal/tests/mocks/mock_storage.go -package=mocks
+//	mockgen -source=db/db_helpers.go -destination=tests/mocks/mock_storage.go -package=mocks
 //
 
 // Package mocks is a generated GoMock package.
@@ -41,19 +41,91 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// ApplyCallback mocks base method.
+func (m *MockStorage) ApplyCallback(ctx context.Context, update db.CallbackUpdate, fencingToken int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyCallback", ctx, update, fencingToken)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ApplyCallback indicates an expected call of ApplyCallback.
+func (mr *MockStorageMockRecorder) ApplyCallback(ctx, update, fencingToken any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyCallback", reflect.TypeOf((*MockStorage)(nil).ApplyCallback), ctx, update, fencingToken)
+}
+
+// CreateReconciliationAlert mocks base method.
+func (m *MockStorage) CreateReconciliationAlert(ctx context.Context, alert db.ReconciliationAlert) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateReconciliationAlert", ctx, alert)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateReconciliationAlert indicates an expected call of CreateReconciliationAlert.
+func (mr *MockStorageMockRecorder) CreateReconciliationAlert(ctx, alert any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateReconciliationAlert", reflect.TypeOf((*MockStorage)(nil).CreateReconciliationAlert), ctx, alert)
+}
+
 // CreateTransaction mocks base method.
-func (m *MockStorage) CreateTransaction(ctx context.Context, tx db.Transaction) (int, error) {
+func (m *MockStorage) CreateTransaction(ctx context.Context, tx db.Transaction, message db.OutboxMessageFunc) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateTransaction", ctx, tx)
+	ret := m.ctrl.Call(m, "CreateTransaction", ctx, tx, message)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateTransaction indicates an expected call of CreateTransaction.
-func (mr *MockStorageMockRecorder) CreateTransaction(ctx, tx any) *gomock.Call {
+func (mr *MockStorageMockRecorder) CreateTransaction(ctx, tx, message any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTransaction", reflect.TypeOf((*MockStorage)(nil).CreateTransaction), ctx, tx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTransaction", reflect.TypeOf((*MockStorage)(nil).CreateTransaction), ctx, tx, message)
+}
+
+// FailPendingTransaction mocks base method.
+func (m *MockStorage) FailPendingTransaction(ctx context.Context, txID int, errorMsg, reasonCode string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailPendingTransaction", ctx, txID, errorMsg, reasonCode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FailPendingTransaction indicates an expected call of FailPendingTransaction.
+func (mr *MockStorageMockRecorder) FailPendingTransaction(ctx, txID, errorMsg, reasonCode any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailPendingTransaction", reflect.TypeOf((*MockStorage)(nil).FailPendingTransaction), ctx, txID, errorMsg, reasonCode)
+}
+
+// GetGatewayByID mocks base method.
+func (m *MockStorage) GetGatewayByID(ctx context.Context, id int) (db.Gateway, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGatewayByID", ctx, id)
+	ret0, _ := ret[0].(db.Gateway)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGatewayByID indicates an expected call of GetGatewayByID.
+func (mr *MockStorageMockRecorder) GetGatewayByID(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGatewayByID", reflect.TypeOf((*MockStorage)(nil).GetGatewayByID), ctx, id)
+}
+
+// GetGatewayByName mocks base method.
+func (m *MockStorage) GetGatewayByName(ctx context.Context, name string) (db.Gateway, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGatewayByName", ctx, name)
+	ret0, _ := ret[0].(db.Gateway)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGatewayByName indicates an expected call of GetGatewayByName.
+func (mr *MockStorageMockRecorder) GetGatewayByName(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGatewayByName", reflect.TypeOf((*MockStorage)(nil).GetGatewayByName), ctx, name)
 }
 
 // GetGatewaysByCountry mocks base method.
@@ -71,6 +143,36 @@ func (mr *MockStorageMockRecorder) GetGatewaysByCountry(ctx, countryID any) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGatewaysByCountry", reflect.TypeOf((*MockStorage)(nil).GetGatewaysByCountry), ctx, countryID)
 }
 
+// GetStatusMapping mocks base method.
+func (m *MockStorage) GetStatusMapping(ctx context.Context, gatewayID int, gatewayStatus string) (db.StatusMapping, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStatusMapping", ctx, gatewayID, gatewayStatus)
+	ret0, _ := ret[0].(db.StatusMapping)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStatusMapping indicates an expected call of GetStatusMapping.
+func (mr *MockStorageMockRecorder) GetStatusMapping(ctx, gatewayID, gatewayStatus any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatusMapping", reflect.TypeOf((*MockStorage)(nil).GetStatusMapping), ctx, gatewayID, gatewayStatus)
+}
+
+// GetTransactionByGatewayTxnID mocks base method.
+func (m *MockStorage) GetTransactionByGatewayTxnID(ctx context.Context, gatewayID int, gatewayTxnID string) (db.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransactionByGatewayTxnID", ctx, gatewayID, gatewayTxnID)
+	ret0, _ := ret[0].(db.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransactionByGatewayTxnID indicates an expected call of GetTransactionByGatewayTxnID.
+func (mr *MockStorageMockRecorder) GetTransactionByGatewayTxnID(ctx, gatewayID, gatewayTxnID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionByGatewayTxnID", reflect.TypeOf((*MockStorage)(nil).GetTransactionByGatewayTxnID), ctx, gatewayID, gatewayTxnID)
+}
+
 // GetTransactionByID mocks base method.
 func (m *MockStorage) GetTransactionByID(ctx context.Context, id int) (db.Transaction, error) {
 	m.ctrl.T.Helper()
@@ -101,30 +203,44 @@ func (mr *MockStorageMockRecorder) GetUserByID(ctx, id any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockStorage)(nil).GetUserByID), ctx, id)
 }
 
+// RecordSecurityEvent mocks base method.
+func (m *MockStorage) RecordSecurityEvent(ctx context.Context, event db.SecurityEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordSecurityEvent", ctx, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordSecurityEvent indicates an expected call of RecordSecurityEvent.
+func (mr *MockStorageMockRecorder) RecordSecurityEvent(ctx, event any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordSecurityEvent", reflect.TypeOf((*MockStorage)(nil).RecordSecurityEvent), ctx, event)
+}
+
 // UpdateTransactionGateway mocks base method.
-func (m *MockStorage) UpdateTransactionGateway(ctx context.Context, txID, gatewayID int) error {
+func (m *MockStorage) UpdateTransactionGateway(ctx context.Context, txID, gatewayID int, fencingToken int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateTransactionGateway", ctx, txID, gatewayID)
+	ret := m.ctrl.Call(m, "UpdateTransactionGateway", ctx, txID, gatewayID, fencingToken)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateTransactionGateway indicates an expected call of UpdateTransactionGateway.
-func (mr *MockStorageMockRecorder) UpdateTransactionGateway(ctx, txID, gatewayID any) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateTransactionGateway(ctx, txID, gatewayID, fencingToken any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransactionGateway", reflect.TypeOf((*MockStorage)(nil).UpdateTransactionGateway), ctx, txID, gatewayID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransactionGateway", reflect.TypeOf((*MockStorage)(nil).UpdateTransactionGateway), ctx, txID, gatewayID, fencingToken)
 }
 
 // UpdateTransactionStatus mocks base method.
-func (m *MockStorage) UpdateTransactionStatus(ctx context.Context, txID int, status, gatewayTxnID, errorMsg string) error {
+func (m *MockStorage) UpdateTransactionStatus(ctx context.Context, txID int, status, gatewayTxnID, errorMsg, reasonCode string, fencingToken int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateTransactionStatus", ctx, txID, status, gatewayTxnID, errorMsg)
+	ret := m.ctrl.Call(m, "UpdateTransactionStatus", ctx, txID, status, gatewayTxnID, errorMsg, reasonCode, fencingToken)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateTransactionStatus indicates an expected call of UpdateTransactionStatus.
-func (mr *MockStorageMockRecorder) UpdateTransactionStatus(ctx, txID, status, gatewayTxnID, errorMsg any) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateTransactionStatus(ctx, txID, status, gatewayTxnID, errorMsg, reasonCode, fencingToken any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransactionStatus", reflect.TypeOf((*MockStorage)(nil).UpdateTransactionStatus), ctx, txID, status, gatewayTxnID, errorMsg)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransactionStatus", reflect.TypeOf((*MockStorage)(nil).UpdateTransactionStatus), ctx, txID, status, gatewayTxnID, errorMsg, reasonCode, fencingToken)
 }
@@ -0,0 +1,57 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/transaction_events.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/transaction_events.go -destination=tests/mocks/mock_transaction_events.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockTransactionEventStorage is a mock of TransactionEventStorage interface.
+type MockTransactionEventStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockTransactionEventStorageMockRecorder
+}
+
+// MockTransactionEventStorageMockRecorder is the mock recorder for MockTransactionEventStorage.
+type MockTransactionEventStorageMockRecorder struct {
+	mock *MockTransactionEventStorage
+}
+
+// NewMockTransactionEventStorage creates a new mock instance.
+func NewMockTransactionEventStorage(ctrl *gomock.Controller) *MockTransactionEventStorage {
+	mock := &MockTransactionEventStorage{ctrl: ctrl}
+	mock.recorder = &MockTransactionEventStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTransactionEventStorage) EXPECT() *MockTransactionEventStorageMockRecorder {
+	return m.recorder
+}
+
+// ListTransactionEvents mocks base method.
+func (m *MockTransactionEventStorage) ListTransactionEvents(ctx context.Context, filter db.TransactionEventFilter) ([]db.TransactionEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTransactionEvents", ctx, filter)
+	ret0, _ := ret[0].([]db.TransactionEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTransactionEvents indicates an expected call of ListTransactionEvents.
+func (mr *MockTransactionEventStorageMockRecorder) ListTransactionEvents(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTransactionEvents", reflect.TypeOf((*MockTransactionEventStorage)(nil).ListTransactionEvents), ctx, filter)
+}
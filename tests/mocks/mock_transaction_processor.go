@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -source=internal/workers/transaction_processor.go -destination=internal/tests/mocks/mock_transaction_processor.go -package=mocks
+//	mockgen -source=internal/workers/transaction_processor.go -destination=tests/mocks/mock_transaction_processor.go -package=mocks
 //
 
 // Package mocks is a generated GoMock package.
@@ -14,6 +14,7 @@ import (
 	"reflect"
 
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/workers"
 
 	"go.uber.org/mock/gomock"
 )
@@ -42,15 +43,46 @@ func (m *MockTransactionProcessor) EXPECT() *MockTransactionProcessorMockRecorde
 }
 
 // ProcessTransaction mocks base method.
-func (m *MockTransactionProcessor) ProcessTransaction(tx models.Transaction) {
+func (m *MockTransactionProcessor) ProcessTransaction(ctx context.Context, tx models.Transaction) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "ProcessTransaction", tx)
+	ret := m.ctrl.Call(m, "ProcessTransaction", ctx, tx)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // ProcessTransaction indicates an expected call of ProcessTransaction.
-func (mr *MockTransactionProcessorMockRecorder) ProcessTransaction(tx any) *gomock.Call {
+func (mr *MockTransactionProcessorMockRecorder) ProcessTransaction(ctx, tx any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessTransaction", reflect.TypeOf((*MockTransactionProcessor)(nil).ProcessTransaction), tx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessTransaction", reflect.TypeOf((*MockTransactionProcessor)(nil).ProcessTransaction), ctx, tx)
+}
+
+// QueueDepth mocks base method.
+func (m *MockTransactionProcessor) QueueDepth() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueDepth")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// QueueDepth indicates an expected call of QueueDepth.
+func (mr *MockTransactionProcessorMockRecorder) QueueDepth() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueDepth", reflect.TypeOf((*MockTransactionProcessor)(nil).QueueDepth))
+}
+
+// Reserve mocks base method.
+func (m *MockTransactionProcessor) Reserve(ctx context.Context) (workers.Reservation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reserve", ctx)
+	ret0, _ := ret[0].(workers.Reservation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reserve indicates an expected call of Reserve.
+func (mr *MockTransactionProcessorMockRecorder) Reserve(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reserve", reflect.TypeOf((*MockTransactionProcessor)(nil).Reserve), ctx)
 }
 
 // Start mocks base method.
@@ -76,3 +108,52 @@ func (mr *MockTransactionProcessorMockRecorder) Stop() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockTransactionProcessor)(nil).Stop))
 }
+
+// MockReservation is a mock of Reservation interface.
+type MockReservation struct {
+	ctrl     *gomock.Controller
+	recorder *MockReservationMockRecorder
+}
+
+// MockReservationMockRecorder is the mock recorder for MockReservation.
+type MockReservationMockRecorder struct {
+	mock *MockReservation
+}
+
+// NewMockReservation creates a new mock instance.
+func NewMockReservation(ctrl *gomock.Controller) *MockReservation {
+	mock := &MockReservation{ctrl: ctrl}
+	mock.recorder = &MockReservationMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReservation) EXPECT() *MockReservationMockRecorder {
+	return m.recorder
+}
+
+// Cancel mocks base method.
+func (m *MockReservation) Cancel() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Cancel")
+}
+
+// Cancel indicates an expected call of Cancel.
+func (mr *MockReservationMockRecorder) Cancel() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockReservation)(nil).Cancel))
+}
+
+// Enqueue mocks base method.
+func (m *MockReservation) Enqueue(tx models.Transaction) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", tx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockReservationMockRecorder) Enqueue(tx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockReservation)(nil).Enqueue), tx)
+}
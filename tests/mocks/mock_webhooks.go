@@ -0,0 +1,204 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: db/webhooks.go
+//
+// Generated by this command:
+//
+//	mockgen -source=db/webhooks.go -destination=tests/mocks/mock_webhooks.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"payment-gateway/db"
+
+	"go.uber.org/mock/gomock"
+)
+
+// MockWebhookStorage is a mock of WebhookStorage interface.
+type MockWebhookStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookStorageMockRecorder
+}
+
+// MockWebhookStorageMockRecorder is the mock recorder for MockWebhookStorage.
+type MockWebhookStorageMockRecorder struct {
+	mock *MockWebhookStorage
+}
+
+// NewMockWebhookStorage creates a new mock instance.
+func NewMockWebhookStorage(ctrl *gomock.Controller) *MockWebhookStorage {
+	mock := &MockWebhookStorage{ctrl: ctrl}
+	mock.recorder = &MockWebhookStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhookStorage) EXPECT() *MockWebhookStorageMockRecorder {
+	return m.recorder
+}
+
+// ClaimWebhookDeliveries mocks base method.
+func (m *MockWebhookStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, visibility time.Duration) ([]db.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimWebhookDeliveries", ctx, limit, visibility)
+	ret0, _ := ret[0].([]db.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimWebhookDeliveries indicates an expected call of ClaimWebhookDeliveries.
+func (mr *MockWebhookStorageMockRecorder) ClaimWebhookDeliveries(ctx, limit, visibility any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimWebhookDeliveries", reflect.TypeOf((*MockWebhookStorage)(nil).ClaimWebhookDeliveries), ctx, limit, visibility)
+}
+
+// CreateWebhookSubscription mocks base method.
+func (m *MockWebhookStorage) CreateWebhookSubscription(ctx context.Context, sub db.WebhookSubscription) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebhookSubscription", ctx, sub)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateWebhookSubscription indicates an expected call of CreateWebhookSubscription.
+func (mr *MockWebhookStorageMockRecorder) CreateWebhookSubscription(ctx, sub any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhookSubscription", reflect.TypeOf((*MockWebhookStorage)(nil).CreateWebhookSubscription), ctx, sub)
+}
+
+// DeactivateWebhookSubscription mocks base method.
+func (m *MockWebhookStorage) DeactivateWebhookSubscription(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeactivateWebhookSubscription", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeactivateWebhookSubscription indicates an expected call of DeactivateWebhookSubscription.
+func (mr *MockWebhookStorageMockRecorder) DeactivateWebhookSubscription(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeactivateWebhookSubscription", reflect.TypeOf((*MockWebhookStorage)(nil).DeactivateWebhookSubscription), ctx, id)
+}
+
+// EnqueueWebhookEvent mocks base method.
+func (m *MockWebhookStorage) EnqueueWebhookEvent(ctx context.Context, event db.WebhookEvent) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueWebhookEvent", ctx, event)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueWebhookEvent indicates an expected call of EnqueueWebhookEvent.
+func (mr *MockWebhookStorageMockRecorder) EnqueueWebhookEvent(ctx, event any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueWebhookEvent", reflect.TypeOf((*MockWebhookStorage)(nil).EnqueueWebhookEvent), ctx, event)
+}
+
+// GetWebhookDelivery mocks base method.
+func (m *MockWebhookStorage) GetWebhookDelivery(ctx context.Context, id int) (db.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookDelivery", ctx, id)
+	ret0, _ := ret[0].(db.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookDelivery indicates an expected call of GetWebhookDelivery.
+func (mr *MockWebhookStorageMockRecorder) GetWebhookDelivery(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookDelivery", reflect.TypeOf((*MockWebhookStorage)(nil).GetWebhookDelivery), ctx, id)
+}
+
+// GetWebhookSubscription mocks base method.
+func (m *MockWebhookStorage) GetWebhookSubscription(ctx context.Context, id int) (db.WebhookSubscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookSubscription", ctx, id)
+	ret0, _ := ret[0].(db.WebhookSubscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookSubscription indicates an expected call of GetWebhookSubscription.
+func (mr *MockWebhookStorageMockRecorder) GetWebhookSubscription(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookSubscription", reflect.TypeOf((*MockWebhookStorage)(nil).GetWebhookSubscription), ctx, id)
+}
+
+// ListWebhookDeliveries mocks base method.
+func (m *MockWebhookStorage) ListWebhookDeliveries(ctx context.Context, subscriptionID int, status string, limit int) ([]db.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListWebhookDeliveries", ctx, subscriptionID, status, limit)
+	ret0, _ := ret[0].([]db.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListWebhookDeliveries indicates an expected call of ListWebhookDeliveries.
+func (mr *MockWebhookStorageMockRecorder) ListWebhookDeliveries(ctx, subscriptionID, status, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListWebhookDeliveries", reflect.TypeOf((*MockWebhookStorage)(nil).ListWebhookDeliveries), ctx, subscriptionID, status, limit)
+}
+
+// ListWebhookSubscriptions mocks base method.
+func (m *MockWebhookStorage) ListWebhookSubscriptions(ctx context.Context, userID *int) ([]db.WebhookSubscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListWebhookSubscriptions", ctx, userID)
+	ret0, _ := ret[0].([]db.WebhookSubscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListWebhookSubscriptions indicates an expected call of ListWebhookSubscriptions.
+func (mr *MockWebhookStorageMockRecorder) ListWebhookSubscriptions(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListWebhookSubscriptions", reflect.TypeOf((*MockWebhookStorage)(nil).ListWebhookSubscriptions), ctx, userID)
+}
+
+// MarkWebhookDelivered mocks base method.
+func (m *MockWebhookStorage) MarkWebhookDelivered(ctx context.Context, id, statusCode int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkWebhookDelivered", ctx, id, statusCode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkWebhookDelivered indicates an expected call of MarkWebhookDelivered.
+func (mr *MockWebhookStorageMockRecorder) MarkWebhookDelivered(ctx, id, statusCode any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkWebhookDelivered", reflect.TypeOf((*MockWebhookStorage)(nil).MarkWebhookDelivered), ctx, id, statusCode)
+}
+
+// MarkWebhookFailed mocks base method.
+func (m *MockWebhookStorage) MarkWebhookFailed(ctx context.Context, id int, statusCode *int, errorMsg string, nextAttemptAt time.Time, dead bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkWebhookFailed", ctx, id, statusCode, errorMsg, nextAttemptAt, dead)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkWebhookFailed indicates an expected call of MarkWebhookFailed.
+func (mr *MockWebhookStorageMockRecorder) MarkWebhookFailed(ctx, id, statusCode, errorMsg, nextAttemptAt, dead any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkWebhookFailed", reflect.TypeOf((*MockWebhookStorage)(nil).MarkWebhookFailed), ctx, id, statusCode, errorMsg, nextAttemptAt, dead)
+}
+
+// RedeliverWebhook mocks base method.
+func (m *MockWebhookStorage) RedeliverWebhook(ctx context.Context, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RedeliverWebhook", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RedeliverWebhook indicates an expected call of RedeliverWebhook.
+func (mr *MockWebhookStorageMockRecorder) RedeliverWebhook(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedeliverWebhook", reflect.TypeOf((*MockWebhookStorage)(nil).RedeliverWebhook), ctx, id)
+}
@@ -0,0 +1,281 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/outbox"
+	"payment-gateway/internal/requestid"
+	"payment-gateway/internal/schema"
+	"payment-gateway/pkg/encryption"
+	"payment-gateway/pkg/events"
+)
+
+func TestOutboxRelay_PublishesInOrderUntilDrained(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockOutboxStorage(ctrl)
+	mockSink := mocks.NewMockEventSink(ctrl)
+
+	batches := [][]db.OutboxMessage{
+		{{ID: 1, Topic: "payment-transactions", Key: "7", Headers: map[string]string{"schema-id": "2"}, Payload: []byte("a")}, {ID: 2, Topic: "payment-transactions", Key: "8", Payload: []byte("b")}},
+		{{ID: 3, Topic: "payment-transactions", Payload: []byte("c")}},
+	}
+	for _, batch := range batches {
+		batch := batch
+		mockStore.EXPECT().RelayOutbox(gomock.Any(), 2, 5, gomock.Any()).DoAndReturn(
+			func(_ context.Context, _, _ int, publish func(db.OutboxMessage) error) (int, []db.OutboxMessage, error) {
+				for _, message := range batch {
+					assert.NoError(t, publish(message))
+				}
+				return len(batch), nil, nil
+			})
+	}
+
+	gomock.InOrder(
+		mockSink.EXPECT().PublishMessage(gomock.Any(), "payment-transactions", []byte("7"), []byte("a"), map[string]string{"schema-id": "2"}).Return(nil),
+		mockSink.EXPECT().PublishMessage(gomock.Any(), "payment-transactions", []byte("8"), []byte("b"), nil).Return(nil),
+		mockSink.EXPECT().PublishMessage(gomock.Any(), "payment-transactions", nil, []byte("c"), nil).Return(nil),
+	)
+
+	relay := outbox.NewRelay(mockStore, mockSink, time.Second, 2, 5, time.Second)
+	relay.Poll(context.Background())
+}
+
+func TestOutboxRelay_StopsOnPublishError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockOutboxStorage(ctrl)
+	mockSink := mocks.NewMockEventSink(ctrl)
+
+	// a full batch, but after the failure Poll must not ask for more
+	mockStore.EXPECT().RelayOutbox(gomock.Any(), 1, 5, gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, _ int, publish func(db.OutboxMessage) error) (int, []db.OutboxMessage, error) {
+			err := publish(db.OutboxMessage{ID: 1, Topic: "payment-transactions", Payload: []byte("a")})
+			assert.Error(t, err)
+			return 0, nil, err
+		})
+	mockSink.EXPECT().PublishMessage(gomock.Any(), "payment-transactions", nil, []byte("a"), gomock.Any()).Return(errors.New("kafka down"))
+
+	relay := outbox.NewRelay(mockStore, mockSink, time.Second, 1, 5, time.Second)
+	relay.Poll(context.Background())
+}
+
+func TestOutboxRelay_ContinuesAfterParkedMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockOutboxStorage(ctrl)
+
+	// the batch was full with a parked message, so Poll asks for more
+	gomock.InOrder(
+		mockStore.EXPECT().RelayOutbox(gomock.Any(), 1, 5, gomock.Any()).
+			Return(0, []db.OutboxMessage{{ID: 1, Topic: "payment-transactions", Attempts: 5}}, nil),
+		mockStore.EXPECT().RelayOutbox(gomock.Any(), 1, 5, gomock.Any()).Return(0, nil, nil),
+	)
+
+	relay := outbox.NewRelay(mockStore, mocks.NewMockEventSink(ctrl), time.Second, 1, 5, time.Second)
+	relay.Poll(context.Background())
+}
+
+func newEncoder(t *testing.T, codec events.Codec) *outbox.Encoder {
+	registry, err := schema.Load("../contract/events")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	encoder, err := outbox.NewEncoder(codec, registry, newTestCipher(t))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return encoder
+}
+
+func TestStatusMessages_KeyedByTransaction(t *testing.T) {
+	ctx := requestid.NewContext(context.Background(), "req-1")
+
+	message, err := newEncoder(t, events.JSONCodec{}).StatusMessages("payment-transaction-events")(ctx, db.TransactionEvent{
+		ID: 12, TransactionID: 7, UserID: 3, PreviousStatus: "processing", Status: "failed",
+		GatewayID: 2, ReasonCode: "insufficient_funds", CreatedAt: time.Now(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "payment-transaction-events", message.Topic)
+	assert.Equal(t, "7", message.Key)
+	assert.Equal(t, map[string]string{
+		"event-type":        events.TypeStatusChanged,
+		"schema-id":         "3",
+		"content-type":      "application/json",
+		"request-id":        "req-1",
+		"encryption-key-id": "test-key",
+	}, message.Headers)
+
+	var envelope events.Envelope
+	assert.NoError(t, json.Unmarshal(message.Payload, &envelope))
+	assert.Equal(t, "evt_transaction.status_changed_12", envelope.ID)
+	assert.Equal(t, events.TypeStatusChanged, envelope.Type)
+	assert.Equal(t, events.SchemaVersions[events.TypeStatusChanged], envelope.SchemaVersion)
+	assert.Equal(t, "req-1", envelope.CorrelationID)
+
+	assert.True(t, envelope.Encrypted)
+
+	var event events.StatusChanged
+	assert.NoError(t, envelope.DecodeWith(ctx, newTestCipher(t), &event))
+	assert.Equal(t, int64(12), event.EventID)
+	assert.Equal(t, "processing", event.PreviousStatus)
+	assert.Equal(t, "failed", event.Status)
+	assert.Equal(t, 2, event.GatewayID)
+	assert.Equal(t, "insufficient_funds", event.ReasonCode)
+}
+
+func TestStatusMessages_Protobuf(t *testing.T) {
+	occurredAt := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+
+	message, err := newEncoder(t, events.ProtobufCodec{}).StatusMessages("payment-transaction-events")(context.Background(), db.TransactionEvent{
+		ID: 12, TransactionID: 7, UserID: 3, PreviousStatus: "processing", Status: "completed", CreatedAt: occurredAt,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "6", message.Headers[events.HeaderSchemaID])
+
+	// consumers pick the codec from the header
+	codec, err := events.CodecByContentType(message.Headers[events.HeaderContentType])
+	assert.NoError(t, err)
+	assert.Equal(t, events.FormatProtobuf, codec.Format())
+
+	envelope, err := codec.Unmarshal(message.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "evt_transaction.status_changed_12", envelope.ID)
+	assert.Equal(t, occurredAt, envelope.OccurredAt)
+
+	var event events.StatusChanged
+	assert.NoError(t, envelope.DecodeWith(context.Background(), newTestCipher(t), &event))
+	assert.Equal(t, events.StatusChanged{
+		EventID: 12, TransactionID: 7, UserID: 3, PreviousStatus: "processing", Status: "completed", OccurredAt: occurredAt,
+	}, event)
+}
+
+func TestNewEncoder_RequiresSchemas(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "registry.json"), []byte(`{"schemas": []}`), 0o644))
+
+	registry, err := schema.Load(dir)
+	assert.NoError(t, err)
+
+	_, err = outbox.NewEncoder(events.JSONCodec{}, registry, newTestCipher(t))
+	assert.ErrorIs(t, err, schema.ErrNotFound)
+}
+
+func TestReencrypt_RewritesMessagesOfRetiredKeys(t *testing.T) {
+	ctx := context.Background()
+	registry, err := schema.Load("../contract/events")
+	assert.NoError(t, err)
+
+	rotated := encryption.New(newTestKeys(t, "rotated", map[string][]byte{
+		"test-key": testEncryptionKey,
+		"rotated":  testRotatedKey,
+	}))
+	oldEncoder, err := outbox.NewEncoder(events.JSONCodec{}, registry, newTestCipher(t))
+	assert.NoError(t, err)
+	newEncoder, err := outbox.NewEncoder(events.ProtobufCodec{}, registry, rotated)
+	assert.NoError(t, err)
+
+	transaction := db.Transaction{ID: 1, UserID: 3, Amount: decimal.RequireFromString("9.99"), Currency: "EUR", Type: "deposit", Status: "pending"}
+	stale, err := oldEncoder.CreatedMessages("payment-transactions")(ctx, transaction)
+	assert.NoError(t, err)
+	stale.ID = 1
+	status, err := oldEncoder.StatusMessages("payment-transaction-events")(ctx, db.TransactionEvent{ID: 1, TransactionID: 1, Status: "completed"})
+	assert.NoError(t, err)
+	status.ID = 2
+	// status events were published in plaintext before they were encrypted
+	legacyEnvelope, err := events.NewEnvelope("evt_transaction.status_changed_2", events.TypeStatusChanged, time.Now(), "",
+		events.StatusChanged{EventID: 2, TransactionID: 1, Status: "completed"})
+	assert.NoError(t, err)
+	legacyPayload, err := json.Marshal(legacyEnvelope)
+	assert.NoError(t, err)
+	legacy := db.OutboxMessage{ID: 3, Topic: "payment-transaction-events", Payload: legacyPayload}
+	transaction.ID = 4
+	current, err := newEncoder.CreatedMessages("payment-transactions")(ctx, transaction)
+	assert.NoError(t, err)
+	current.ID = 4
+
+	for _, dryRun := range []bool{true, false} {
+		ctrl := gomock.NewController(t)
+		mockStore := mocks.NewMockOutboxStorage(ctrl)
+
+		gomock.InOrder(
+			mockStore.EXPECT().ListOutbox(gomock.Any(), int64(0), 2).Return([]db.OutboxMessage{stale, status}, nil),
+			mockStore.EXPECT().ListOutbox(gomock.Any(), int64(2), 2).Return([]db.OutboxMessage{legacy, current}, nil),
+			mockStore.EXPECT().ListOutbox(gomock.Any(), int64(4), 2).Return(nil, nil),
+		)
+		if !dryRun {
+			mockStore.EXPECT().RewriteOutboxMessage(gomock.Any(), int64(1), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, _ int64, headers map[string]string, payload []byte) error {
+					assert.Equal(t, "rotated", headers[events.HeaderEncryptionKeyID])
+					assert.Equal(t, stale.Headers[events.HeaderSchemaID], headers[events.HeaderSchemaID])
+
+					var envelope events.Envelope
+					assert.NoError(t, json.Unmarshal(payload, &envelope))
+					assert.Equal(t, "evt_transaction.created_1", envelope.ID)
+
+					// readable once the old key is gone
+					var data events.TransactionCreated
+					retired := encryption.New(newTestKeys(t, "rotated", map[string][]byte{"rotated": testRotatedKey}))
+					assert.NoError(t, envelope.DecodeWith(ctx, retired, &data))
+					assert.Equal(t, "9.99", data.Amount)
+					return nil
+				})
+			mockStore.EXPECT().RewriteOutboxMessage(gomock.Any(), int64(2), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, _ int64, headers map[string]string, payload []byte) error {
+					assert.Equal(t, "rotated", headers[events.HeaderEncryptionKeyID])
+
+					var envelope events.Envelope
+					assert.NoError(t, json.Unmarshal(payload, &envelope))
+
+					var data events.StatusChanged
+					retired := encryption.New(newTestKeys(t, "rotated", map[string][]byte{"rotated": testRotatedKey}))
+					assert.NoError(t, envelope.DecodeWith(ctx, retired, &data))
+					assert.Equal(t, "completed", data.Status)
+					return nil
+				})
+		}
+
+		stats, err := outbox.Reencrypt(ctx, mockStore, rotated, 2, dryRun)
+		assert.NoError(t, err)
+		rewritten := 2
+		if dryRun {
+			rewritten = 0
+		}
+		assert.Equal(t, outbox.ReencryptStats{Scanned: 4, Encrypted: 3, Stale: 2, Rewritten: rewritten}, stats)
+		ctrl.Finish()
+	}
+}
+
+func TestReencrypt_StopsAtUnreadableMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	message, err := newEncoder(t, events.JSONCodec{}).CreatedMessages("payment-transactions")(ctx, db.Transaction{ID: 1})
+	assert.NoError(t, err)
+	message.ID = 1
+
+	mockStore := mocks.NewMockOutboxStorage(ctrl)
+	mockStore.EXPECT().ListOutbox(gomock.Any(), int64(0), 10).Return([]db.OutboxMessage{message}, nil)
+
+	// test-key was dropped before the messages were re-encrypted
+	cipher := encryption.New(newTestKeys(t, "rotated", map[string][]byte{"rotated": testRotatedKey}))
+	_, err = outbox.Reencrypt(ctx, mockStore, cipher, 10, false)
+	assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -14,7 +15,10 @@ import (
 
 	"payment-gateway/db"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/requestid"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/workers"
+	"payment-gateway/pkg/events"
 )
 
 func TestProcessTransaction_Success(t *testing.T) {
@@ -24,7 +28,7 @@ func TestProcessTransaction_Success(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -35,8 +39,8 @@ func TestProcessTransaction_Success(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
-		func(_ context.Context, transaction db.Transaction) (int, error) {
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction db.Transaction, _ db.OutboxMessageFunc) (int, error) {
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
 			assert.Equal(t, tx.Currency, transaction.Currency)
@@ -45,8 +49,8 @@ func TestProcessTransaction_Success(t *testing.T) {
 			assert.Equal(t, 0, transaction.GatewayID)
 			return 1, nil
 		})
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any()).Do(
-		func(transaction models.Transaction) {
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction models.Transaction) error {
 			assert.Equal(t, 1, transaction.ID)
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
@@ -54,11 +58,11 @@ func TestProcessTransaction_Success(t *testing.T) {
 			assert.Equal(t, tx.Type, transaction.Type)
 			assert.Equal(t, "pending", transaction.Status)
 			assert.Equal(t, 0, transaction.GatewayID)
+			return nil
 		})
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -72,7 +76,7 @@ func TestProcessTransaction_CreateTransactionError(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -83,10 +87,10 @@ func TestProcessTransaction_CreateTransactionError(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).Return(0, fmt.Errorf("database error"))
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, fmt.Errorf("database error"))
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -94,16 +98,16 @@ func TestProcessTransaction_CreateTransactionError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to create transaction record")
 }
 
-func TestProcessTransaction_KafkaError(t *testing.T) {
+func TestProcessTransaction_WritesOutboxMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
-	ctx := context.Background()
+	ctx := requestid.NewContext(context.Background(), "req-1")
 	tx := db.Transaction{
 		UserID:   1,
 		Amount:   decimal.NewFromFloat(100.0),
@@ -112,12 +116,42 @@ func TestProcessTransaction_KafkaError(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).Return(1, nil)
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any())
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("kafka error"))
-
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, transaction db.Transaction, message db.OutboxMessageFunc) (int, error) {
+			// the storage calls it inside the insert, with the new ID
+			transaction.ID = 1
+			msg, err := message(ctx, transaction)
+			assert.NoError(t, err)
+			assert.Equal(t, cfg.Kafka.TransactionsTopic, msg.Topic)
+			assert.Equal(t, "1", msg.Key)
+			assert.Equal(t, "2", msg.Headers[events.HeaderSchemaID])
+			assert.Equal(t, "test-key", msg.Headers[events.HeaderEncryptionKeyID])
+			assert.Equal(t, "req-1", msg.Headers[events.HeaderRequestID])
+
+			var envelope events.Envelope
+			assert.NoError(t, json.Unmarshal(msg.Payload, &envelope))
+			assert.Equal(t, events.TypeTransactionCreated, envelope.Type)
+			assert.Equal(t, "evt_transaction.created_1", envelope.ID)
+			assert.True(t, envelope.Encrypted)
+
+			var data events.TransactionCreated
+			assert.NoError(t, envelope.DecodeWith(ctx, newTestCipher(t), &data))
+			assert.Equal(t, events.TransactionCreated{
+				TransactionID: 1, UserID: 1, Amount: "100", Currency: "USD", Type: "deposit", Status: "pending",
+			}, data)
+			return 1, nil
+		})
+	// the worker tags its status changes with the request ID
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, tx models.Transaction) error {
+			assert.Equal(t, "req-1", tx.RequestID)
+			return nil
+		})
+
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker,
+		newEncoder(t, events.JSONCodec{}).CreatedMessages(cfg.Kafka.TransactionsTopic), cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -131,7 +165,7 @@ func TestProcessTransaction_MarshalError(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -142,13 +176,11 @@ func TestProcessTransaction_MarshalError(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).Return(1, nil)
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any())
-
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil)
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -162,7 +194,7 @@ func TestProcessTransaction_WithSpecificGateway(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -174,8 +206,8 @@ func TestProcessTransaction_WithSpecificGateway(t *testing.T) {
 		GatewayID: 3, // Specific gateway requested
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
-		func(_ context.Context, transaction db.Transaction) (int, error) {
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction db.Transaction, _ db.OutboxMessageFunc) (int, error) {
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
 			assert.Equal(t, tx.Currency, transaction.Currency)
@@ -184,8 +216,8 @@ func TestProcessTransaction_WithSpecificGateway(t *testing.T) {
 			assert.Equal(t, 3, transaction.GatewayID) // Verify specific gateway ID is preserved
 			return 1, nil
 		})
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any()).Do(
-		func(transaction models.Transaction) {
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction models.Transaction) error {
 			assert.Equal(t, 1, transaction.ID)
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
@@ -193,11 +225,11 @@ func TestProcessTransaction_WithSpecificGateway(t *testing.T) {
 			assert.Equal(t, tx.Type, transaction.Type)
 			assert.Equal(t, "pending", transaction.Status)
 			assert.Equal(t, 3, transaction.GatewayID) // Verify specific gateway ID is preserved
+			return nil
 		})
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -211,7 +243,7 @@ func TestProcessTransaction_WithdrawalWithPrioritizedGateways(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -222,8 +254,8 @@ func TestProcessTransaction_WithdrawalWithPrioritizedGateways(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
-		func(_ context.Context, transaction db.Transaction) (int, error) {
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction db.Transaction, _ db.OutboxMessageFunc) (int, error) {
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
 			assert.Equal(t, tx.Currency, transaction.Currency)
@@ -231,19 +263,19 @@ func TestProcessTransaction_WithdrawalWithPrioritizedGateways(t *testing.T) {
 			assert.Equal(t, "pending", transaction.Status)
 			return 1, nil
 		})
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any()).Do(
-		func(transaction models.Transaction) {
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction models.Transaction) error {
 			assert.Equal(t, 1, transaction.ID)
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, tx.Amount, transaction.Amount)
 			assert.Equal(t, tx.Currency, transaction.Currency)
 			assert.Equal(t, "withdrawal", transaction.Type)
 			assert.Equal(t, "pending", transaction.Status)
+			return nil
 		})
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
@@ -257,7 +289,7 @@ func TestProcessTransaction_WithHighValueAmount(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	tx := db.Transaction{
@@ -268,8 +300,8 @@ func TestProcessTransaction_WithHighValueAmount(t *testing.T) {
 		Status:   "pending",
 	}
 
-	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
-		func(_ context.Context, transaction db.Transaction) (int, error) {
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, transaction db.Transaction, _ db.OutboxMessageFunc) (int, error) {
 			assert.Equal(t, tx.UserID, transaction.UserID)
 			assert.Equal(t, decimal.NewFromFloat(10000.0), transaction.Amount)
 			assert.Equal(t, tx.Currency, transaction.Currency)
@@ -277,13 +309,150 @@ func TestProcessTransaction_WithHighValueAmount(t *testing.T) {
 			assert.Equal(t, "pending", transaction.Status)
 			return 1, nil
 		})
-	mockProcessor.EXPECT().ProcessTransaction(gomock.Any())
-	mockKafka.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	err := service.ProcessTransaction(ctx, tx)
 
 	assert.NoError(t, err)
 }
+
+func TestProcessTransaction_QueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+
+	ctx := context.Background()
+	tx := db.Transaction{
+		UserID:   1,
+		Amount:   decimal.NewFromFloat(100.0),
+		Currency: "USD",
+		Type:     "deposit",
+		Status:   "pending",
+	}
+
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil)
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(workers.ErrQueueFull)
+	mockDB.EXPECT().FailPendingTransaction(gomock.Any(), 1, gomock.Any(), workers.ReasonQueueFull).Return(nil)
+
+	cfg := envs.Load()
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
+
+	err := service.ProcessTransaction(ctx, tx)
+
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, workers.ErrQueueFull)
+}
+
+func TestProcessTransaction_CommandQueueFullCreatesNothing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+
+	// CreateTransaction must not be called, a failed row would use up the
+	// command ID its retry needs
+	mockProcessor.EXPECT().Reserve(gomock.Any()).Return(nil, workers.ErrQueueFull)
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mockProcessor, mocks.NewMockLocker(ctrl), nil, &envs.Config{})
+
+	err := service.ProcessTransaction(context.Background(), db.Transaction{
+		UserID: 1, Amount: decimal.NewFromInt(10), Currency: "EUR", Type: "withdrawal", Status: "pending", CommandID: "cmd-1",
+	})
+
+	assert.ErrorIs(t, err, workers.ErrQueueFull)
+}
+
+func TestProcessTransaction_CommandQueuedInReservedPlace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockReservation := mocks.NewMockReservation(ctrl)
+
+	gomock.InOrder(
+		mockProcessor.EXPECT().Reserve(gomock.Any()).Return(mockReservation, nil),
+		mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(7, nil),
+		mockReservation.EXPECT().Enqueue(gomock.Any()).DoAndReturn(func(tx models.Transaction) error {
+			assert.Equal(t, 7, tx.ID)
+			return nil
+		}),
+	)
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mockProcessor, mocks.NewMockLocker(ctrl), nil, &envs.Config{})
+
+	err := service.ProcessTransaction(context.Background(), db.Transaction{
+		UserID: 1, Amount: decimal.NewFromInt(10), Currency: "EUR", Type: "withdrawal", Status: "pending", CommandID: "cmd-1",
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestProcessTransaction_DuplicateCommandGivesPlaceBack(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockReservation := mocks.NewMockReservation(ctrl)
+
+	mockProcessor.EXPECT().Reserve(gomock.Any()).Return(mockReservation, nil)
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, db.ErrDuplicateCommand)
+	mockReservation.EXPECT().Cancel()
+
+	service := services.NewGateway(mockDB, mocks.NewMockCache(ctrl), mockProcessor, mocks.NewMockLocker(ctrl), nil, &envs.Config{})
+
+	err := service.ProcessTransaction(context.Background(), db.Transaction{
+		UserID: 1, Amount: decimal.NewFromInt(10), Currency: "EUR", Type: "withdrawal", Status: "pending", CommandID: "cmd-1",
+	})
+
+	assert.ErrorIs(t, err, db.ErrDuplicateCommand)
+}
+
+func TestProcessTransaction_CancelledEnqueueIsNotQueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx := db.Transaction{
+		UserID:   1,
+		Amount:   decimal.NewFromFloat(100.0),
+		Currency: "USD",
+		Type:     "deposit",
+		Status:   "pending",
+	}
+
+	mockDB.EXPECT().CreateTransaction(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil)
+	mockProcessor.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, models.Transaction) error {
+			cancel()
+			return context.Canceled
+		})
+	mockDB.EXPECT().FailPendingTransaction(gomock.Any(), 1, gomock.Any(), workers.ReasonNotQueued).
+		DoAndReturn(func(ctx context.Context, _ int, _, _ string) error {
+			// the client went away, the rejection must still be written
+			assert.NoError(t, ctx.Err())
+			return nil
+		})
+
+	cfg := envs.Load()
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
+
+	err := service.ProcessTransaction(ctx, tx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, workers.ErrQueueFull)
+}
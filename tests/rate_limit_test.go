@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/stream"
+)
+
+// allowIPs returns an IP limiter with plenty of room for any address.
+func allowIPs(ctrl *gomock.Controller) *mocks.MockLimiter {
+	ipLimiter := mocks.NewMockLimiter(ctrl)
+	ipLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).
+		Return(ratelimit.Result{Allowed: true, Limit: 100, Remaining: 99}, nil).AnyTimes()
+	return ipLimiter
+}
+
+func TestRateLimit_AllowedRequestHasHeaders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	userID := 123
+	mockLimiter.EXPECT().Allow(gomock.Any(), "user:123").
+		Return(ratelimit.Result{Allowed: true, Limit: 20, Remaining: 5, ResetAfter: 2 * time.Second}, nil)
+	mockLimiter.EXPECT().Allow(gomock.Any(), "apikey:7").
+		Return(ratelimit.Result{Allowed: true, Limit: 20, Remaining: 19, ResetAfter: time.Second}, nil)
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "secret").Return(auth.Identity{KeyID: 7, Scopes: []string{auth.ScopeDeposit}, UserID: &userID}, nil)
+	mockService.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, allowIPs(ctrl), "", mockKeys, mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	req := httptest.NewRequest(http.MethodPost, "/deposit",
+		strings.NewReader(`{"user_id": 123, "amount": "10.00", "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "20", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "5", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestRateLimit_ExceededReturns429(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "secret").Return(auth.Identity{KeyID: 7, Scopes: []string{auth.ScopeWithdrawal}}, nil)
+	mockLimiter.EXPECT().Allow(gomock.Any(), "apikey:7").
+		Return(ratelimit.Result{Allowed: false, Limit: 20, Remaining: 0, RetryAfter: 1500 * time.Millisecond}, nil)
+	// The handler must not be reached
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, allowIPs(ctrl), "", mockKeys, mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	req := httptest.NewRequest(http.MethodPost, "/withdrawal",
+		strings.NewReader(`{"user_id": 123, "amount": "10.00", "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+}
+
+func TestRateLimit_LimiterErrorFailsOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{}, assert.AnError).AnyTimes()
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "secret").Return(auth.Identity{Scopes: []string{auth.ScopeDeposit}}, nil)
+	mockService.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, mockLimiter, "", mockKeys, mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	req := httptest.NewRequest(http.MethodPost, "/deposit",
+		strings.NewReader(`{"user_id": 123, "amount": "10.00", "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestRateLimit_UnrestrictedKeyLimitsRequestedUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+
+	// an admin key acting for any user is limited per user as well
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "secret").Return(auth.Identity{KeyID: 7, Scopes: []string{auth.ScopeDeposit}}, nil)
+	mockLimiter.EXPECT().Allow(gomock.Any(), "apikey:7").Return(ratelimit.Result{Allowed: true, Limit: 20, Remaining: 19}, nil)
+	mockLimiter.EXPECT().Allow(gomock.Any(), "user:456").Return(ratelimit.Result{Allowed: true, Limit: 20, Remaining: 3}, nil)
+	mockService.EXPECT().ProcessTransaction(gomock.Any(), gomock.Any()).Return(nil)
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, allowIPs(ctrl), "", mockKeys, mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	// the handler still gets the whole body
+	req := httptest.NewRequest(http.MethodPost, "/deposit",
+		strings.NewReader(`<request><user_id>456</user_id><amount>10.00</amount><currency>USD</currency></request>`))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("X-API-Key", "secret")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("X-RateLimit-Remaining"))
+}
+
+func TestRateLimit_InvalidKeysAreLimitedByIP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	ipLimiter := mocks.NewMockLimiter(ctrl)
+
+	// the key is never looked up
+	ipLimiter.EXPECT().Allow(gomock.Any(), "ip:192.0.2.1").
+		Return(ratelimit.Result{Allowed: false, Limit: 100, RetryAfter: time.Second}, nil)
+
+	router := api.SetupRouter(mocks.NewMockStorage(ctrl), mocks.NewMockGatewayServiceInterface(ctrl), mockLimiter, ipLimiter, "", mocks.NewMockKeyManager(ctrl), mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "guess")
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
+
+func TestRateLimit_CallbacksAreLimitedByIP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockService := mocks.NewMockGatewayServiceInterface(ctrl)
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	ipLimiter := mocks.NewMockLimiter(ctrl)
+	mockVerifier := mocks.NewMockCallbackVerifier(ctrl)
+
+	// keyed on the proxy's entry, not on what the client put before it
+	gomock.InOrder(
+		ipLimiter.EXPECT().Allow(gomock.Any(), "ip:203.0.113.9").Return(ratelimit.Result{Allowed: true, Limit: 100, Remaining: 0}, nil),
+		ipLimiter.EXPECT().Allow(gomock.Any(), "ip:203.0.113.9").Return(ratelimit.Result{Allowed: false, Limit: 100, RetryAfter: time.Second}, nil),
+	)
+	mockDB.EXPECT().GetGatewayByName(gomock.Any(), "stripe").Return(db.Gateway{ID: 1, Name: "Stripe"}, nil)
+	mockVerifier.EXPECT().Verify(gomock.Any(), "Stripe", gomock.Any(), gomock.Any()).Return(signature.Stamp{}, signature.ErrInvalidSignature)
+
+	router := api.SetupRouter(mockDB, mockService, mockLimiter, ipLimiter, "X-Forwarded-For", mocks.NewMockKeyManager(ctrl), mockVerifier, callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mocks.NewMockWebhookStorage(ctrl), mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+
+	for _, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/callbacks/stripe", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.9")
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, want, rec.Code)
+	}
+}
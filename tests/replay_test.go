@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/replay"
+	"payment-gateway/pkg/events"
+)
+
+// expectReplayHistory has transaction 1 go pending -> processing -> completed
+// on gateway 2, and transaction 2 stay pending.
+func expectReplayHistory(store *mocks.MockReplayStorage, filter db.ReplayFilter) {
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	gomock.InOrder(
+		store.EXPECT().ListReplayTransactions(gomock.Any(), filter, 0, 10).Return([]db.Transaction{
+			{ID: 1, UserID: 3, Amount: decimal.RequireFromString("12.50"), Currency: "EUR", Type: "deposit", Status: "completed", GatewayID: 2, CreatedAt: createdAt},
+			{ID: 2, UserID: 3, Amount: decimal.RequireFromString("1"), Currency: "EUR", Type: "withdrawal", Status: "pending", CreatedAt: createdAt},
+		}, nil),
+		store.EXPECT().ListReplayTransactions(gomock.Any(), filter, 2, 10).Return(nil, nil),
+	)
+	store.EXPECT().ListTransactionEvents(gomock.Any(), db.TransactionEventFilter{TransactionID: 1, Limit: 100}).Return([]db.TransactionEvent{
+		{ID: 10, TransactionID: 1, UserID: 3, Status: "pending", CreatedAt: createdAt},
+		{ID: 11, TransactionID: 1, UserID: 3, PreviousStatus: "pending", Status: "processing", GatewayID: 2, CreatedAt: createdAt},
+		{ID: 14, TransactionID: 1, UserID: 3, PreviousStatus: "processing", Status: "completed", GatewayID: 2, CreatedAt: createdAt},
+	}, nil).AnyTimes()
+	store.EXPECT().ListTransactionEvents(gomock.Any(), db.TransactionEventFilter{TransactionID: 2, Limit: 100}).Return([]db.TransactionEvent{
+		{ID: 12, TransactionID: 2, UserID: 3, Status: "pending", CreatedAt: createdAt},
+	}, nil).AnyTimes()
+}
+
+func TestReplayer_RebuildsEventsFromHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	filter := db.ReplayFilter{UserID: 3}
+	store := mocks.NewMockReplayStorage(ctrl)
+	expectReplayHistory(store, filter)
+
+	type published struct {
+		topic, key, eventID string
+		headers             map[string]string
+	}
+	var messages []published
+	mockSink := mocks.NewMockEventSink(ctrl)
+	mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, topic string, key, payload []byte, headers map[string]string) error {
+			envelope, err := events.JSONCodec{}.Unmarshal(payload)
+			assert.NoError(t, err)
+			messages = append(messages, published{topic, string(key), envelope.ID, headers})
+
+			if envelope.Type == events.TypeTransactionCreated {
+				// as announced on creation, not the current state
+				var data events.TransactionCreated
+				assert.NoError(t, envelope.DecodeWith(context.Background(), newTestCipher(t), &data))
+				assert.Equal(t, "pending", data.Status)
+				assert.Equal(t, 0, data.GatewayID)
+				if data.TransactionID == 1 {
+					assert.Equal(t, "12.5", data.Amount)
+				}
+			}
+			return nil
+		}).Times(6)
+
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mockSink)
+	stats, err := replayer.Run(context.Background(), replay.Options{
+		Filter:       filter,
+		CreatedTopic: "backfill",
+		StatusTopic:  "backfill",
+		BatchSize:    10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, replay.Stats{Transactions: 2, Messages: 6, LastTransactionID: 2}, stats)
+
+	// each transaction's events in order, with the IDs of the originals
+	var ids []string
+	for _, message := range messages {
+		ids = append(ids, message.eventID)
+		assert.Equal(t, "backfill", message.topic)
+		assert.NotEmpty(t, message.headers[events.HeaderReplay])
+		assert.NotEmpty(t, message.headers[events.HeaderSchemaID])
+	}
+	assert.Equal(t, []string{
+		"evt_transaction.created_1", "evt_transaction.status_changed_10", "evt_transaction.status_changed_11", "evt_transaction.status_changed_14",
+		"evt_transaction.created_2", "evt_transaction.status_changed_12",
+	}, ids)
+	assert.Equal(t, "1", messages[0].key)
+	assert.Equal(t, "2", messages[5].key)
+	assert.Equal(t, "test-key", messages[0].headers[events.HeaderEncryptionKeyID])
+}
+
+func TestReplayer_DryRunPublishesNothing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockReplayStorage(ctrl)
+	expectReplayHistory(store, db.ReplayFilter{Status: "completed"})
+
+	// only status events were asked for
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mocks.NewMockEventSink(ctrl))
+	stats, err := replayer.Run(context.Background(), replay.Options{
+		Filter:      db.ReplayFilter{Status: "completed"},
+		StatusTopic: "payment-transaction-events",
+		BatchSize:   10,
+		DryRun:      true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, replay.Stats{Transactions: 2, Messages: 4, LastTransactionID: 2}, stats)
+}
+
+func TestReplayer_RateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockReplayStorage(ctrl)
+	expectReplayHistory(store, db.ReplayFilter{})
+	mockSink := mocks.NewMockEventSink(ctrl)
+	mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(4)
+
+	start := time.Now()
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mockSink)
+	_, err := replayer.Run(context.Background(), replay.Options{StatusTopic: "payment-transaction-events", Rate: 50, BatchSize: 10})
+	assert.NoError(t, err)
+
+	// 4 messages at 50 per second are 3 intervals of 20ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 55*time.Millisecond)
+}
+
+func TestReplayer_StopsAtPublishFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockReplayStorage(ctrl)
+	createdAt := time.Now()
+	store.EXPECT().ListReplayTransactions(gomock.Any(), gomock.Any(), 0, 10).Return([]db.Transaction{
+		{ID: 1, UserID: 3, Status: "pending", CreatedAt: createdAt},
+		{ID: 2, UserID: 3, Status: "pending", CreatedAt: createdAt},
+	}, nil)
+	store.EXPECT().ListTransactionEvents(gomock.Any(), gomock.Any()).Return([]db.TransactionEvent{
+		{ID: 10, UserID: 3, Status: "pending", CreatedAt: createdAt},
+	}, nil).Times(2)
+
+	mockSink := mocks.NewMockEventSink(ctrl)
+	gomock.InOrder(
+		mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), []byte("1"), gomock.Any(), gomock.Any()).Return(nil),
+		mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), []byte("2"), gomock.Any(), gomock.Any()).Return(errors.New("kafka down")),
+	)
+
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mockSink)
+	stats, err := replayer.Run(context.Background(), replay.Options{CreatedTopic: "payment-transactions", BatchSize: 10})
+	assert.ErrorContains(t, err, "failed to replay transaction 2")
+	assert.Equal(t, replay.Stats{Transactions: 1, Messages: 1, LastTransactionID: 1}, stats)
+}
+
+func TestReplayer_SkipsTransactionsWithoutHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockReplayStorage(ctrl)
+	createdAt := time.Now()
+	gomock.InOrder(
+		store.EXPECT().ListReplayTransactions(gomock.Any(), gomock.Any(), 0, 10).Return([]db.Transaction{
+			{ID: 1, UserID: 3, Status: "completed", CreatedAt: createdAt},
+			{ID: 2, UserID: 3, Status: "pending", CreatedAt: createdAt},
+		}, nil),
+		store.EXPECT().ListReplayTransactions(gomock.Any(), gomock.Any(), 2, 10).Return(nil, nil),
+	)
+	// transaction 1 predates the history table
+	store.EXPECT().ListTransactionEvents(gomock.Any(), db.TransactionEventFilter{TransactionID: 1, Limit: 100}).Return(nil, nil)
+	store.EXPECT().ListTransactionEvents(gomock.Any(), db.TransactionEventFilter{TransactionID: 2, Limit: 100}).Return([]db.TransactionEvent{
+		{ID: 12, TransactionID: 2, UserID: 3, Status: "pending", CreatedAt: createdAt},
+	}, nil)
+
+	mockSink := mocks.NewMockEventSink(ctrl)
+	mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), []byte("2"), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mockSink)
+	stats, err := replayer.Run(context.Background(), replay.Options{
+		CreatedTopic: "payment-transactions",
+		StatusTopic:  "payment-transaction-events",
+		BatchSize:    10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, replay.Stats{Transactions: 1, Messages: 2, WithoutHistory: 1, LastTransactionID: 2}, stats)
+}
+
+func TestReplayer_RateTooHighIsUnlimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockReplayStorage(ctrl)
+	expectReplayHistory(store, db.ReplayFilter{})
+	mockSink := mocks.NewMockEventSink(ctrl)
+	mockSink.EXPECT().PublishMessage(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(4)
+
+	// more than one message per nanosecond
+	replayer := replay.NewReplayer(store, newEncoder(t, events.JSONCodec{}), mockSink)
+	stats, err := replayer.Run(context.Background(), replay.Options{StatusTopic: "payment-transaction-events", Rate: 1e12, BatchSize: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.Messages)
+}
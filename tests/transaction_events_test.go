@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/stream"
+)
+
+func TestHub_DispatchFiltersAndDropsSlowSubscribers(t *testing.T) {
+	hub := stream.NewHub()
+
+	byTransaction := hub.SubscribeTransaction(7)
+	byUser := hub.SubscribeUser(3)
+	other := hub.SubscribeTransaction(8)
+	defer hub.Unsubscribe(other)
+
+	hub.Dispatch(models.TransactionStatusEvent{ID: 1, TransactionID: 7, UserID: 3, Status: "processing"})
+
+	assert.Equal(t, int64(1), (<-byTransaction.Events).ID)
+	assert.Equal(t, int64(1), (<-byUser.Events).ID)
+	assert.Len(t, other.Events, 0)
+
+	// nobody reads byUser, it is closed once its buffer overflows
+	for i := 0; i < 64; i++ {
+		hub.Dispatch(models.TransactionStatusEvent{ID: int64(i + 2), TransactionID: 9, UserID: 3})
+	}
+
+	count := 0
+	for range byUser.Events {
+		count++
+	}
+	assert.Less(t, count, 64)
+
+	hub.Unsubscribe(byTransaction)
+	hub.Unsubscribe(byUser) // already dropped, must not panic
+}
+
+func newEventStreamServer(ctrl *gomock.Controller, mockDB *mocks.MockStorage, mockEvents *mocks.MockTransactionEventStorage,
+	hub *stream.Hub, identity auth.Identity) *httptest.Server {
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{Allowed: true}, nil).AnyTimes()
+
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "key").Return(identity, nil).AnyTimes()
+
+	return httptest.NewServer(api.SetupRouter(mockDB, mocks.NewMockGatewayServiceInterface(ctrl), mockLimiter, mockLimiter, "", mockKeys,
+		mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl),
+		mocks.NewMockWebhookStorage(ctrl), mockEvents, hub))
+}
+
+// readEvent returns the data of the next SSE event, skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) models.TransactionStatusEvent {
+	var event models.TransactionStatusEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			assert.NoError(t, json.Unmarshal([]byte(data), &event))
+			return event
+		}
+	}
+}
+
+func TestEventStream_ResumesFromHistoryThenStreamsLive(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockEvents := mocks.NewMockTransactionEventStorage(ctrl)
+	hub := stream.NewHub()
+
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), 7).Return(db.Transaction{ID: 7, UserID: 3}, nil)
+	mockEvents.EXPECT().ListTransactionEvents(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, filter db.TransactionEventFilter) ([]db.TransactionEvent, error) {
+			assert.Equal(t, 7, filter.TransactionID)
+			assert.Equal(t, int64(2), filter.AfterID)
+			return []db.TransactionEvent{{ID: 3, TransactionID: 7, UserID: 3, PreviousStatus: "pending", Status: "processing"}}, nil
+		})
+
+	userID := 3
+	server := newEventStreamServer(ctrl, mockDB, mockEvents, hub, auth.Identity{Scopes: []string{auth.ScopeRead}, UserID: &userID})
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/transactions/7/events", nil)
+	req.Header.Set("X-API-Key", "key")
+	req.Header.Set("Last-Event-ID", "2")
+
+	resp, err := server.Client().Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, int64(3), readEvent(t, reader).ID)
+
+	// the replayed event arriving live again is skipped
+	hub.Dispatch(models.TransactionStatusEvent{ID: 3, TransactionID: 7, UserID: 3, Status: "processing"})
+	hub.Dispatch(models.TransactionStatusEvent{ID: 4, TransactionID: 7, UserID: 3, PreviousStatus: "processing", Status: "completed"})
+
+	event := readEvent(t, reader)
+	assert.Equal(t, int64(4), event.ID)
+	assert.Equal(t, "completed", event.Status)
+}
+
+func TestEventStream_OtherUsersTransactionIsForbidden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockDB.EXPECT().GetTransactionByID(gomock.Any(), 7).Return(db.Transaction{ID: 7, UserID: 4}, nil)
+
+	userID := 3
+	server := newEventStreamServer(ctrl, mockDB, mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub(),
+		auth.Identity{Scopes: []string{auth.ScopeRead}, UserID: &userID})
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/transactions/7/events", nil)
+	req.Header.Set("X-API-Key", "key")
+
+	resp, err := server.Client().Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/cache"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/requestid"
+	"payment-gateway/internal/workers"
+)
+
+func TestTransactionProcessor_QueueFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	ctx := context.Background()
+
+	// Workers are not started, so the queue never drains
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, 10*time.Millisecond, mockClient)
+
+	err := processor.ProcessTransaction(ctx, models.Transaction{ID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, processor.QueueDepth())
+
+	start := time.Now()
+	err = processor.ProcessTransaction(ctx, models.Transaction{ID: 2})
+	assert.ErrorIs(t, err, workers.ErrQueueFull)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestTransactionProcessor_ReservationHoldsPlace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	// Workers are not started, so the queue never drains
+	processor := workers.NewTransactionProcessor(mocks.NewMockStorage(ctrl), mocks.NewMockCache(ctrl), mocks.NewMockLocker(ctrl),
+		1, 1, 10*time.Millisecond, mocks.NewMockGatewayClient(ctrl))
+
+	reservation, err := processor.Reserve(ctx)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, processor.ProcessTransaction(ctx, models.Transaction{ID: 2}), workers.ErrQueueFull)
+
+	reservation.Cancel()
+	reservation, err = processor.Reserve(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, reservation.Enqueue(models.Transaction{ID: 1}))
+	assert.Equal(t, 1, processor.QueueDepth())
+}
+
+func TestTransactionProcessor_EnqueueRespectsContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, time.Minute, mockClient)
+
+	assert.NoError(t, processor.ProcessTransaction(context.Background(), models.Transaction{ID: 1}))
+
+	err := processor.ProcessTransaction(ctx, models.Transaction{ID: 2})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTransactionProcessor_FailoverIsRecordedAsReason(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockCache.EXPECT().GetRoute(gomock.Any(), mockDB, 3).Return(cache.Route{Gateways: []db.Gateway{{ID: 1}, {ID: 2}}}, nil)
+	mockClient.EXPECT().ProcessPayment(gomock.Any(), gomock.Any()).Return("", errors.New("gateway down"))
+	mockClient.EXPECT().ProcessPayment(gomock.Any(), gomock.Any()).Return("gateway-txn-2", nil)
+	mockDB.EXPECT().UpdateTransactionGateway(gomock.Any(), 1, 2, int64(1)).Return(nil)
+
+	done := make(chan struct{})
+	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), 1, "processing", "gateway-txn-2", "", workers.ReasonGatewayFailover, int64(1)).
+		DoAndReturn(func(ctx context.Context, _ int, _, _, _, _ string, _ int64) error {
+			// the status event is tagged with the request that created the transaction
+			assert.Equal(t, "req-1", requestid.FromContext(ctx))
+			close(done)
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, time.Second, mockClient)
+	processor.Start(ctx)
+	defer processor.Stop()
+
+	assert.NoError(t, processor.ProcessTransaction(ctx, models.Transaction{ID: 1, UserID: 3, RequestID: "req-1"}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was not marked processing")
+	}
+}
+
+func TestTransactionProcessor_LockFailureRetriesThenFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	// the first attempt and one retry, then the job is not dropped but failed
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(nil, errors.New("lock wait timed out")).Times(2)
+
+	done := make(chan struct{})
+	mockDB.EXPECT().FailPendingTransaction(gomock.Any(), 1, gomock.Any(), workers.ReasonLockUnavailable).
+		DoAndReturn(func(context.Context, int, string, string) error {
+			close(done)
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, time.Second, mockClient)
+	processor.(*workers.Processor).LockRetries = 1
+	processor.(*workers.Processor).LockRetryBackoff = 10 * time.Millisecond
+	processor.Start(ctx)
+	defer processor.Stop()
+
+	assert.NoError(t, processor.ProcessTransaction(ctx, models.Transaction{ID: 1, UserID: 3}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was left pending")
+	}
+}
+
+func TestTransactionProcessor_LockFailureIsRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	gomock.InOrder(
+		mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(nil, errors.New("redis unavailable")),
+		mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil),
+	)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockLease.EXPECT().Release(gomock.Any()).Return(nil)
+	mockCache.EXPECT().GetRoute(gomock.Any(), mockDB, 3).Return(cache.Route{Gateways: []db.Gateway{{ID: 1}}}, nil)
+	mockClient.EXPECT().ProcessPayment(gomock.Any(), gomock.Any()).Return("gateway-txn-1", nil)
+	mockDB.EXPECT().UpdateTransactionGateway(gomock.Any(), 1, 1, int64(1)).Return(nil)
+
+	done := make(chan struct{})
+	mockDB.EXPECT().UpdateTransactionStatus(gomock.Any(), 1, "processing", "gateway-txn-1", "", "", int64(1)).
+		DoAndReturn(func(context.Context, int, string, string, string, string, int64) error {
+			close(done)
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, time.Second, mockClient)
+	processor.(*workers.Processor).LockRetryBackoff = 10 * time.Millisecond
+	processor.Start(ctx)
+	defer processor.Stop()
+
+	assert.NoError(t, processor.ProcessTransaction(ctx, models.Transaction{ID: 1, UserID: 3}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was not processed after the retry")
+	}
+}
+
+func TestTransactionProcessor_StaleTokenStopsProcessing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := mocks.NewMockStorage(ctrl)
+	mockCache := mocks.NewMockCache(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
+	mockLease := mocks.NewMockLease(ctrl)
+	mockClient := mocks.NewMockGatewayClient(ctrl)
+
+	mockLocker.EXPECT().Acquire(gomock.Any(), 1).Return(mockLease, nil)
+	mockLease.EXPECT().Token().Return(int64(1)).AnyTimes()
+	mockCache.EXPECT().GetRoute(gomock.Any(), mockDB, 3).Return(cache.Route{Gateways: []db.Gateway{{ID: 1}}}, nil)
+	mockClient.EXPECT().ProcessPayment(gomock.Any(), gomock.Any()).Return("gateway-txn-1", nil)
+	mockDB.EXPECT().UpdateTransactionGateway(gomock.Any(), 1, 1, int64(1)).
+		Return(fmt.Errorf("%w: 1 < 2", db.ErrStaleFencingToken))
+	// the lease is lost, the processing status must not be written
+
+	done := make(chan struct{})
+	mockLease.EXPECT().Release(gomock.Any()).DoAndReturn(func(context.Context) error {
+		close(done)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := workers.NewTransactionProcessor(mockDB, mockCache, mockLocker, 1, 1, time.Second, mockClient)
+	processor.Start(ctx)
+	defer processor.Stop()
+
+	assert.NoError(t, processor.ProcessTransaction(ctx, models.Transaction{ID: 1, UserID: 3}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was not processed")
+	}
+}
@@ -24,7 +24,7 @@ func TestGetTransactionStatus_Success(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -44,7 +44,7 @@ func TestGetTransactionStatus_Success(t *testing.T) {
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(expectedTx, nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	tx, err := service.GetTransactionStatus(ctx, txID)
 
@@ -60,7 +60,7 @@ func TestGetTransactionStatus_NotFound(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	txID := 999 // Non-existent transaction
@@ -68,7 +68,7 @@ func TestGetTransactionStatus_NotFound(t *testing.T) {
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(db.Transaction{}, fmt.Errorf("transaction not found"))
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	_, err := service.GetTransactionStatus(ctx, txID)
 
@@ -82,7 +82,7 @@ func TestGetTransactionStatus_WithGatewayInfo(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -104,7 +104,7 @@ func TestGetTransactionStatus_WithGatewayInfo(t *testing.T) {
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(expectedTx, nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	tx, err := service.GetTransactionStatus(ctx, txID)
 
@@ -122,7 +122,7 @@ func TestGetTransactionStatus_FailedTransaction(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -143,7 +143,7 @@ func TestGetTransactionStatus_FailedTransaction(t *testing.T) {
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(expectedTx, nil)
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	tx, err := service.GetTransactionStatus(ctx, txID)
 
@@ -160,7 +160,7 @@ func TestGetTransactionStatus_DatabaseError(t *testing.T) {
 	mockDB := mocks.NewMockStorage(ctrl)
 	mockCache := mocks.NewMockCache(ctrl)
 	mockProcessor := mocks.NewMockTransactionProcessor(ctrl)
-	mockKafka := mocks.NewMockProducer(ctrl)
+	mockLocker := mocks.NewMockLocker(ctrl)
 
 	ctx := context.Background()
 	txID := 1
@@ -168,7 +168,7 @@ func TestGetTransactionStatus_DatabaseError(t *testing.T) {
 	mockDB.EXPECT().GetTransactionByID(gomock.Any(), txID).Return(db.Transaction{}, fmt.Errorf("database connection error"))
 
 	cfg := envs.Load()
-	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockKafka, cfg)
+	service := services.NewGateway(mockDB, mockCache, mockProcessor, mockLocker, nil, cfg)
 
 	_, err := service.GetTransactionStatus(ctx, txID)
 
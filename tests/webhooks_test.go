@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"payment-gateway/tests/mocks"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"payment-gateway/db"
+	"payment-gateway/internal/api"
+	"payment-gateway/internal/auth"
+	"payment-gateway/internal/callback"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/signature"
+	"payment-gateway/internal/stream"
+	"payment-gateway/internal/webhook"
+	"payment-gateway/internal/workers"
+)
+
+func TestWebhookStatusEvent_BuildsCompletedEvent(t *testing.T) {
+	event, ok, err := webhook.StatusEvent(context.Background(), db.Transaction{
+		ID: 7, UserID: 3, Amount: decimal.NewFromInt(10), Currency: "USD", Type: "deposit", Status: "completed",
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "evt_transaction.completed_7", event.ID)
+	assert.Equal(t, webhook.EventTransactionCompleted, event.Type)
+	assert.Equal(t, 3, event.UserID)
+	assert.Equal(t, 7, *event.TransactionID)
+
+	var payload webhook.Event
+	assert.NoError(t, json.Unmarshal(event.Payload, &payload))
+	assert.Equal(t, event.ID, payload.ID)
+	assert.Equal(t, 7, payload.Data.TransactionID)
+	assert.Equal(t, "completed", payload.Data.Status)
+}
+
+func TestWebhookStatusEvent_BuildsFailedEvent(t *testing.T) {
+	event, ok, err := webhook.StatusEvent(context.Background(), db.Transaction{
+		ID: 1, UserID: 3, Status: "failed", ErrorMessage: "no route", ReasonCode: workers.ReasonNoRoute,
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, webhook.EventTransactionFailed, event.Type)
+
+	var payload webhook.Event
+	assert.NoError(t, json.Unmarshal(event.Payload, &payload))
+	assert.Equal(t, "failed", payload.Data.Status)
+	assert.Equal(t, workers.ReasonNoRoute, payload.Data.ReasonCode)
+}
+
+func TestWebhookStatusEvent_IgnoresNonFinalStatus(t *testing.T) {
+	_, ok, err := webhook.StatusEvent(context.Background(), db.Transaction{ID: 7, Status: "processing"})
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWebhookDispatcher_DeliversSignedPayload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	payload := []byte(`{"id":"evt_transaction.completed_7"}`)
+	secret := "whsec_test"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, err := signature.HMACScheme{}.Verify(r.Header, body, secret)
+		assert.NoError(t, err)
+		assert.Equal(t, "evt_transaction.completed_7", r.Header.Get("X-Webhook-Event-ID"))
+		assert.Equal(t, webhook.EventTransactionCompleted, r.Header.Get("X-Webhook-Event-Type"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mockStore := mocks.NewMockWebhookStorage(ctrl)
+	mockStore.EXPECT().ClaimWebhookDeliveries(gomock.Any(), 10, gomock.Any()).Return([]db.WebhookDelivery{{
+		ID: 1, SubscriptionID: 2, URL: server.URL, Secret: secret,
+		EventID: "evt_transaction.completed_7", EventType: webhook.EventTransactionCompleted, Payload: payload, Attempts: 1,
+	}}, nil)
+	mockStore.EXPECT().MarkWebhookDelivered(gomock.Any(), 1, http.StatusNoContent).Return(nil)
+
+	dispatcher := webhook.NewDispatcher(mockStore, server.Client(), time.Second, 10, 3, time.Second)
+	dispatcher.Poll(context.Background())
+}
+
+func TestWebhookDispatcher_RetriesThenGivesUp(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	mockStore := mocks.NewMockWebhookStorage(ctrl)
+	mockStore.EXPECT().ClaimWebhookDeliveries(gomock.Any(), 10, gomock.Any()).Return([]db.WebhookDelivery{
+		{ID: 1, URL: server.URL, Secret: "s", Payload: []byte(`{}`), Attempts: 1},
+		{ID: 2, URL: server.URL, Secret: "s", Payload: []byte(`{}`), Attempts: 3},
+	}, nil)
+
+	statusCode := http.StatusInternalServerError
+	mockStore.EXPECT().MarkWebhookFailed(gomock.Any(), 1, &statusCode, gomock.Any(), gomock.Any(), false).Return(nil)
+	mockStore.EXPECT().MarkWebhookFailed(gomock.Any(), 2, &statusCode, gomock.Any(), gomock.Any(), true).Return(nil)
+
+	dispatcher := webhook.NewDispatcher(mockStore, server.Client(), time.Second, 10, 3, time.Second)
+	dispatcher.Poll(context.Background())
+}
+
+func newWebhookTestRouter(ctrl *gomock.Controller, mockStore *mocks.MockWebhookStorage, identity auth.Identity) http.Handler {
+	mockLimiter := mocks.NewMockLimiter(ctrl)
+	mockLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).Return(ratelimit.Result{Allowed: true}, nil).AnyTimes()
+
+	mockKeys := mocks.NewMockKeyManager(ctrl)
+	mockKeys.EXPECT().Authenticate(gomock.Any(), "key").Return(identity, nil).AnyTimes()
+
+	return api.SetupRouter(mocks.NewMockStorage(ctrl), mocks.NewMockGatewayServiceInterface(ctrl), mockLimiter, mockLimiter, "", mockKeys,
+		mocks.NewMockCallbackVerifier(ctrl), callback.NewParser(nil), mocks.NewMockCallbackInboxStorage(ctrl), mockStore,
+		mocks.NewMockTransactionEventStorage(ctrl), stream.NewHub())
+}
+
+func newWebhookRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "key")
+	return req
+}
+
+func TestWebhookHandler_CreateBindsToKeyUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userID := 5
+	mockStore := mocks.NewMockWebhookStorage(ctrl)
+	mockStore.EXPECT().CreateWebhookSubscription(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, sub db.WebhookSubscription) (int, error) {
+			assert.Equal(t, &userID, sub.UserID)
+			assert.True(t, strings.HasPrefix(sub.Secret, "whsec_"))
+			return 1, nil
+		})
+
+	router := newWebhookTestRouter(ctrl, mockStore, auth.Identity{Scopes: []string{auth.ScopeWebhooks}, UserID: &userID})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newWebhookRequest(http.MethodPost, "/webhooks",
+		`{"url": "https://merchant.example/hooks", "event_types": ["transaction.completed", "refund.succeeded"]}`))
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"secret":"whsec_`)
+}
+
+func TestWebhookHandler_CreateRejectsUnknownEventType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	router := newWebhookTestRouter(ctrl, mocks.NewMockWebhookStorage(ctrl), auth.Identity{Scopes: []string{auth.ScopeWebhooks}})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newWebhookRequest(http.MethodPost, "/webhooks",
+		`{"url": "https://merchant.example/hooks", "event_types": ["transaction.exploded"]}`))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWebhookHandler_CreateRejectsInternalEndpoints(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// CreateWebhookSubscription must not be called
+	router := newWebhookTestRouter(ctrl, mocks.NewMockWebhookStorage(ctrl), auth.Identity{Scopes: []string{auth.ScopeWebhooks}})
+
+	for _, endpoint := range []string{
+		"http://merchant.example/hooks",
+		"https://localhost/hooks",
+		"https://127.0.0.1/hooks",
+		"https://10.0.0.5/hooks",
+		"https://192.168.1.1:8443/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hooks",
+		"https://[fe80::1]/hooks",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newWebhookRequest(http.MethodPost, "/webhooks",
+			`{"url": "`+endpoint+`", "event_types": ["transaction.completed"]}`))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, endpoint)
+	}
+}
+
+func TestWebhookClient_RefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached an internal address")
+	}))
+	defer server.Close()
+
+	client := webhook.NewClient(time.Second)
+
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, webhook.ErrEndpointNotAllowed)
+
+	_, err = client.Get(strings.Replace(server.URL, "https://", "http://", 1))
+	assert.ErrorIs(t, err, webhook.ErrEndpointNotAllowed)
+}
+
+func TestWebhookHandler_OtherUsersSubscriptionIsHidden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userID, otherUser := 5, 6
+	mockStore := mocks.NewMockWebhookStorage(ctrl)
+	mockStore.EXPECT().GetWebhookSubscription(gomock.Any(), 1).Return(db.WebhookSubscription{ID: 1, UserID: &otherUser}, nil)
+	// ListWebhookDeliveries must not be called
+
+	router := newWebhookTestRouter(ctrl, mockStore, auth.Identity{Scopes: []string{auth.ScopeWebhooks}, UserID: &userID})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newWebhookRequest(http.MethodGet, "/webhooks/1/deliveries", ""))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestWebhookHandler_Redeliver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStore := mocks.NewMockWebhookStorage(ctrl)
+	mockStore.EXPECT().GetWebhookDelivery(gomock.Any(), 9).Return(db.WebhookDelivery{ID: 9, SubscriptionID: 1, Status: db.WebhookStatusDead}, nil)
+	mockStore.EXPECT().GetWebhookSubscription(gomock.Any(), 1).Return(db.WebhookSubscription{ID: 1}, nil)
+	mockStore.EXPECT().RedeliverWebhook(gomock.Any(), 9).Return(nil)
+
+	router := newWebhookTestRouter(ctrl, mockStore, auth.Identity{Scopes: []string{auth.ScopeAdmin}})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newWebhookRequest(http.MethodPost, "/webhook-deliveries/9/redeliver", ""))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}